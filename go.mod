@@ -1,3 +1,6 @@
 module github.com/SgtDaJim/op-mtr
 
-require github.com/pixelbender/go-traceroute v0.0.0-20190414152342-e631ab553a80
+require (
+	github.com/pixelbender/go-traceroute v0.0.0-20190414152342-e631ab553a80
+	golang.org/x/net v0.0.0-20190125091013-d26f9f9a57f3
+)
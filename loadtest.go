@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// runLoadtest implements `op-mtr loadtest`: it runs MTRs against the given
+// targets with doubling concurrency and reports what the host sustained.
+func runLoadtest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	maxConc := fs.Int("max", 64, "highest number of concurrent runs")
+	count := fs.Int("count", 5, "pings per hop")
+	simulate := fs.Bool("simulate", false, "probe the loopback address instead of real targets")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s loadtest [flags] <dst>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	targets := fs.Args()
+	if *simulate {
+		targets = []string{"127.0.0.1"}
+	}
+	if len(targets) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fmt.Printf("%6s  %8s  %8s  %8s  %8s  %8s\n", "Conc", "Probes", "Dropped", "Probe/s", "CPU", "HeapMB")
+	for conc := 1; conc <= *maxConc; conc *= 2 {
+		opmtr, err := mtr.NewOPMTR("0.0.0.0", mtr.WithPingCount(*count))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cpu := cpuTime()
+		start := time.Now()
+		var mu sync.Mutex
+		var probes, dropped int
+		var wg sync.WaitGroup
+		for i := 0; i < conc; i++ {
+			wg.Add(1)
+			go func(dst string) {
+				defer wg.Done()
+				r, err := opmtr.Run(dst)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, h := range r.Hups {
+					if h.Host == "???" {
+						continue
+					}
+					probes += int(h.Snt)
+					dropped += h.LossPoint
+				}
+			}(targets[i%len(targets)])
+		}
+		wg.Wait()
+		elapsed := time.Since(start)
+		opmtr.Close()
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		fmt.Printf("%6d  %8d  %8d  %8.1f  %8s  %8.1f\n",
+			conc,
+			probes,
+			dropped,
+			float64(probes)/elapsed.Seconds(),
+			(cpuTime() - cpu).Round(time.Millisecond),
+			float64(ms.HeapAlloc)/(1<<20),
+		)
+	}
+}
@@ -1,29 +1,390 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/SgtDaJim/op-mtr/mtr"
 )
 
 func main() {
-	opmtr, err1 := mtr.NewOPMTR("0.0.0.0", 30, 20, 5, time.Second*1)
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		runLoadtest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+
+	chaos := flag.String("chaos", "", "")
+	prefer6 := flag.Bool("6", false, "prefer IPv6 when the destination is a hostname")
+	udp := flag.Bool("u", false, "use UDP datagrams instead of ICMP echo")
+	tcp := flag.Bool("T", false, "use TCP SYN packets instead of ICMP echo")
+	backend := flag.String("backend", "", "ICMP sockets: raw, or datagram for unprivileged ping sockets (default: detect)")
+	port := flag.Int("P", mtr.DefaultTCPPort, "destination port for -T")
+	size := flag.Int("s", 0, "probe payload size in bytes, e.g. 1472 for 1500 byte IPv4 packets (default: per probe mode)")
+	pattern := flag.String("pattern", "", "hex bytes the probe payload repeats, e.g. ff or deadbeef (default: zeros)")
+	interval := flag.Duration("i", 0, "least time between the pings of a hop, e.g. 200ms")
+	rate := flag.Float64("rate", 0, "probes per second for all hops together, 0 for no limit")
+	rateBurst := flag.Int("rate-burst", 1, "probes that may leave at once within -rate")
+	jitter := flag.Duration("jitter", 0, "delay every ping by a random duration up to this, e.g. 50ms")
+	shuffle := flag.Bool("shuffle", false, "ping the hops in random order")
+	seed := flag.Int64("seed", 0, "seed of -jitter and -shuffle, to reproduce the run reporting it (default: random)")
+	flow := flag.String("flow", "", "paris to keep probes on one ECMP path, enumerate to discover all paths")
+	numeric := flag.Bool("n", false, "show hop addresses without reverse DNS lookups")
+	showMPLS := flag.Bool("e", false, "show the MPLS label stacks hops report, like mtr -e")
+	hopName := flag.String("hop-name", "", "text/template naming hops in all output, e.g. '{{.ASN}} {{.Hostname | default .IP}}'")
+	showASN := flag.Bool("z", false, "show the AS number of each hop, looked up with Team Cymru DNS")
+	asnDB := flag.String("asn-db", "", "GeoLite2-ASN .mmdb, or CSV file of network,asn,name rows, for offline -z lookups")
+	geoDB := flag.String("geoip", "", "GeoLite2-City .mmdb file to locate each hop with")
+	geoJSON := flag.Bool("geojson", false, "print the path as GeoJSON, needs -geoip")
+	runAs := flag.String("user", "", "drop privileges to this user once the sockets are open")
+	anonymize := flag.String("anonymize", "", "anonymize hop addresses: truncate or hash")
+	anonKey := flag.String("anonymize-key", "", "HMAC key for -anonymize hash")
+	targets := flag.String("targets", "", "JSON file of target groups to measure instead of <dst>")
+	sample := flag.Int("sample", 1, "with -budget, print only every Nth report of each target")
+	budget := flag.Float64("budget", 0, "with -targets, probe continuously at this many packets per second, favoring heavy and unhealthy targets")
+	dnsName := flag.String("dns", "", "also query <dst> as a DNS resolver for this name")
+	dnsType := flag.String("dns-type", "A", "query type for -dns")
+	dnsExpect := flag.String("dns-expect", "", "comma-separated values the -dns answer must contain")
+	ntp := flag.Bool("ntp", false, "also query <dst> as an NTP server")
+	baseline := flag.Bool("baseline", false, "also ping <dst> end to end and report it, to tell ICMP rate limiting at hops from path loss")
+	notes := flag.String("notes", "", "JSON file of operator notes on destinations and hops")
+	csvOut := flag.Bool("csv", false, "print the report as CSV")
+	influx := flag.Bool("influx", false, "print the report as InfluxDB line protocol, one point per hop")
+	keepSamples := flag.Int("samples", 0, "keep up to this many raw RTT samples per hop in the report")
+	segments := flag.Bool("segments", false, "print the latency each path segment adds")
+	wide := flag.Bool("report-wide", false, "print the report like mtr --report-wide")
+	locale := flag.String("locale", "", "format numbers and times in reports for this language, e.g. de or fr-CH (default: Go formatting)")
+	live := flag.Bool("t", false, "show a live hop table refreshed every cycle, like mtr")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 && *targets == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	switch *backend {
+	case "":
+		if _, err := mtr.DetectBackend(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case mtr.BackendRaw, mtr.BackendDatagram:
+	default:
+		fmt.Fprintln(os.Stderr, "-backend must be raw or datagram")
+		os.Exit(2)
+	}
+	if *interval < 0 || *rate < 0 {
+		fmt.Fprintln(os.Stderr, "-i and -rate must not be negative")
+		os.Exit(2)
+	}
+	if *size < 0 || *size > mtr.MaxPayloadSize {
+		fmt.Fprintf(os.Stderr, "-s must be between 0 and %d\n", mtr.MaxPayloadSize)
+		os.Exit(2)
+	}
+	var loc mtr.Locale
+	if *locale != "" {
+		var ok bool
+		if loc, ok = mtr.LookupLocale(*locale); !ok {
+			fmt.Fprintf(os.Stderr, "-locale: unknown language %q\n", *locale)
+			os.Exit(2)
+		}
+	}
+	anon := mtr.Anonymizer{Mode: *anonymize, Key: []byte(*anonKey)}
+	if err := anon.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fill, err := hex.DecodeString(*pattern)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-pattern must be hex bytes")
+		os.Exit(2)
+	}
+
+	opmtr, err1 := mtr.NewOPMTR("0.0.0.0")
 	if err1 != nil {
 		fmt.Println(err1)
 		return
 	}
-	r, err := opmtr.RunMTRWithCocurrentPing(os.Args[1])
-	j, err2 := r.ToJSON()
+	opmtr.Logger = mtr.StdLogger(log.New(os.Stderr, "", log.LstdFlags), mtr.LevelWarn)
+	opmtr.Backend = *backend
+	if *prefer6 {
+		opmtr.Prefer = mtr.PreferIPv6
+	}
+	if *udp {
+		opmtr.ProbeMode = mtr.ProbeUDP
+	}
+	if *tcp {
+		opmtr.ProbeMode = mtr.ProbeTCP
+		opmtr.TCPPort = *port
+	}
+	opmtr.Flow = *flow
+	opmtr.MPLS = *showMPLS
+	opmtr.PayloadSize, opmtr.PayloadPattern = *size, fill
+	opmtr.ProbeJitter, opmtr.Shuffle, opmtr.Seed = *jitter, *shuffle, *seed
+	opmtr.Interval = *interval
+	if *rate > 0 {
+		opmtr.RateLimit = mtr.NewRateLimiter(*rate, *rateBurst)
+	}
+	opmtr.KeepSamples = *keepSamples
+	if *hopName != "" {
+		t, err := mtr.ParseHopTemplate(*hopName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-hop-name:", err)
+			os.Exit(2)
+		}
+		opmtr.HopNames = t
+	}
+	if !*numeric {
+		opmtr.PTR = mtr.NewPTRResolver(2*time.Second, mtr.DefaultPTRCacheSize)
+	}
+	if strings.HasSuffix(*asnDB, ".mmdb") {
+		db, err := mtr.OpenMMDB(*asnDB)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opmtr.ASN = db
+	} else if *asnDB != "" {
+		f, err := os.Open(*asnDB)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		t, err := mtr.LoadASNTable(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opmtr.ASN = t
+	} else if *showASN {
+		opmtr.ASN = &mtr.CymruASN{}
+	}
+	if *dnsName != "" {
+		opmtr.DNS = &mtr.DNSProbe{Name: *dnsName, Type: *dnsType}
+		if *dnsExpect != "" {
+			opmtr.DNS.Expect = strings.Split(*dnsExpect, ",")
+		}
+	}
+	opmtr.Baseline = *baseline
+	if *ntp {
+		opmtr.NTP = &mtr.NTPProbe{}
+	}
+	if *geoDB != "" {
+		db, err := mtr.OpenMMDB(*geoDB)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opmtr.Geo = db
+	}
+	if *notes != "" {
+		nb, err := mtr.LoadNoteBook(*notes)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opmtr.Notes = nb
+	}
+	if *runAs != "" {
+		if err := opmtr.Open(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := mtr.DropPrivileges(*runAs); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if *chaos != "" {
+		f, err := parseFaults(*chaos)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opmtr.Faults = f
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *targets != "" && *budget > 0 {
+		runScheduled(ctx, opmtr, *targets, *budget, *sample, loc)
+		return
+	}
+	if *targets != "" {
+		runGroups(ctx, opmtr, *targets, loc)
+		return
+	}
+	if *live {
+		if err := runTUI(ctx, opmtr, flag.Arg(0), loc); err != nil && ctx.Err() == nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	r, err := opmtr.RunContext(ctx, flag.Arg(0))
+	if *anonymize != "" {
+		// anon is valid, checked above
+		r, _ = anon.Apply(r)
+	}
+	if *wide {
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Print(r.ToReport())
+		return
+	}
+	if *segments {
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Print(r.LatencyBudget(loc))
+		return
+	}
+	if *geoJSON {
+		if err != nil {
+			fmt.Println(err)
+		}
+		g, err := r.ToGeoJSON()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(g)
+		return
+	}
+	if *csvOut {
+		if err != nil {
+			fmt.Println(err)
+		}
+		if err := r.ToCSV(os.Stdout); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	if *influx {
+		// keep stdout clean for Telegraf
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		fmt.Print(r.ToLineProtocol())
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 	} else {
-		r.PrettyPrint()
+		r.Fprint(os.Stdout, loc)
 	}
-	if err2 != nil {
-		fmt.Println(err2)
-	} else {
-		fmt.Println(j)
+	if err := r.WriteJSON(os.Stdout); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println()
+}
+
+// runGroups measures every target of the groups in path and prints each
+// report followed by per-group summaries.
+func runGroups(ctx context.Context, opmtr *mtr.OPMTR, path string, l mtr.Locale) {
+	groups, err := mtr.LoadGroups(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var summaries []mtr.GroupSummary
+	var health []float64
+	// score path stability against the paths seen so far
+	if opmtr.PathDB == nil {
+		opmtr.PathDB = mtr.NewPathDB()
+	}
+	scorer := mtr.HealthScorer{PathDB: opmtr.PathDB}
+	for _, g := range groups {
+		var reports []mtr.MTRReport
+		for _, t := range g.Expand() {
+			r, err := opmtr.RunTarget(ctx, t)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			r.Fprint(os.Stdout, l)
+			reports = append(reports, r)
+		}
+		summaries = append(summaries, mtr.SummarizeGroup(g.Name, reports))
+		health = append(health, scorer.GroupScore(reports))
+	}
+	fmt.Printf("%-20s %7s  %-20s %6s  %6s  %7s  %6s\n", "Group", "Targets", "Worst", "Loss%", "Avg%", "AvgRTT", "Health")
+	for i, s := range summaries {
+		fmt.Printf("%-20s %7d  %-20s %5s%%  %5s%%  %7s  %6s\n",
+			s.Group, s.Targets, s.WorstDst, l.Float(s.WorstLoss*100, 1), l.Float(s.AvgLoss*100, 1), l.Float(s.AvgRTT, 1), l.Float(health[i], 1))
+	}
+}
+
+// runScheduled measures the targets of the groups in path continuously
+// within budget packets per second, printing every sample-th report of each
+// target, until ctx is done.
+func runScheduled(ctx context.Context, opmtr *mtr.OPMTR, path string, budget float64, sample int, l mtr.Locale) {
+	groups, err := mtr.LoadGroups(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var targets []mtr.Target
+	for _, g := range groups {
+		targets = append(targets, g.Expand()...)
+	}
+	if opmtr.PathDB == nil {
+		opmtr.PathDB = mtr.NewPathDB()
+	}
+	s := mtr.NewScheduler(targets, budget)
+	s.Scorer = mtr.HealthScorer{PathDB: opmtr.PathDB}
+	for r := range mtr.SampleReports(s.Run(ctx, opmtr), sample, nil) {
+		r.Fprint(os.Stdout, l)
+	}
+}
+
+// usage prints the flag defaults, leaving out the hidden -chaos flag.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <dst>\n       %s loadtest [flags] <dst>...\n       %s serve [flags]\n", os.Args[0], os.Args[0], os.Args[0])
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "chaos" {
+			return
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  -%s\n    \t%s (default %q)\n", f.Name, f.Usage, f.DefValue)
+	})
+}
+
+// parseFaults parses a -chaos spec like "drop=0.1,error=0.05,delay=50ms,seed=1".
+func parseFaults(spec string) (*mtr.FaultConfig, error) {
+	f := &mtr.FaultConfig{}
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid chaos option %q", kv)
+		}
+		var err error
+		switch parts[0] {
+		case "drop":
+			f.DropRate, err = strconv.ParseFloat(parts[1], 64)
+		case "error":
+			f.ErrorRate, err = strconv.ParseFloat(parts[1], 64)
+		case "delay":
+			f.Delay, err = time.ParseDuration(parts[1])
+		case "seed":
+			f.Seed, err = strconv.ParseInt(parts[1], 10, 64)
+		default:
+			err = fmt.Errorf("unknown chaos option %q", parts[0])
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	return f, nil
 }
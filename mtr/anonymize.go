@@ -0,0 +1,161 @@
+package mtr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Anonymization modes.
+const (
+	AnonymizeTruncate = "truncate" // zero the host bits: 10.1.2.3 -> 10.1.2.0
+	AnonymizeHash     = "hash"     // prefix plus a keyed hash: 10.1.2.0#1a2b3c4d
+)
+
+// Anonymizer rewrites addresses in a report so it can be shared without
+// exposing internal addressing, while keeping prefix-level information.
+type Anonymizer struct {
+	Mode     string // AnonymizeTruncate if empty
+	PrefixV4 int    // bits kept for IPv4, 24 if zero
+	PrefixV6 int    // bits kept for IPv6, 48 if zero
+	Key      []byte // HMAC key for AnonymizeHash, required
+}
+
+// Validate checks the mode, and that AnonymizeHash has a key: hashes
+// without a secret key are reversed by hashing every address of a prefix.
+func (a Anonymizer) Validate() error {
+	switch a.Mode {
+	case "", AnonymizeTruncate:
+		return nil
+	case AnonymizeHash:
+		if len(a.Key) == 0 {
+			return errors.New("anonymize: hash mode needs a key")
+		}
+		return nil
+	}
+	return fmt.Errorf("anonymize: unknown mode %q, want %s or %s", a.Mode, AnonymizeTruncate, AnonymizeHash)
+}
+
+// Apply returns a copy of r with Src, hup, DNS and NTP server addresses
+// anonymized, along with the addresses quoted in errors and local events.
+// Dst, and the hup or server answering as Dst, are kept since they name the
+// measured target.
+func (a Anonymizer) Apply(r MTRReport) (MTRReport, error) {
+	if err := a.Validate(); err != nil {
+		return r, err
+	}
+	n := r
+	n.Src = a.Address(r.Src)
+	n.Hups = make([]MTRHup, len(r.Hups))
+	for i, h := range r.Hups {
+		h.Hosts = append([]HopHost(nil), h.Hosts...)
+		for j := range h.Hosts {
+			if h.Hosts[j].Host != r.Dst {
+				h.Hosts[j].Host = a.Address(h.Hosts[j].Host)
+			}
+		}
+		if h.Host != r.Dst {
+			h.Host = a.Address(h.Host)
+			h.Hostname, h.Display = "", ""
+			h.Iface, h.Location = "", ""
+			h.Geo = nil
+		}
+		n.Hups[i] = h
+	}
+	if r.Errors != nil {
+		n.Errors = make([]MTRRunError, len(r.Errors))
+		for i, e := range r.Errors {
+			e.Error = a.text(e.Error, r.Dst)
+			n.Errors[i] = e
+		}
+	}
+	if r.LocalEvents != nil {
+		n.LocalEvents = make([]LocalEvent, len(r.LocalEvents))
+		for i, e := range r.LocalEvents {
+			e.Detail, e.Prefix = a.text(e.Detail, r.Dst), a.text(e.Prefix, r.Dst)
+			n.LocalEvents[i] = e
+		}
+	}
+	if r.DNS != nil {
+		d := *r.DNS
+		if d.Server != r.Dst {
+			d.Server = a.Address(d.Server)
+		}
+		d.Error = a.text(d.Error, r.Dst)
+		d.Answers = make([]string, len(r.DNS.Answers))
+		for i, s := range r.DNS.Answers {
+			d.Answers[i] = a.text(s, r.Dst)
+		}
+		n.DNS = &d
+	}
+	if r.NTP != nil {
+		t := *r.NTP
+		if t.Server != r.Dst {
+			t.Server = a.Address(t.Server)
+		}
+		t.Error = a.text(t.Error, r.Dst)
+		n.NTP = &t
+	}
+	return n, nil
+}
+
+// addrToken matches runs of characters an address can be written with,
+// with at least one separator.
+var addrToken = regexp.MustCompile(`[0-9A-Fa-f:.]*[:.][0-9A-Fa-f:.]*`)
+
+// text anonymizes the addresses in free text such as an error message,
+// except keep. Addresses may carry a port, "10.0.0.1:53", or end a
+// sentence.
+func (a Anonymizer) text(s, keep string) string {
+	return addrToken.ReplaceAllStringFunc(s, func(t string) string {
+		host := strings.TrimRight(t, ".:")
+		rest := t[len(host):]
+		if net.ParseIP(host) == nil {
+			i := strings.LastIndexByte(host, ':')
+			if i < 0 || net.ParseIP(host[:i]).To4() == nil {
+				return t
+			}
+			host, rest = host[:i], host[i:]+rest
+		}
+		if host == keep {
+			return t
+		}
+		return a.Address(host) + rest
+	})
+}
+
+// Address anonymizes a single address. Anything that is not an IP, like
+// "???", is returned unchanged. It doesn't Validate a.
+func (a Anonymizer) Address(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	var mask net.IPMask
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		bits := a.PrefixV4
+		if bits == 0 {
+			bits = 24
+		}
+		mask = net.CIDRMask(bits, 32)
+	} else {
+		bits := a.PrefixV6
+		if bits == 0 {
+			bits = 48
+		}
+		mask = net.CIDRMask(bits, 128)
+	}
+	prefix := ip.Mask(mask).String()
+	if a.Mode != AnonymizeHash {
+		return prefix
+	}
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write(ip)
+	return prefix + "#" + hex.EncodeToString(mac.Sum(nil)[:4])
+}
@@ -0,0 +1,78 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymizerValidate(t *testing.T) {
+	tests := []struct {
+		a  Anonymizer
+		ok bool
+	}{
+		{Anonymizer{}, true},
+		{Anonymizer{Mode: AnonymizeTruncate}, true},
+		{Anonymizer{Mode: AnonymizeHash, Key: []byte("k")}, true},
+		{Anonymizer{Mode: AnonymizeHash}, false},
+		{Anonymizer{Mode: "hashed", Key: []byte("k")}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.a.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: Validate = %v", tt.a, err)
+		}
+		if _, err := tt.a.Apply(MTRReport{}); (err == nil) != tt.ok {
+			t.Errorf("%+v: Apply = %v", tt.a, err)
+		}
+	}
+}
+
+func TestAnonymizerApply(t *testing.T) {
+	r := MTRReport{
+		Src: "192.168.1.5",
+		Dst: "8.8.8.8",
+		Hups: []MTRHup{
+			{Count: 1, Host: "192.168.1.1", Hostname: "gw.lan", Hosts: []HopHost{{Host: "192.168.1.1"}, {Host: "192.168.1.2"}}},
+			{Count: 2, Host: "???"},
+			{Count: 3, Host: "8.8.8.8", Hostname: "dns.google"},
+		},
+		Errors:      []MTRRunError{{Hop: 1, Error: "write ip4 192.168.1.5->192.168.1.1: no buffer space"}},
+		LocalEvents: []LocalEvent{{Kind: LocalEventRoute, Detail: "route 10.20.0.0/16 added via 192.168.1.254.", Prefix: "10.20.0.0/16"}},
+		DNS:         &DNSResult{Server: "8.8.8.8", Error: "read udp 192.168.1.5:41234->8.8.8.8:53: i/o timeout", Answers: []string{"10.20.30.40"}},
+		NTP:         &NTPResult{Server: "8.8.8.8", Error: "dial udp [fd00:1:2:3::5]:123: no route"},
+	}
+	n, err := Anonymizer{}.Apply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Src != "192.168.1.0" || n.Hups[0].Host != "192.168.1.0" || n.Hups[0].Hostname != "" || n.Hups[0].Hosts[1].Host != "192.168.1.0" {
+		t.Errorf("hop 1 %+v, Src %s", n.Hups[0], n.Src)
+	}
+	if n.Hups[1].Host != "???" || n.Hups[2].Host != "8.8.8.8" || n.Hups[2].Hostname != "dns.google" {
+		t.Errorf("hops 2 and 3 changed: %+v", n.Hups[1:])
+	}
+	for _, c := range []struct{ got, want string }{
+		{n.Errors[0].Error, "write ip4 192.168.1.0->192.168.1.0: no buffer space"},
+		{n.LocalEvents[0].Detail, "route 10.20.0.0/16 added via 192.168.1.0."},
+		{n.LocalEvents[0].Prefix, "10.20.0.0/16"},
+		{n.DNS.Server, "8.8.8.8"},
+		{n.DNS.Error, "read udp 192.168.1.0:41234->8.8.8.8:53: i/o timeout"},
+		{n.DNS.Answers[0], "10.20.30.0"},
+		{n.NTP.Error, "dial udp [fd00:1:2::]:123: no route"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+	// r is left alone
+	if r.Hups[0].Hosts[1].Host != "192.168.1.2" || !strings.Contains(r.Errors[0].Error, "192.168.1.5") || r.DNS.Answers[0] != "10.20.30.40" {
+		t.Error("Apply modified its argument")
+	}
+
+	h, err := Anonymizer{Mode: AnonymizeHash, Key: []byte("secret")}.Apply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(h.Src, "192.168.1.0#") || !strings.Contains(h.Errors[0].Error, h.Src+"->") {
+		t.Errorf("hashed Src %s, error %q", h.Src, h.Errors[0].Error)
+	}
+}
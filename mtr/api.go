@@ -0,0 +1,31 @@
+package mtr
+
+import "time"
+
+// Stable names for the report types. New code should use these; the MTR*
+// names are kept so existing callers keep compiling.
+type (
+	Report   = MTRReport
+	Hop      = MTRHup
+	RunError = MTRRunError
+)
+
+// NewOPMTRWithParams is the former positional form of NewOPMTR.
+//
+// Deprecated: use NewOPMTR with WithMaxHops, WithPingCount, WithMaxUnknowns
+// and WithTimeout.
+func NewOPMTRWithParams(src string, maxHops, count, maxUnknowns int, timeout time.Duration) (*OPMTR, error) {
+	return NewOPMTR(src,
+		WithMaxHops(maxHops),
+		WithPingCount(count),
+		WithMaxUnknowns(maxUnknowns),
+		WithTimeout(timeout),
+	)
+}
+
+// RunMTRWithCocurrentPing is the former name of Run.
+//
+// Deprecated: use Run.
+func (op *OPMTR) RunMTRWithCocurrentPing(dst string) (MTRReport, error) {
+	return op.Run(dst)
+}
@@ -0,0 +1,241 @@
+package mtr
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ASInfo is the autonomous system an address is announced by.
+type ASInfo struct {
+	Number int
+	Name   string
+}
+
+// ASNSource maps an address to its autonomous system. Addresses no AS
+// announces return a zero ASInfo and no error.
+type ASNSource interface {
+	LookupASN(ctx context.Context, addr string) (ASInfo, error)
+}
+
+// TXTResolver looks up TXT records. *net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// CymruASN looks up ASNs over DNS with the Team Cymru IP to ASN service.
+// Results are cached, so repeated runs don't re-query.
+type CymruASN struct {
+	// Resolver does the lookups, net.DefaultResolver if nil.
+	Resolver TXTResolver
+	// Timeout bounds each lookup, 2s if zero.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	addrs map[string]ASInfo
+	names map[int]string
+}
+
+// LookupASN implements ASNSource.
+func (c *CymruASN) LookupASN(ctx context.Context, addr string) (ASInfo, error) {
+	c.mu.Lock()
+	info, ok := c.addrs[addr]
+	c.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ASInfo{}, fmt.Errorf("invalid address %q", addr)
+	}
+	var name string
+	if ip4 := ip.To4(); ip4 != nil {
+		name = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", ip4[3], ip4[2], ip4[1], ip4[0])
+	} else {
+		var b strings.Builder
+		for i := len(ip) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "%x.%x.", ip[i]&0xf, ip[i]>>4)
+		}
+		name = b.String() + "origin6.asn.cymru.com"
+	}
+	// "13335 | 1.1.1.0/24 | US | arin | 2010-07-14"
+	fields, err := c.txt(ctx, name)
+	if err != nil {
+		return ASInfo{}, err
+	}
+	if len(fields) > 0 {
+		// Multi-origin prefixes list every AS, keep the first.
+		if asn := strings.Fields(fields[0]); len(asn) > 0 {
+			info.Number, _ = strconv.Atoi(asn[0])
+		}
+	}
+	if info.Number != 0 {
+		if info.Name, err = c.asName(ctx, info.Number); err != nil {
+			return ASInfo{}, err
+		}
+	}
+	c.mu.Lock()
+	if c.addrs == nil || len(c.addrs) >= DefaultPTRCacheSize {
+		c.addrs = map[string]ASInfo{}
+	}
+	c.addrs[addr] = info
+	c.mu.Unlock()
+	return info, nil
+}
+
+func (c *CymruASN) asName(ctx context.Context, asn int) (string, error) {
+	c.mu.Lock()
+	name, ok := c.names[asn]
+	c.mu.Unlock()
+	if ok {
+		return name, nil
+	}
+	// "13335 | US | arin | 2010-07-14 | CLOUDFLARENET - Cloudflare, Inc., US"
+	fields, err := c.txt(ctx, fmt.Sprintf("AS%d.asn.cymru.com", asn))
+	if err != nil {
+		return "", err
+	}
+	if len(fields) >= 5 {
+		name = fields[4]
+	}
+	c.mu.Lock()
+	if c.names == nil {
+		c.names = map[int]string{}
+	}
+	c.names[asn] = name
+	c.mu.Unlock()
+	return name, nil
+}
+
+// txt returns the "|" separated fields of the first TXT record of name, or
+// none if name doesn't exist.
+func (c *CymruASN) txt(ctx context.Context, name string) ([]string, error) {
+	var r TXTResolver = net.DefaultResolver
+	if c.Resolver != nil {
+		r = c.Resolver
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	recs, err := r.LookupTXT(ctx, name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	if err != nil || len(recs) == 0 {
+		return nil, err
+	}
+	fields := strings.Split(recs[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, nil
+}
+
+// ASNTable is an offline ASNSource, e.g. loaded from a GeoLite2-ASN CSV
+// export. It is safe for concurrent lookups once loaded.
+type ASNTable struct {
+	// nets maps a prefix length and masked network to its AS. bits lists
+	// the prefix lengths in use, longest first.
+	nets map[int]map[string]ASInfo
+	bits []int
+}
+
+// Add maps the addresses in network to info. More specific networks win.
+func (t *ASNTable) Add(network *net.IPNet, info ASInfo) {
+	ones, size := network.Mask.Size()
+	if size == 32 {
+		ones += 96
+	}
+	if t.nets == nil {
+		t.nets = map[int]map[string]ASInfo{}
+	}
+	if t.nets[ones] == nil {
+		t.nets[ones] = map[string]ASInfo{}
+		i := 0
+		for i < len(t.bits) && t.bits[i] > ones {
+			i++
+		}
+		t.bits = append(t.bits[:i], append([]int{ones}, t.bits[i:]...)...)
+	}
+	t.nets[ones][string(network.IP.To16().Mask(net.CIDRMask(ones, 128)))] = info
+}
+
+// LookupASN implements ASNSource.
+func (t *ASNTable) LookupASN(ctx context.Context, addr string) (ASInfo, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ASInfo{}, fmt.Errorf("invalid address %q", addr)
+	}
+	for _, ones := range t.bits {
+		if info, ok := t.nets[ones][string(ip.Mask(net.CIDRMask(ones, 128)))]; ok {
+			return info, nil
+		}
+	}
+	return ASInfo{}, nil
+}
+
+// LoadASNTable reads an ASNTable from CSV rows of network, AS number and AS
+// name, the layout of the GeoLite2-ASN-Blocks files. A header row is
+// skipped.
+func LoadASNTable(r io.Reader) (*ASNTable, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	t := &ASNTable{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: want network and AS number", line)
+		}
+		_, network, err := net.ParseCIDR(rec[0])
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		info := ASInfo{}
+		if info.Number, err = strconv.Atoi(strings.TrimPrefix(rec[1], "AS")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number %q", line, rec[1])
+		}
+		if len(rec) > 2 {
+			info.Name = rec[2]
+		}
+		t.Add(network, info)
+	}
+}
+
+// annotateASN sets ASN and ASName on every responding hup. Lookups that fail
+// leave the hup unannotated.
+func annotateASN(ctx context.Context, src ASNSource, hups []*MTRHup) {
+	var wg sync.WaitGroup
+	for _, h := range hups {
+		if h.Host == "???" {
+			continue
+		}
+		wg.Add(1)
+		go func(h *MTRHup) {
+			defer wg.Done()
+			info, err := src.LookupASN(ctx, h.Host)
+			if err != nil {
+				return
+			}
+			h.ASN, h.ASName = info.Number, info.Name
+		}(h)
+	}
+	wg.Wait()
+}
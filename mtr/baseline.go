@@ -0,0 +1,124 @@
+package mtr
+
+import (
+	"context"
+	"net"
+)
+
+// BaselineResult is a plain end-to-end ping series to the destination,
+// sent alongside the pings of the hups if OPMTR.Baseline is set. Routers
+// rate-limit or deprioritize the ICMP they answer themselves, not the
+// traffic they forward, so hups losing more than the baseline, or slower
+// than it, show ICMP handling rather than the path.
+type BaselineResult struct {
+	Snt    int     `json:"snt"`
+	Loss   float64 `json:"loss"`
+	Last   float64 `json:"last"`
+	Avg    float64 `json:"avg"`
+	Best   float64 `json:"best"`
+	Wrst   float64 `json:"wrst"`
+	StDev  float64 `json:"stdev"`
+	Jitter float64 `json:"jitter"`
+
+	// hup holds the running statistics, for Monitor to merge cycles
+	hup MTRHup
+}
+
+// set fills the fields of b from its running statistics.
+func (b *BaselineResult) set() {
+	h := &b.hup
+	b.Snt, b.Last, b.Avg, b.Best, b.Wrst = int(h.Snt), h.Last, h.Avg, h.Best, h.Wrst
+	b.StDev, b.Jitter = h.StDev, h.Jitter
+	if h.Snt > 0 {
+		b.Loss = float64(h.LossPoint) / h.Snt
+	}
+}
+
+// merge adds the series of c, a later baseline of the same destination.
+func (b *BaselineResult) merge(c *BaselineResult) {
+	b.hup.merge(c.hup, 0)
+	b.set()
+}
+
+// startBaseline sends the baseline pings to dst in the background, if
+// Baseline is set, and returns the func waiting for their result, which
+// is nil without a baseline. A panic is handed to failed and leaves no
+// baseline.
+func (op *OPMTR) startBaseline(ctx context.Context, dst net.IP, failed func(MTRRunError)) func() *BaselineResult {
+	if !op.Baseline {
+		return func() *BaselineResult { return nil }
+	}
+	ch := make(chan *BaselineResult, 1)
+	SafeGo("baseline", 0, func() { ch <- op.baseline(ctx, dst) }, func(e MTRRunError) {
+		failed(e)
+		ch <- nil
+	})
+	return func() *BaselineResult { return <-ch }
+}
+
+// baseline sends PingCount pings to dst, one after another as a hup's
+// pings, and counts those answered by dst itself.
+func (op *OPMTR) baseline(ctx context.Context, dst net.IP) *BaselineResult {
+	res := &BaselineResult{}
+	h := &res.hup
+	pace := op.pacer(nil, 0)
+	for i := 0; i < op.PingCount; i++ {
+		if pace.wait(ctx, op.clock()) != nil {
+			break
+		}
+		r, err := op.ping(ctx, dst.String(), op.Tracer.MaxHops, op.Tracer.Timeout)
+		if ctx.Err() != nil {
+			// interrupted, not lost
+			break
+		}
+		h.Snt++
+		if answered(r, err) && r.IP.Equal(dst) {
+			h.record(r.IP.String(), r.RTT.Seconds()*1000)
+		} else {
+			h.LossPoint++
+		}
+	}
+	res.set()
+	return res
+}
+
+// aboveBaselineMargin is how much more than the baseline a hop must lose
+// to be flagged on its own, without the hops after it losing more too.
+const aboveBaselineMargin = 0.2
+
+// AboveBaseline returns the hops losing more probes than the baseline, in
+// order, or nil without one. Their loss is likely the hop limiting or
+// ignoring the ICMP it answers rather than loss of forwarded traffic.
+// Occasional ICMP rate limiting is normal, so a hop is only flagged if
+// every known hop after it also loses more than the baseline, or if it
+// loses aboveBaselineMargin more. Unknown hops are never flagged.
+func (r MTRReport) AboveBaseline() []int {
+	if r.Baseline == nil || r.Baseline.Snt == 0 {
+		return nil
+	}
+	above := func(h MTRHup) bool { return h.Loss > r.Baseline.Loss }
+	// persists reports whether the hops after i lose more too
+	persists := func(i int) bool {
+		known := 0
+		for _, h := range r.Hups[i+1:] {
+			if h.Host == "???" {
+				continue
+			}
+			if !above(h) {
+				return false
+			}
+			known++
+		}
+		return known > 0
+	}
+	var hops []int
+	for i, h := range r.Hups {
+		if h.Host == "???" || !above(h) {
+			continue
+		}
+		if h.Loss-r.Baseline.Loss > aboveBaselineMargin || persists(i) {
+			hops = append(hops, h.Count)
+		}
+	}
+	return hops
+}
@@ -0,0 +1,71 @@
+package mtr
+
+import (
+	"context"
+	"math"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+func TestAboveBaseline(t *testing.T) {
+	// path builds known hups with losses.
+	path := func(losses ...float64) []MTRHup {
+		hups := make([]MTRHup, len(losses))
+		for i, l := range losses {
+			hups[i] = MTRHup{Count: i + 1, Host: "10.0.0.1", Loss: l}
+		}
+		return hups
+	}
+	unknown := func(hups []MTRHup, i int) []MTRHup {
+		hups[i].Host = "???"
+		return hups
+	}
+	tests := []struct {
+		name string
+		hups []MTRHup
+		want []int
+	}{
+		{"clean", path(0, 0, 0), nil},
+		{"rate limited hop", path(0, 0.1, 0, 0), nil},
+		{"heavily limiting hop", path(0, 0.5, 0, 0), []int{2}},
+		{"loss carried on", path(0, 0.1, 0.1, 0.15), []int{2, 3}},
+		{"unknown hops", unknown(unknown(path(0, 1, 0.1, 1, 0.1), 1), 3), []int{3}},
+		{"last hop", path(0, 0, 0.1), nil},
+	}
+	for _, tt := range tests {
+		r := MTRReport{Hups: tt.hups, Baseline: &BaselineResult{Snt: 10}}
+		if got := r.AboveBaseline(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: AboveBaseline = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	r := MTRReport{Hups: path(0, 1, 1)}
+	if got := r.AboveBaseline(); got != nil {
+		t.Errorf("without baseline: AboveBaseline = %v", got)
+	}
+}
+
+func TestBaselineLostPing(t *testing.T) {
+	op, err := NewOPMTR("192.0.2.1", WithPingCount(3), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer op.Close()
+	dst := net.IPv4(10, 0, 0, 3)
+	var sent int32
+	op.Probe = func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+		// the first ping is lost, the others answered after 10 ms
+		if atomic.AddInt32(&sent, 1) == 1 {
+			return nil, nil
+		}
+		return &traceroute.Reply{IP: dst, RTT: 10 * time.Millisecond, Hops: ttl}, nil
+	}
+	b := op.baseline(context.Background(), dst)
+	if b.Snt != 3 || math.Abs(b.Loss-1.0/3) > 1e-9 || b.Avg != 10 || b.Best != 10 || b.Wrst != 10 {
+		t.Errorf("baseline Snt %d, Loss %v, Avg %v, Best %v, Wrst %v, want 3, 0.33, 10, 10, 10", b.Snt, b.Loss, b.Avg, b.Best, b.Wrst)
+	}
+}
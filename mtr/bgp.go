@@ -0,0 +1,61 @@
+package mtr
+
+import (
+	"net"
+	"time"
+)
+
+// BGPEvent is an announcement or withdrawal seen by an external BGP feed.
+type BGPEvent struct {
+	Time      int64    `json:"ts"`
+	Peer      string   `json:"peer,omitempty"`
+	Prefix    string   `json:"prefix"`
+	ASPath    []uint32 `json:"as_path,omitempty"`
+	Withdrawn bool     `json:"withdrawn,omitempty"`
+}
+
+// BGPFeed supplies BGP events, e.g. from a BMP collector or gobgp adapter.
+type BGPFeed interface {
+	// BGPEvents returns the events seen at or after since.
+	BGPEvents(since time.Time) []BGPEvent
+}
+
+// DefaultBGPWindow is how long before a path change a BGP event may have
+// triggered it.
+const DefaultBGPWindow = 5 * time.Minute
+
+// CorrelateBGP picks the event from feed most likely to have changed the
+// path to dst at changed: the most specific prefix covering dst within
+// window before the change, the latest one on ties.
+func CorrelateBGP(feed BGPFeed, dst string, changed time.Time, window time.Duration) (BGPEvent, bool) {
+	ip := net.ParseIP(dst)
+	if ip == nil {
+		return BGPEvent{}, false
+	}
+	var best BGPEvent
+	bestLen := -1
+	for _, e := range feed.BGPEvents(changed.Add(-window)) {
+		if e.Time > changed.Unix() {
+			continue
+		}
+		_, n, err := net.ParseCIDR(e.Prefix)
+		if err != nil || !n.Contains(ip) {
+			continue
+		}
+		l, _ := n.Mask.Size()
+		if l > bestLen || l == bestLen && e.Time >= best.Time {
+			best, bestLen = e, l
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// SetTrigger records e as the likely cause of the last path change from src
+// to dst.
+func (db *PathDB) SetTrigger(src, dst string, e BGPEvent) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if rec, ok := db.paths[pathKey(src, dst)]; ok {
+		rec.Trigger = &e
+	}
+}
@@ -0,0 +1,116 @@
+package mtr
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// BundleOptions configures WriteBundle.
+type BundleOptions struct {
+	// Last is the number of most recent reports included, all if zero.
+	Last int
+	// PathDB, if set, supplies the current path to the destination.
+	PathDB *PathDB
+}
+
+// WriteBundle writes a zip archive describing dst for attaching to a
+// provider ticket: its latest reports (reports.json), the known paths
+// (paths.json), hop changes between consecutive reports (diffs.txt) and the
+// agent's diagnostics (diagnostics.json). reports are expected oldest first;
+// those for other destinations are skipped.
+func WriteBundle(w io.Writer, dst string, reports []MTRReport, o BundleOptions) error {
+	var sel []MTRReport
+	for _, r := range reports {
+		if r.Dst == dst || r.DstName == dst {
+			sel = append(sel, r)
+		}
+	}
+	if o.Last > 0 && len(sel) > o.Last {
+		sel = sel[len(sel)-o.Last:]
+	}
+
+	var paths []PathRecord
+	if o.PathDB != nil {
+		for _, rec := range o.PathDB.Records() {
+			if rec.Dst == dst {
+				paths = append(paths, rec)
+			}
+		}
+	}
+
+	backend, berr := DetectBackend()
+	diag := map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"go":      runtime.Version(),
+		"os":      runtime.GOOS,
+		"arch":    runtime.GOARCH,
+		"backend": backend,
+		"metrics": Metrics(),
+	}
+	if berr != nil {
+		diag["backend_error"] = berr.Error()
+	}
+
+	z := zip.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"reports.json", sel},
+		{"paths.json", paths},
+		{"diagnostics.json", diag},
+	} {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+	fw, err := z.Create("diffs.txt")
+	if err != nil {
+		return err
+	}
+	if err := writeDiffs(fw, sel); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// writeDiffs lists the hups whose host changed between consecutive reports.
+func writeDiffs(w io.Writer, reports []MTRReport) error {
+	for i := 1; i < len(reports); i++ {
+		prev, cur := reports[i-1], reports[i]
+		n := len(prev.Hups)
+		if len(cur.Hups) > n {
+			n = len(cur.Hups)
+		}
+		for j := 0; j < n; j++ {
+			a, b := "-", "-"
+			if j < len(prev.Hups) {
+				a = prev.Hups[j].Host
+			}
+			if j < len(cur.Hups) {
+				b = cur.Hups[j].Host
+			}
+			if a == b {
+				continue
+			}
+			_, err := fmt.Fprintf(w, "%s -> %s hop %d: %s -> %s\n",
+				time.Unix(prev.Time, 0).UTC().Format(time.RFC3339),
+				time.Unix(cur.Time, 0).UTC().Format(time.RFC3339),
+				j+1, a, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
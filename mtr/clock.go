@@ -0,0 +1,59 @@
+package mtr
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the time source of an OPMTR for report timestamps, Monitor
+// cycles, Scheduler pacing and injected fault delays. Tests can set a fake
+// one, such as mtrtest.Clock, to run those instantly. RTTs and socket
+// timeouts are always measured with the system clock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer firing once after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer made by a Clock.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, reporting whether it was
+	// pending.
+	Stop() bool
+}
+
+// SystemClock is the Clock of an OPMTR without one.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+
+func (t systemTimer) Stop() bool { return t.t.Stop() }
+
+func (op *OPMTR) clock() Clock {
+	if op.Clock != nil {
+		return op.Clock
+	}
+	return SystemClock
+}
+
+// sleep waits for d on clk, returning ctx.Err() if ctx is done first.
+func sleep(ctx context.Context, clk Clock, d time.Duration) error {
+	t := clk.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
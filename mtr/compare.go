@@ -0,0 +1,128 @@
+package mtr
+
+import "sort"
+
+// PathComparison is a merged view of reports for one destination taken from
+// several agents (or runs), keyed by agent name.
+type PathComparison struct {
+	Dst        string              `json:"dst"`
+	Agents     []string            `json:"agents"`
+	Paths      map[string][]string `json:"paths"`
+	SharedHops []string            `json:"shared_hops"`
+	Divergence int                 `json:"divergence"`
+	EndToEnd   map[string]MTRHup   `json:"end_to_end"`
+}
+
+// ComparePaths merges reports keyed by agent. Paths lists each agent's hop
+// hosts by TTL, SharedHops the responsive hosts every agent traversed,
+// Divergence the first TTL at which the paths differ (0 if they never do)
+// and EndToEnd each agent's final hup.
+func ComparePaths(reports map[string]MTRReport) PathComparison {
+	c := PathComparison{
+		Paths:    map[string][]string{},
+		EndToEnd: map[string]MTRHup{},
+	}
+	for agent, r := range reports {
+		c.Agents = append(c.Agents, agent)
+		c.Dst = r.Dst
+		var path []string
+		for _, h := range r.Hups {
+			path = append(path, h.Host)
+		}
+		c.Paths[agent] = path
+		if len(r.Hups) > 0 {
+			c.EndToEnd[agent] = r.Hups[len(r.Hups)-1]
+		}
+	}
+	sort.Strings(c.Agents)
+	if len(c.Agents) == 0 {
+		return c
+	}
+
+	first := c.Paths[c.Agents[0]]
+	for _, host := range first {
+		if host == "???" {
+			continue
+		}
+		shared := true
+		for _, agent := range c.Agents[1:] {
+			if !containsHost(c.Paths[agent], host) {
+				shared = false
+				break
+			}
+		}
+		if shared && !containsHost(c.SharedHops, host) {
+			c.SharedHops = append(c.SharedHops, host)
+		}
+	}
+
+	for ttl := 1; c.Divergence == 0; ttl++ {
+		var host string
+		done := true
+		for i, agent := range c.Agents {
+			path := c.Paths[agent]
+			h := ""
+			if ttl <= len(path) {
+				h = path[ttl-1]
+				done = false
+			}
+			if i == 0 {
+				host = h
+			} else if h != host {
+				c.Divergence = ttl
+			}
+		}
+		if done {
+			break
+		}
+	}
+	return c
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// AnycastLanding is where one agent's path to an anycast destination ends.
+type AnycastLanding struct {
+	Agent string  `json:"agent"`
+	Site  string  `json:"site"`
+	Avg   float64 `json:"avg"`
+	Far   bool    `json:"far"`
+}
+
+// AnalyzeAnycast identifies the instance each agent reaches for an anycast
+// destination. As every agent sees the same destination address, the site
+// is taken to be the last responsive hop before it. Agents whose end-to-end
+// average RTT exceeds farFactor times the best agent's are flagged Far.
+func AnalyzeAnycast(reports map[string]MTRReport, farFactor float64) []AnycastLanding {
+	c := ComparePaths(reports)
+	var landings []AnycastLanding
+	best := -1.0
+	for _, agent := range c.Agents {
+		l := AnycastLanding{Agent: agent, Site: "???"}
+		path := c.Paths[agent]
+		for i := len(path) - 2; i >= 0; i-- {
+			if path[i] != "???" {
+				l.Site = path[i]
+				break
+			}
+		}
+		if e, ok := c.EndToEnd[agent]; ok && e.Host != "???" {
+			l.Avg = e.Avg
+			if best < 0 || e.Avg < best {
+				best = e.Avg
+			}
+		}
+		landings = append(landings, l)
+	}
+	for i := range landings {
+		landings[i].Far = best > 0 && landings[i].Avg > best*farFactor
+	}
+	return landings
+}
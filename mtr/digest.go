@@ -0,0 +1,146 @@
+package mtr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// DigestOptions configures WriteDigest.
+type DigestOptions struct {
+	// Since limits path changes to those recorded after it.
+	Since time.Time
+	// Top is the number of degraded paths listed, 10 if zero.
+	Top int
+	// Scorer rates each report. Reports scoring below MinHealth breach
+	// their SLA.
+	Scorer    HealthScorer
+	MinHealth float64
+	// PathDB, if set, supplies the path changes and the stability of each
+	// path over Windows, DefaultStabilityWindows if empty.
+	PathDB  *PathDB
+	Windows []time.Duration
+}
+
+// WriteDigest renders a Markdown summary of reports for periodic digests:
+// SLA status, the most degraded paths and the paths that changed.
+func WriteDigest(w io.Writer, reports []MTRReport, o DigestOptions) error {
+	top := o.Top
+	if top <= 0 {
+		top = 10
+	}
+	type scored struct {
+		r     MTRReport
+		score float64
+	}
+	all := make([]scored, len(reports))
+	breaches := 0
+	for i, r := range reports {
+		all[i] = scored{r, o.Scorer.Score(r)}
+		if all[i].score < o.MinHealth {
+			breaches++
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].score < all[j].score })
+
+	p := &digestWriter{w: w}
+	p.printf("# op-mtr digest\n\n")
+	p.printf("%d targets, %d below health %.0f.\n\n", len(reports), breaches, o.MinHealth)
+
+	p.printf("## Most degraded paths\n\n")
+	p.printf("| Src | Dst | Loss | Avg RTT | Health | SLA |\n|---|---|---|---|---|---|\n")
+	for i, s := range all {
+		if i == top {
+			break
+		}
+		h := lastHup(s.r)
+		sla := "ok"
+		if s.score < o.MinHealth {
+			sla = "breach"
+		}
+		p.printf("| %s | %s | %.1f%% | %.1f | %.1f | %s |\n",
+			s.r.Src, digestDst(s.r), h.Loss*100, h.Avg, s.score, sla)
+	}
+
+	if o.PathDB != nil {
+		p.printf("\n## Path changes\n\n")
+		n := 0
+		for _, rec := range o.PathDB.Records() {
+			if rec.Changes == 0 || time.Unix(rec.Changed, 0).Before(o.Since) {
+				continue
+			}
+			p.printf("- %s → %s: %d changes, last at %s\n",
+				rec.Src, rec.Dst, rec.Changes, time.Unix(rec.Changed, 0).UTC().Format(time.RFC3339))
+			n++
+		}
+		if n == 0 {
+			p.printf("No path changes.\n")
+		}
+		writeStability(p, o.PathDB, o.Windows, top)
+	}
+	return p.err
+}
+
+// writeStability lists the top least stable paths over the last window.
+func writeStability(p *digestWriter, db *PathDB, windows []time.Duration, top int) {
+	if len(windows) == 0 {
+		windows = DefaultStabilityWindows
+	}
+	type row struct {
+		rec PathRecord
+		s   []PathStability
+	}
+	var rows []row
+	for _, rec := range db.Records() {
+		x := row{rec: rec}
+		for _, w := range windows {
+			s, _ := db.Stability(rec.Src, rec.Dst, w)
+			x.s = append(x.s, s)
+		}
+		if x.s[len(x.s)-1].Runs > 0 {
+			rows = append(rows, x)
+		}
+	}
+	last := len(windows) - 1
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].s[last].Modal < rows[j].s[last].Modal })
+
+	p.printf("\n## Path stability\n\n| Src | Dst |")
+	for _, w := range windows {
+		p.printf(" Modal %s | Paths %s |", FormatWindow(w), FormatWindow(w))
+	}
+	p.printf("\n|---|---|")
+	for range windows {
+		p.printf("---|---|")
+	}
+	p.printf("\n")
+	for i, x := range rows {
+		if i == top {
+			break
+		}
+		p.printf("| %s | %s |", x.rec.Src, x.rec.Dst)
+		for _, s := range x.s {
+			p.printf(" %.0f%% | %d |", s.Modal*100, s.Distinct)
+		}
+		p.printf("\n")
+	}
+}
+
+func digestDst(r MTRReport) string {
+	if r.DstName != "" {
+		return r.DstName
+	}
+	return r.Dst
+}
+
+// digestWriter keeps the first write error so WriteDigest can check once.
+type digestWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *digestWriter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
@@ -0,0 +1,162 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoverySource lists targets from an external inventory.
+type DiscoverySource interface {
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// SRVSource discovers the targets behind a DNS SRV name such as
+// "_https._tcp.example.com". The SRV port becomes the target's TCPPort.
+type SRVSource struct {
+	Name  string
+	Group string
+	// Resolver does the lookups, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// Discover implements DiscoverySource.
+func (s SRVSource) Discover(ctx context.Context) ([]Target, error) {
+	r := s.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	_, srvs, err := r.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, len(srvs))
+	for i, srv := range srvs {
+		targets[i] = Target{
+			Dst:           strings.TrimSuffix(srv.Target, "."),
+			Group:         s.Group,
+			TargetOptions: TargetOptions{TCPPort: int(srv.Port)},
+		}
+	}
+	return targets, nil
+}
+
+// ConsulSource discovers the instances of a service in the Consul catalog.
+type ConsulSource struct {
+	// Addr is the Consul HTTP API, e.g. "http://127.0.0.1:8500".
+	Addr    string
+	Service string
+	Group   string
+	// Client makes the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Discover implements DiscoverySource.
+func (s ConsulSource) Discover(ctx context.Context) ([]Target, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u := strings.TrimSuffix(s.Addr, "/") + "/v1/catalog/service/" + url.PathEscape(s.Service)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul %s: %s", s.Service, resp.Status)
+	}
+	var entries []struct {
+		Address        string
+		ServiceAddress string
+		ServicePort    int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	targets := make([]Target, len(entries))
+	for i, e := range entries {
+		dst := e.ServiceAddress
+		if dst == "" {
+			dst = e.Address
+		}
+		targets[i] = Target{Dst: dst, Group: s.Group, TargetOptions: TargetOptions{TCPPort: e.ServicePort}}
+	}
+	return targets, nil
+}
+
+// Discovery keeps the targets of several sources up to date. A source that
+// fails keeps its previous targets. It is safe for concurrent use.
+type Discovery struct {
+	Sources []DiscoverySource
+	// Logger, if set, receives the errors of Run.
+	Logger Logger
+
+	mu      sync.RWMutex
+	targets map[int][]Target
+}
+
+// Refresh queries every source once and returns the first error.
+func (d *Discovery) Refresh(ctx context.Context) error {
+	var first error
+	for i, s := range d.Sources {
+		t, err := s.Discover(ctx)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		d.mu.Lock()
+		if d.targets == nil {
+			d.targets = map[int][]Target{}
+		}
+		d.targets[i] = t
+		d.mu.Unlock()
+	}
+	return first
+}
+
+// Run refreshes the targets every interval until ctx is done, logging
+// source errors to Logger.
+func (d *Discovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Refresh(ctx); err != nil && ctx.Err() == nil {
+			logTo(d.Logger, LevelError, "target discovery failed", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Targets returns the current targets of all sources, without duplicates.
+func (d *Discovery) Targets() []Target {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	seen := map[Target]bool{}
+	var out []Target
+	for i := range d.Sources {
+		for _, t := range d.targets[i] {
+			if !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
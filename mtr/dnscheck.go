@@ -0,0 +1,213 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSProbe sends a DNS query to the destination of every run, for targets
+// that are resolvers, so a report shows whether the resolver answered and
+// how fast next to the path towards it.
+type DNSProbe struct {
+	// Name is the name to query.
+	Name string `json:"name"`
+	// Type is the query type, A if empty. A, AAAA, CNAME, NS, MX, PTR,
+	// SOA and TXT are supported.
+	Type string `json:"type,omitempty"`
+	// Expect, if set, lists values the answer must contain, e.g. the
+	// addresses Name should resolve to.
+	Expect []string `json:"expect,omitempty"`
+	// Port is the resolver port, 53 if zero.
+	Port int `json:"port,omitempty"`
+	// Timeout bounds the query, 2s if zero.
+	Timeout time.Duration `json:"-"`
+}
+
+// DNSResult is the outcome of a DNSProbe query. OK is set when the resolver
+// answered without error, with at least one record and with every expected
+// value.
+type DNSResult struct {
+	Server  string   `json:"server"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	RTT     float64  `json:"rtt"`
+	Rcode   string   `json:"rcode,omitempty"`
+	Answers []string `json:"answers,omitempty"`
+	OK      bool     `json:"ok"`
+	Error   string   `json:"error,omitempty"`
+}
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"NS":    dnsmessage.TypeNS,
+	"MX":    dnsmessage.TypeMX,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// Check queries the resolver at server.
+func (p DNSProbe) Check(ctx context.Context, server string) DNSResult {
+	typ := strings.ToUpper(p.Type)
+	if typ == "" {
+		typ = "A"
+	}
+	res := DNSResult{Server: server, Name: p.Name, Type: typ}
+	answers, rcode, rtt, err := p.query(ctx, server, typ)
+	res.RTT = float64(rtt) / float64(time.Millisecond)
+	res.Rcode, res.Answers = rcode, answers
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case rcode != "NOERROR":
+		res.Error = "resolver returned " + rcode
+	case len(answers) == 0:
+		res.Error = "empty answer"
+	default:
+		res.OK = true
+		for _, want := range p.Expect {
+			if !containsAnswer(answers, want) {
+				res.OK = false
+				res.Error = fmt.Sprintf("answer lacks %s", want)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func containsAnswer(answers []string, want string) bool {
+	w := strings.TrimSuffix(want, ".")
+	wip := net.ParseIP(w)
+	for _, a := range answers {
+		if strings.EqualFold(strings.TrimSuffix(a, "."), w) {
+			return true
+		}
+		if ip := net.ParseIP(a); ip != nil && wip != nil && ip.Equal(wip) {
+			return true
+		}
+	}
+	return false
+}
+
+// query sends one query over UDP and returns the answer records of the
+// requested type rendered as text.
+func (p DNSProbe) query(ctx context.Context, server, typ string) ([]string, string, time.Duration, error) {
+	qtype, ok := dnsTypes[typ]
+	if !ok {
+		return nil, "", 0, fmt.Errorf("unsupported DNS query type %q", typ)
+	}
+	name := p.Name
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	id := uint16(rand.Uint32())
+	q := dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	port := p.Port
+	if port == 0 {
+		port = 53
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(server, strconv.Itoa(port)))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	start := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return nil, "", 0, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, "", time.Since(start), err
+		}
+		rtt := time.Since(start)
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || resp.ID != id || !resp.Response ||
+			len(resp.Questions) != 1 || resp.Questions[0] != q {
+			// Not the answer to our query, keep waiting.
+			continue
+		}
+		var answers []string
+		for _, a := range resp.Answers {
+			if a.Header.Type == qtype {
+				answers = append(answers, dnsRecordText(a.Body))
+			}
+		}
+		return answers, rcodeName(resp.RCode), rtt, nil
+	}
+}
+
+// rcodeName names a response code the way dig does.
+func rcodeName(c dnsmessage.RCode) string {
+	switch c {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", c)
+}
+
+func dnsRecordText(b dnsmessage.ResourceBody) string {
+	switch r := b.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(r.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(r.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.MXResource:
+		return r.MX.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.SOAResource:
+		return r.NS.String()
+	case *dnsmessage.TXTResource:
+		return strings.Join(r.TXT, "")
+	}
+	return ""
+}
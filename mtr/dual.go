@@ -0,0 +1,98 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// DualReport compares the same destination measured over the default route
+// (Underlay) and over a tunnel interface (Tunnel).
+type DualReport struct {
+	Iface    string    `json:"iface"`
+	Underlay MTRReport `json:"underlay"`
+	Tunnel   MTRReport `json:"tunnel"`
+	// LossDelta and AvgDelta are tunnel minus underlay at the last hup.
+	LossDelta float64 `json:"loss_delta"`
+	AvgDelta  float64 `json:"avg_delta"`
+	// Verdict is "ok", "tunnel" (only the tunnel path is lossy) or
+	// "underlay" (the underlay itself is lossy, so the tunnel is not to blame).
+	Verdict string `json:"verdict"`
+}
+
+// dualLossThreshold is the end-to-end loss above which a path is lossy.
+const dualLossThreshold = 0.05
+
+// RunDual measures dst twice: once as configured and once sourced from the
+// first address of iface (e.g. "wg0"), which on policy-routed VPN setups
+// such as wg-quick sends the probes through the tunnel.
+func (op *OPMTR) RunDual(dst, iface string) (DualReport, error) {
+	d := DualReport{Iface: iface}
+	dstIP, dstName, err := op.resolve(context.Background(), dst)
+	if err != nil {
+		return d, err
+	}
+	src, err := ifaceAddr(iface, dstIP.To4() != nil)
+	if err != nil {
+		return d, err
+	}
+	tun, err := NewOPMTR(src.String(),
+		WithMaxHops(op.Tracer.MaxHops),
+		WithPingCount(op.PingCount),
+		WithMaxUnknowns(op.MaxUnknowns),
+		WithTimeout(op.Tracer.Timeout),
+		WithDelay(op.Tracer.Delay),
+	)
+	if err != nil {
+		return d, err
+	}
+	defer tun.Close()
+	tun.Faults = op.Faults
+	tun.Probe = op.Probe
+
+	// both runs must measure the same address, not two DNS answers
+	if d.Underlay, err = op.Run(dstIP.String()); err != nil {
+		return d, err
+	}
+	if d.Tunnel, err = tun.Run(dstIP.String()); err != nil {
+		return d, err
+	}
+	d.Underlay.DstName, d.Tunnel.DstName = dstName, dstName
+
+	u, t := lastHup(d.Underlay), lastHup(d.Tunnel)
+	d.LossDelta = t.Loss - u.Loss
+	d.AvgDelta = t.Avg - u.Avg
+	switch {
+	case u.Loss > dualLossThreshold:
+		d.Verdict = "underlay"
+	case t.Loss > dualLossThreshold:
+		d.Verdict = "tunnel"
+	default:
+		d.Verdict = "ok"
+	}
+	return d, nil
+}
+
+func lastHup(r MTRReport) MTRHup {
+	if len(r.Hups) == 0 {
+		return MTRHup{Loss: 1}
+	}
+	return r.Hups[len(r.Hups)-1]
+}
+
+func ifaceAddr(name string, v4 bool) (net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && (n.IP.To4() != nil) == v4 {
+			return n.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no usable address on interface %s", name)
+}
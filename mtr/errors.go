@@ -0,0 +1,83 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors wrapped by the errors of runs, to be tested with errors.Is along
+// with ErrPermission.
+var (
+	// ErrInvalidSource is wrapped by errors about the source address, e.g.
+	// one that can't reach the destination's address family.
+	ErrInvalidSource = errors.New("invalid source address")
+	// ErrResolve is wrapped by ResolveError.
+	ErrResolve = errors.New("cannot resolve destination")
+	// ErrTimeout is wrapped by the errors of runs whose context deadline
+	// passed. They also match context.DeadlineExceeded.
+	ErrTimeout = errors.New("run timed out")
+	// ErrPanic is wrapped by the errors of runs and monitors stopped by a
+	// recovered panic.
+	ErrPanic = errors.New("recovered panic")
+)
+
+// kindError is err classified as kind: it matches kind with errors.Is and
+// unwraps to err.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.kind.Error() + ": " + e.err.Error() }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+func (e *kindError) Unwrap() error { return e.err }
+
+// runError classifies an error ending a run as ErrTimeout or ErrPermission
+// where it applies.
+func runError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrTimeout):
+		return &kindError{kind: ErrTimeout, err: err}
+	case isPermission(err) && !errors.Is(err, ErrPermission):
+		return &kindError{kind: ErrPermission, err: err}
+	}
+	return err
+}
+
+// SafeGo runs f in a new goroutine, recovering a panic in f instead of
+// crashing the process. The panic is handed to failed, if set, as an
+// MTRRunError of hop, 0 if f doesn't probe a single hop, naming what
+// panicked.
+func SafeGo(what string, hop int, f func(), failed func(MTRRunError)) {
+	go func() {
+		if e := recoverRun(what, hop, f); e != nil && failed != nil {
+			failed(*e)
+		}
+	}()
+}
+
+// recoverRun runs f and returns a panic in it as an MTRRunError of hop.
+func recoverRun(what string, hop int, f func()) (e *MTRRunError) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = &MTRRunError{Hop: hop, Error: fmt.Sprintf("%s panic: %v", what, r)}
+		}
+	}()
+	f()
+	return nil
+}
+
+// panicError is a recovered panic ending a run or monitor.
+type panicError struct{ msg string }
+
+func (e *panicError) Error() string { return e.msg }
+
+func (e *panicError) Is(target error) bool { return target == ErrPanic }
+
+// err returns e as an error matching ErrPanic.
+func (e MTRRunError) err() error { return &panicError{msg: e.Error} }
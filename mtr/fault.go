@@ -0,0 +1,61 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// ErrInjectedFault is returned by pings failed on purpose by a FaultConfig.
+var ErrInjectedFault = errors.New("injected socket error")
+
+// FaultConfig injects failures into pings so retry and timeout handling can
+// be exercised reproducibly. The zero value injects nothing.
+type FaultConfig struct {
+	DropRate  float64       // fraction of pings whose reply is discarded
+	ErrorRate float64       // fraction of pings failing with ErrInjectedFault
+	Delay     time.Duration // added to every reply, replies later than the timeout are dropped
+	Seed      int64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (f *FaultConfig) roll() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rnd == nil {
+		f.rnd = rand.New(rand.NewSource(f.Seed))
+	}
+	return f.rnd.Float64()
+}
+
+// apply wraps a ping with the configured faults, waiting out Delay on clk.
+func (f *FaultConfig) apply(ctx context.Context, clk Clock, timeout time.Duration, p func() (*traceroute.Reply, error)) (*traceroute.Reply, error) {
+	if f.ErrorRate > 0 && f.roll() < f.ErrorRate {
+		return nil, ErrInjectedFault
+	}
+	r, err := p()
+	if err != nil || r == nil {
+		return r, err
+	}
+	if f.DropRate > 0 && f.roll() < f.DropRate {
+		return nil, nil
+	}
+	if f.Delay > 0 {
+		if r.RTT+f.Delay > timeout {
+			return nil, nil
+		}
+		if err := sleep(ctx, clk, f.Delay); err != nil {
+			return nil, err
+		}
+		d := *r
+		d.RTT += f.Delay
+		r = &d
+	}
+	return r, nil
+}
@@ -0,0 +1,86 @@
+package mtr_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// runFaults runs a trace through a clean three hop fakeNet with faults
+// injected into its pings.
+func runFaults(t *testing.T, faults *mtr.FaultConfig) mtr.MTRReport {
+	t.Helper()
+	op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(8), mtr.WithMaxHops(5), mtr.WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer op.Close()
+	op.Prober = &fakeNet{path: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}
+	op.Faults = faults
+	r, err := op.RunContext(context.Background(), "10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Hups) != 3 {
+		t.Fatalf("%d hups, want 3", len(r.Hups))
+	}
+	return r
+}
+
+func TestFaultsLoseEveryPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults *mtr.FaultConfig
+	}{
+		{"drop", &mtr.FaultConfig{DropRate: 1}},
+		{"error", &mtr.FaultConfig{ErrorRate: 1}},
+		{"delay past timeout", &mtr.FaultConfig{Delay: time.Second}},
+	}
+	for _, tt := range tests {
+		r := runFaults(t, tt.faults)
+		// only the trace, which isn't faulted, was answered
+		for _, h := range r.Hups {
+			if h.Snt != 8 || h.LossPoint != 7 {
+				t.Errorf("%s: hop %d Snt %v, LossPoint %d, want 8, 7", tt.name, h.Count, h.Snt, h.LossPoint)
+			}
+		}
+	}
+}
+
+func TestFaultsDelay(t *testing.T) {
+	clean := runFaults(t, nil)
+	delayed := runFaults(t, &mtr.FaultConfig{Delay: 5 * time.Millisecond})
+	for i, h := range delayed.Hups {
+		c := clean.Hups[i]
+		if h.Loss != 0 {
+			t.Errorf("hop %d lost %v of delayed pings", h.Count, h.Loss)
+		}
+		// the trace answer isn't delayed, the pings are
+		if h.Best != c.Best || math.Abs(h.Wrst-c.Wrst-5) > 1e-9 {
+			t.Errorf("hop %d Best %v, Wrst %v, want %v, %v", h.Count, h.Best, h.Wrst, c.Best, c.Wrst+5)
+		}
+	}
+}
+
+func TestFaultsSeeded(t *testing.T) {
+	lost := func() int {
+		var n int
+		for _, h := range runFaults(t, &mtr.FaultConfig{DropRate: 0.5, Seed: 7}).Hups {
+			n += h.LossPoint
+		}
+		return n
+	}
+	first := lost()
+	if first == 0 || first == 3*7 {
+		t.Errorf("DropRate 0.5 dropped %d of %d pings", first, 3*7)
+	}
+	// the ping workers interleave differently, but draw the same rolls
+	for i := 0; i < 3; i++ {
+		if n := lost(); n != first {
+			t.Errorf("Seed 7 dropped %d pings, then %d", first, n)
+		}
+	}
+}
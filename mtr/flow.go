@@ -0,0 +1,24 @@
+package mtr
+
+// Flow modes. Routers balancing traffic over equal-cost paths (ECMP) hash
+// the flow identifiers of a packet, so probes with varying identifiers can
+// take different paths and mix their statistics.
+const (
+	// FlowDefault lets identifiers vary per probe as each probe mode
+	// naturally does: UDP and TCP ports, ICMP checksums.
+	FlowDefault = ""
+	// FlowParis keeps the 5-tuple and the ICMP checksum constant, as in
+	// Paris traceroute, so all probes to an address follow one path.
+	FlowParis = "paris"
+	// FlowEnumerate pings each hop with TTL-limited probes towards the
+	// destination, each on a new flow, so every router answering at that
+	// TTL shows up in the hop's Hosts.
+	FlowEnumerate = "enumerate"
+)
+
+// parisPayload returns an echo payload that cancels seq out of the ICMP
+// checksum: seq plus its one's complement always sum to 0xffff.
+func parisPayload(seq int) []byte {
+	c := ^uint16(seq)
+	return []byte{byte(c >> 8), byte(c)}
+}
@@ -0,0 +1,95 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// GeoInfo is where an address is located.
+type GeoInfo struct {
+	City    string  `json:"city,omitempty"`
+	Country string  `json:"country,omitempty"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+// GeoSource maps an address to its location. Addresses it doesn't know
+// return a zero GeoInfo and no error. *MMDB implements it.
+type GeoSource interface {
+	LookupGeo(ctx context.Context, addr string) (GeoInfo, error)
+}
+
+// annotateGeo sets Geo on every responding hup the source locates.
+func annotateGeo(ctx context.Context, src GeoSource, hups []*MTRHup) {
+	var wg sync.WaitGroup
+	for _, h := range hups {
+		if h.Host == "???" {
+			continue
+		}
+		wg.Add(1)
+		go func(h *MTRHup) {
+			defer wg.Done()
+			g, err := src.LookupGeo(ctx, h.Host)
+			if err != nil || g == (GeoInfo{}) {
+				return
+			}
+			h.Geo = &g
+		}(h)
+	}
+	wg.Wait()
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// ToGeoJSON converts the report to a GeoJSON FeatureCollection for mapping
+// tools: a LineString along the located hops followed by a Point per
+// located hop. Hops without Geo are left out.
+func (r MTRReport) ToGeoJSON() (string, error) {
+	line := [][2]float64{}
+	var points []geoJSONFeature
+	for _, h := range r.Hups {
+		if h.Geo == nil {
+			continue
+		}
+		pos := [2]float64{h.Geo.Lon, h.Geo.Lat}
+		line = append(line, pos)
+		points = append(points, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: pos},
+			Properties: map[string]interface{}{
+				"hop":      h.Count,
+				"host":     h.Host,
+				"hostname": h.Hostname,
+				"city":     h.Geo.City,
+				"country":  h.Geo.Country,
+				"loss":     h.Loss,
+				"avg":      h.Avg,
+			},
+		})
+	}
+	fc := struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}{Type: "FeatureCollection"}
+	fc.Features = append(fc.Features, geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: line},
+		Properties: map[string]interface{}{"id": r.ID, "src": r.Src, "dst": r.Dst},
+	})
+	fc.Features = append(fc.Features, points...)
+	b, err := json.Marshal(fc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
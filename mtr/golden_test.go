@@ -0,0 +1,118 @@
+package mtr_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/SgtDaJim/op-mtr/mtr/mtrtest"
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden reports in testdata")
+
+// fakeNet is a Prober answering from a scripted path. A probe to a hop of
+// the path, or beyond it, is answered by the hop at its TTL or the target,
+// whichever comes first, after n ms for the hop at TTL n plus 0.1 ms per
+// earlier probe to the same address and TTL. So reports don't depend on
+// how the ping workers interleave.
+type fakeNet struct {
+	// path are the hops' addresses, "" for a silent hop.
+	path []string
+	// drop, if set, drops every drop[n]-th probe answered at TTL n.
+	drop map[int]int
+
+	mu   sync.Mutex
+	sent map[string]int
+}
+
+func (f *fakeNet) Trace(ctx context.Context, dst net.IP, cfg mtr.TraceConfig, add func(*traceroute.Reply)) error {
+	return mtr.StepTrace(ctx, f.Probe, dst, cfg, add)
+}
+
+func (f *fakeNet) Probe(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hop := len(f.path)
+	for i, h := range f.path {
+		if h == ip {
+			hop = i + 1
+		}
+	}
+	if ttl < hop {
+		hop = ttl
+	}
+	f.mu.Lock()
+	if f.sent == nil {
+		f.sent = map[string]int{}
+	}
+	key := fmt.Sprintf("%s/%d", ip, ttl)
+	seq := f.sent[key]
+	f.sent[key]++
+	f.mu.Unlock()
+	host := f.path[hop-1]
+	if d := f.drop[hop]; host == "" || (d > 0 && seq%d == d-1) {
+		return nil, nil
+	}
+	rtt := time.Duration(hop)*time.Millisecond + time.Duration(seq)*100*time.Microsecond
+	return &traceroute.Reply{IP: net.ParseIP(host), RTT: rtt, Hops: ttl}, nil
+}
+
+func TestGoldenReports(t *testing.T) {
+	tests := []struct {
+		name string
+		net  *fakeNet
+	}{
+		{"clean", &fakeNet{path: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}},
+		{"lossy", &fakeNet{path: []string{"10.0.0.1", "", "10.0.0.3"}, drop: map[int]int{3: 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(8), mtr.WithMaxHops(5), mtr.WithTimeout(50*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer op.Close()
+			op.Prober = tt.net
+			r, err := op.RunContext(context.Background(), "10.0.0.3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", tt.name+".json")
+			if *update {
+				if err := mtrtest.WriteGolden(path, r); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			golden, err := mtrtest.ReadGolden(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to record it)", err)
+			}
+			for _, d := range mtrtest.Diff(golden, r) {
+				t.Error(d)
+			}
+			got, err := mtrtest.Normalize(r).ToJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := golden.ToJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("ToJSON = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
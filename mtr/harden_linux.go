@@ -0,0 +1,79 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package mtr
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs   = 38
+	seccompModeFilter = 1
+	seccompFlagTsync  = 1
+	seccompRetKill    = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+	seccompRetErrno   = 0x00050000
+	seccompRetAllow   = 0x7fff0000
+)
+
+// Harden installs a seccomp filter on every thread of the process that
+// fails the system calls a probing agent never needs with EPERM: running
+// programs, tracing other processes, mounts and namespaces, kernel
+// modules, keyrings, BPF and setting the clock. It also sets no_new_privs.
+// Call it once initialization is done; it cannot be undone.
+//
+// The filter denies a fixed list rather than allowing only what probing
+// uses, since the Go runtime and resolver need system calls that vary
+// between releases.
+func Harden() error {
+	filter := hardenFilter()
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// no_new_privs is per thread; TSYNC copies it with the filter
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("harden: no_new_privs: %w", errno)
+	}
+	r, _, errno := syscall.RawSyscall(sysSeccomp, seccompModeFilter, seccompFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("harden: seccomp: %w", errno)
+	}
+	if r != 0 {
+		return fmt.Errorf("harden: seccomp: thread %d could not be synchronized", r)
+	}
+	return nil
+}
+
+// hardenFilter returns the BPF program checking struct seccomp_data: the
+// system call number at offset 0 and the architecture at 4.
+func hardenFilter() []syscall.SockFilter {
+	stmt := func(code uint16, k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, K: k}
+	}
+	jeq := func(k uint32, jt, jf uint8) syscall.SockFilter {
+		return syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: jt, Jf: jf, K: k}
+	}
+	deny := stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM))
+
+	f := []syscall.SockFilter{
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 4),
+		jeq(auditArch, 1, 0),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKill),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0),
+	}
+	if sysX32 != 0 {
+		f = append(f,
+			syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K, Jt: 0, Jf: 1, K: sysX32},
+			deny)
+	}
+	for _, nr := range deniedSyscalls {
+		f = append(f, jeq(nr, 0, 1), deny)
+	}
+	return append(f, stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow))
+}
@@ -0,0 +1,19 @@
+package mtr
+
+const (
+	auditArch  = 0xc000003e // AUDIT_ARCH_X86_64
+	sysSeccomp = 317
+	// x32 system calls set this bit and bypass the numbers below
+	sysX32 = 0x40000000
+)
+
+var deniedSyscalls = []uint32{
+	59, 322, // execve, execveat
+	101, 310, 311, // ptrace, process_vm_readv, process_vm_writev
+	165, 166, 155, 161, 272, 308, // mount, umount2, pivot_root, chroot, unshare, setns
+	175, 313, 176, 246, 320, 169, // init_module, finit_module, delete_module, kexec_load, kexec_file_load, reboot
+	167, 168, 163, 304, // swapon, swapoff, acct, open_by_handle_at
+	248, 249, 250, // add_key, request_key, keyctl
+	321, 298, 323, // bpf, perf_event_open, userfaultfd
+	164, 227, // settimeofday, clock_settime
+}
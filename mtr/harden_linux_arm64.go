@@ -0,0 +1,18 @@
+package mtr
+
+const (
+	auditArch  = 0xc00000b7 // AUDIT_ARCH_AARCH64
+	sysSeccomp = 277
+	sysX32     = 0
+)
+
+var deniedSyscalls = []uint32{
+	221, 281, // execve, execveat
+	117, 270, 271, // ptrace, process_vm_readv, process_vm_writev
+	40, 39, 41, 51, 97, 268, // mount, umount2, pivot_root, chroot, unshare, setns
+	105, 273, 106, 104, 294, 142, // init_module, finit_module, delete_module, kexec_load, kexec_file_load, reboot
+	224, 225, 89, 265, // swapon, swapoff, acct, open_by_handle_at
+	217, 218, 219, // add_key, request_key, keyctl
+	280, 241, 282, // bpf, perf_event_open, userfaultfd
+	170, 112, // settimeofday, clock_settime
+}
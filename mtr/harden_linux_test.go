@@ -0,0 +1,39 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package mtr
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// TestHarden runs itself again in a child process, which hardens and then
+// checks that programs can't run while sockets still open.
+func TestHarden(t *testing.T) {
+	if os.Getenv("OPMTR_HARDEN_CHILD") != "" {
+		if err := Harden(); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec.Command(os.Args[0], "-test.run=^$").Run(); !errors.Is(err, syscall.EPERM) {
+			t.Fatalf("exec after Harden: %v, want EPERM", err)
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen after Harden: %v", err)
+		}
+		l.Close()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHarden$", "-test.v")
+	cmd.Env = append(os.Environ(), "OPMTR_HARDEN_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("hardened child: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+package mtr
+
+import "errors"
+
+// Harden is only implemented on Linux on amd64 and arm64.
+func Harden() error {
+	return errors.New("harden: seccomp filtering requires Linux on amd64 or arm64")
+}
@@ -0,0 +1,76 @@
+package mtr
+
+import "time"
+
+// DefaultHealthWindow is the window path stability is scored over when
+// HealthScorer.StabilityWindow is zero.
+const DefaultHealthWindow = 24 * time.Hour
+
+// HealthWeights weigh the components of a health score. The zero value
+// means the defaults: loss 0.5, latency 0.3, stability 0.2.
+type HealthWeights struct {
+	Loss      float64 `json:"loss"`
+	Latency   float64 `json:"latency"`
+	Stability float64 `json:"stability"`
+}
+
+// HealthScorer rates targets and groups on a 0 (down) to 100 (healthy)
+// scale from end-to-end loss, latency against a baseline and path
+// stability.
+type HealthScorer struct {
+	Weights HealthWeights
+	// Baselines maps a destination to its expected end-to-end average RTT
+	// in milliseconds. Destinations without one get no latency penalty.
+	Baselines map[string]float64
+	// PathDB, if set, supplies how steady the path to a destination is:
+	// the modal ratio of its runs over StabilityWindow, DefaultHealthWindow
+	// if zero.
+	PathDB          *PathDB
+	StabilityWindow time.Duration
+}
+
+// Score rates one report.
+func (s HealthScorer) Score(r MTRReport) float64 {
+	w := s.Weights
+	if w == (HealthWeights{}) {
+		w = HealthWeights{Loss: 0.5, Latency: 0.3, Stability: 0.2}
+	}
+	h := lastHup(r)
+
+	loss := 1 - h.Loss
+	latency := 1.0
+	if base := s.Baselines[r.Dst]; base > 0 && h.Avg > base {
+		latency = base / h.Avg
+	}
+	if h.Loss >= 1 {
+		latency = 0
+	}
+	stability := 1.0
+	if s.PathDB != nil {
+		window := s.StabilityWindow
+		if window == 0 {
+			window = DefaultHealthWindow
+		}
+		if st, ok := s.PathDB.Stability(r.Src, r.Dst, window); ok {
+			stability = st.Modal
+		}
+	}
+
+	total := w.Loss + w.Latency + w.Stability
+	if total <= 0 {
+		return 0
+	}
+	return 100 * (w.Loss*loss + w.Latency*latency + w.Stability*stability) / total
+}
+
+// GroupScore is the mean score of a group's reports.
+func (s HealthScorer) GroupScore(reports []MTRReport) float64 {
+	if len(reports) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range reports {
+		sum += s.Score(r)
+	}
+	return sum / float64(len(reports))
+}
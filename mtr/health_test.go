@@ -0,0 +1,49 @@
+package mtr
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHealthScoreStabilityWindow(t *testing.T) {
+	db := NewPathDB()
+	run := func(ts int64, hops ...string) MTRReport {
+		r := MTRReport{Src: "192.0.2.1", Dst: "10.0.0.9", Time: ts}
+		for i, h := range hops {
+			r.Hups = append(r.Hups, MTRHup{Count: i + 1, Host: h})
+		}
+		r.Hups[len(r.Hups)-1].Host = "10.0.0.9"
+		db.Update(r)
+		return r
+	}
+	// flapping a day ago, steady over the last hour
+	day := int64(24 * 3600)
+	for i := int64(0); i < 4; i++ {
+		run(1000+i, "10.0.0.1", "10.0.0.2", "")
+		run(1000+i, "10.0.0.1", "10.0.0.3", "")
+	}
+	var r MTRReport
+	for i := int64(0); i < 8; i++ {
+		r = run(1000+day+i, "10.0.0.1", "10.0.0.2", "")
+	}
+	only := HealthWeights{Stability: 1}
+	tests := []struct {
+		window time.Duration
+		want   float64
+	}{
+		{time.Hour, 100},
+		// 12 of the 16 runs took the modal path
+		{48 * time.Hour, 75},
+	}
+	for _, tt := range tests {
+		s := HealthScorer{Weights: only, PathDB: db, StabilityWindow: tt.window}
+		if got := s.Score(r); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("window %v: Score = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+	// zero means DefaultHealthWindow
+	if got := (HealthScorer{Weights: only, PathDB: db}).Score(r); got != 100 {
+		t.Errorf("default window: Score = %v, want 100", got)
+	}
+}
@@ -0,0 +1,19 @@
+package mtr
+
+import "time"
+
+// Hooks receive progress events while a run is in flight, so UIs can render
+// it before the final report. Any of them may be nil. Run pings hups
+// concurrently, so hooks must be safe for concurrent use.
+type Hooks struct {
+	// OnTraceReply is called for every hop found by the trace.
+	OnTraceReply func(hop int, host string, rtt time.Duration)
+	// OnPingSent is called before each ping on behalf of hop.
+	OnPingSent func(hop int, host string)
+	// OnPingReply is called after each ping with the replying host and
+	// RTT, or with lost set if no reply arrived.
+	OnPingReply func(hop int, from string, rtt time.Duration, lost bool)
+	// OnHopComplete is called with the final statistics of a hop once its
+	// pings are over.
+	OnHopComplete func(h MTRHup)
+}
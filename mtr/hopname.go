@@ -0,0 +1,88 @@
+package mtr
+
+import (
+	"strings"
+	"text/template"
+)
+
+// HopName is the data of hop display-name templates.
+type HopName struct {
+	Hop      int
+	IP       string
+	Hostname string
+	// ASN is like "AS13335", or empty if unknown.
+	ASN      string
+	ASName   string
+	Location string
+	Iface    string
+	Note     string
+}
+
+// HopTemplate renders hop display names, so hops are labeled the same way
+// by every renderer: PrettyPrint, ToReport, the live table and the web UI.
+// Set it as OPMTR.HopNames.
+type HopTemplate struct {
+	t *template.Template
+}
+
+var hopNameFuncs = template.FuncMap{
+	// default returns v, or def if v is empty, as in
+	// {{.Hostname | default .IP}}.
+	"default": func(def, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// ParseHopTemplate parses a text/template executed with a HopName, e.g.
+// "{{.ASN}} {{.Hostname | default .IP}}".
+func ParseHopTemplate(text string) (*HopTemplate, error) {
+	t, err := template.New("hop").Funcs(hopNameFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// catch references to unknown fields now rather than on every hop
+	if err := t.Execute(new(strings.Builder), HopName{}); err != nil {
+		return nil, err
+	}
+	return &HopTemplate{t}, nil
+}
+
+// Name renders the display name of h, or returns "" if it doesn't respond
+// or the template fails.
+func (t *HopTemplate) Name(h MTRHup) string {
+	if h.Host == "???" {
+		return ""
+	}
+	n := HopName{Hop: h.Count, IP: h.Host, Hostname: h.Hostname, ASName: h.ASName,
+		Location: h.Location, Iface: h.Iface, Note: h.Note}
+	if h.ASN != 0 {
+		n.ASN = asLabel(h.ASN)
+	}
+	var b strings.Builder
+	if err := t.t.Execute(&b, n); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// annotate sets the Display of every hup of r.
+func (t *HopTemplate) annotate(r *MTRReport) {
+	for i := range r.Hups {
+		r.Hups[i].Display = t.Name(r.Hups[i])
+	}
+}
+
+// DisplayName returns the name renderers label the hup with: Display,
+// else Hostname, else Host.
+func (h MTRHup) DisplayName() string {
+	switch {
+	case h.Display != "":
+		return h.Display
+	case h.Hostname != "":
+		return h.Hostname
+	}
+	return h.Host
+}
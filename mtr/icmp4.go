@@ -0,0 +1,145 @@
+package mtr
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// icmp4Prober sends ICMP echo requests over one raw socket shared by all
+// pings of an OPMTR, matching replies to probes by sequence number like
+// icmp6Prober instead of setting up a Tracer session per ping.
+type icmp4Prober struct {
+	src    string
+	labels *labelBook
+
+	once sync.Once
+	conn *icmp.PacketConn
+	err  error
+	id   int
+
+	// sendMu serializes setting the TTL and sending, since IPv4 can't carry
+	// the TTL per packet.
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]*pendingProbe
+}
+
+func newICMP4Prober(src string, labels *labelBook) *icmp4Prober {
+	return &icmp4Prober{
+		src:     src,
+		labels:  labels,
+		id:      rand.Intn(0xffff) + 1,
+		pending: map[int]*pendingProbe{},
+	}
+}
+
+func (p *icmp4Prober) init() {
+	p.conn, p.err = icmp.ListenPacket("ip4:icmp", p.src)
+	if p.err != nil {
+		return
+	}
+	metrics.Add("open_sockets", 1)
+	go p.serve()
+}
+
+// probe sends one echo request to ip limited to ttl hops, carrying payload
+// if not nil. With paris the checksum is held constant across probes.
+func (p *icmp4Prober) probe(ctx context.Context, ip string, ttl int, timeout time.Duration, paris bool, payload []byte) (*traceroute.Reply, error) {
+	p.once.Do(p.init)
+	if p.err != nil {
+		return nil, p.err
+	}
+	dst := net.ParseIP(ip)
+
+	p.mu.Lock()
+	p.seq = (p.seq + 1) & 0xffff
+	seq := p.seq
+	pr := &pendingProbe{ch: make(chan probeAnswer, 1)}
+	p.pending[seq] = pr
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+	}()
+
+	echo := &icmp.Echo{ID: p.id, Seq: seq, Data: echoPayload(payload, seq, paris)}
+	msg := icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	p.sendMu.Lock()
+	pc := p.conn.IPv4PacketConn()
+	if err = pc.SetTTL(ttl); err == nil {
+		pr.sent = time.Now()
+		_, err = pc.WriteTo(b, nil, &net.IPAddr{IP: dst})
+	}
+	p.sendMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *icmp4Prober) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		r, ok := parseEchoReply(protocolICMP, buf[:n])
+		if !ok || r.id != p.id {
+			continue
+		}
+		p.mu.Lock()
+		pr, ok := p.pending[r.seq]
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+		addr, _ := from.(*net.IPAddr)
+		if addr == nil {
+			continue
+		}
+		p.labels.record(addr.IP, r.quoted, r.exts, now)
+		pr.answer(addr.IP, now, r.unreach)
+	}
+}
+
+// quotedEcho4 extracts the echo ID and sequence number from the invoking
+// packet quoted in an ICMP error, or returns zeros.
+func quotedEcho4(b []byte) (id, seq int) {
+	proto, e := quotedTransport(b)
+	if proto != protocolICMP || e == nil || e[0] != byte(ipv4.ICMPTypeEcho) {
+		return 0, 0
+	}
+	return int(e[4])<<8 | int(e[5]), int(e[6])<<8 | int(e[7])
+}
+
+func (p *icmp4Prober) close() {
+	p.once.Do(func() {})
+	if p.conn != nil {
+		p.conn.Close()
+		metrics.Add("open_sockets", -1)
+	}
+}
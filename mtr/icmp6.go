@@ -0,0 +1,153 @@
+package mtr
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// protocolICMPv6 is the IANA protocol number of ICMPv6.
+const protocolICMPv6 = 58
+
+// icmp6Prober sends ICMPv6 echo requests with a given hop limit over one raw
+// socket and matches echo replies, Time Exceeded and Destination Unreachable
+// messages back to the probe by echo ID and sequence number.
+type icmp6Prober struct {
+	src    string
+	labels *labelBook
+
+	once sync.Once
+	conn *icmp.PacketConn
+	err  error
+	id   int
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]*pendingProbe
+}
+
+// pendingProbe is a probe waiting for its reply.
+type pendingProbe struct {
+	dst  net.IP
+	sent time.Time
+	ch   chan probeAnswer
+}
+
+func newICMP6Prober(src string, labels *labelBook) *icmp6Prober {
+	return &icmp6Prober{
+		src:     src,
+		labels:  labels,
+		id:      rand.Intn(0xffff) + 1,
+		pending: map[int]*pendingProbe{},
+	}
+}
+
+func (p *icmp6Prober) init() {
+	p.conn, p.err = icmp.ListenPacket("ip6:ipv6-icmp", p.src)
+	if p.err != nil {
+		return
+	}
+	metrics.Add("open_sockets", 1)
+	go p.serve()
+}
+
+// probe sends one echo request to ip limited to ttl hops, carrying payload
+// if not nil. With paris the checksum is held constant across probes.
+func (p *icmp6Prober) probe(ctx context.Context, ip string, ttl int, timeout time.Duration, paris bool, payload []byte) (*traceroute.Reply, error) {
+	p.once.Do(p.init)
+	if p.err != nil {
+		return nil, p.err
+	}
+	dst := net.ParseIP(ip)
+
+	p.mu.Lock()
+	p.seq = (p.seq + 1) & 0xffff
+	seq := p.seq
+	pr := &pendingProbe{ch: make(chan probeAnswer, 1)}
+	p.pending[seq] = pr
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+	}()
+
+	echo := &icmp.Echo{ID: p.id, Seq: seq, Data: echoPayload(payload, seq, paris)}
+	msg := icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: echo}
+	// the kernel fills in the ICMPv6 checksum on raw sockets
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	pr.sent = time.Now()
+	cm := &ipv6.ControlMessage{HopLimit: ttl}
+	if _, err := p.conn.IPv6PacketConn().WriteTo(b, cm, &net.IPAddr{IP: dst}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *icmp6Prober) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		r, ok := parseEchoReply(protocolICMPv6, buf[:n])
+		if !ok || r.id != p.id {
+			continue
+		}
+		p.mu.Lock()
+		pr, ok := p.pending[r.seq]
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+		addr, _ := from.(*net.IPAddr)
+		if addr == nil {
+			continue
+		}
+		p.labels.record(addr.IP, r.quoted, r.exts, now)
+		pr.answer(addr.IP, now, r.unreach)
+	}
+}
+
+// quotedEcho6 extracts the echo ID and sequence number from the invoking
+// packet quoted in an ICMPv6 error, or returns zeros.
+func quotedEcho6(b []byte) (id, seq int) {
+	if len(b) < ipv6.HeaderLen+8 || b[0]>>4 != ipv6.Version {
+		return 0, 0
+	}
+	if b[6] != protocolICMPv6 {
+		return 0, 0
+	}
+	e := b[ipv6.HeaderLen:]
+	if e[0] != byte(ipv6.ICMPTypeEchoRequest) {
+		return 0, 0
+	}
+	return int(e[4])<<8 | int(e[5]), int(e[6])<<8 | int(e[7])
+}
+
+func (p *icmp6Prober) close() {
+	p.once.Do(func() {})
+	if p.conn != nil {
+		p.conn.Close()
+		metrics.Add("open_sockets", -1)
+	}
+}
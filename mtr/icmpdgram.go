@@ -0,0 +1,163 @@
+package mtr
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dgramProber sends ICMP echo requests over ICMP datagram ("ping") sockets,
+// which unprivileged processes may open where the kernel allows it, one per
+// address family. Routers on the way are learnt from the sockets' error
+// queue, so traces work without raw sockets. The kernel picks the echo ID,
+// so replies are matched by sequence number alone.
+type dgramProber struct {
+	v4, v6 *dgramSocket
+
+	detect   sync.Once
+	datagram bool
+}
+
+type dgramSocket struct {
+	v4  bool
+	src string
+
+	once sync.Once
+	conn net.PacketConn
+	err  error
+
+	// sendMu serializes setting the TTL and sending.
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]*pendingProbe
+}
+
+func newDgramProber(src4, src6 string) *dgramProber {
+	return &dgramProber{
+		v4: &dgramSocket{v4: true, src: src4, pending: map[int]*pendingProbe{}},
+		v6: &dgramSocket{src: src6, pending: map[int]*pendingProbe{}},
+	}
+}
+
+// auto reports whether DetectBackend picks datagram sockets, checking once.
+func (p *dgramProber) auto() bool {
+	p.detect.Do(func() {
+		b, _ := DetectBackend()
+		p.datagram = b == BackendDatagram
+	})
+	return p.datagram
+}
+
+func (p *dgramProber) socket(ip net.IP) *dgramSocket {
+	if ip.To4() != nil {
+		return p.v4
+	}
+	return p.v6
+}
+
+func (p *dgramProber) close() {
+	for _, s := range []*dgramSocket{p.v4, p.v6} {
+		s.once.Do(func() { s.err = net.ErrClosed })
+		// a socket failing to open is closed already
+		if s.conn != nil && s.err == nil {
+			s.conn.Close()
+			metrics.Add("open_sockets", -1)
+		}
+	}
+}
+
+// datagram reports whether op probes with ICMP datagram sockets.
+func (op *OPMTR) datagram() bool {
+	switch op.Backend {
+	case BackendRaw:
+		return false
+	case BackendDatagram:
+		return true
+	}
+	return op.dgram.auto()
+}
+
+// probe sends one echo request to ip limited to ttl hops, carrying payload
+// if not nil. With paris the checksum is held constant across probes.
+func (p *dgramProber) probe(ctx context.Context, ip string, ttl int, timeout time.Duration, paris bool, payload []byte) (*traceroute.Reply, error) {
+	dst := net.ParseIP(ip)
+	s := p.socket(dst)
+	s.once.Do(s.open)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	s.mu.Lock()
+	s.seq = (s.seq + 1) & 0xffff
+	seq := s.seq
+	pr := &pendingProbe{dst: dst, ch: make(chan probeAnswer, 1)}
+	s.pending[seq] = pr
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		s.mu.Unlock()
+	}()
+
+	echo := &icmp.Echo{Seq: seq, Data: echoPayload(payload, seq, paris)}
+	var msg icmp.Message
+	if s.v4 {
+		msg = icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}
+	} else {
+		msg = icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: echo}
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sendMu.Lock()
+	if s.v4 {
+		err = ipv4.NewPacketConn(s.conn).SetTTL(ttl)
+	} else {
+		err = ipv6.NewPacketConn(s.conn).SetHopLimit(ttl)
+	}
+	if err == nil {
+		pr.sent = time.Now()
+		_, err = s.conn.WriteTo(b, &net.UDPAddr{IP: dst})
+	}
+	s.sendMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deliver hands the reply from ip to the probe with sequence number seq.
+func (s *dgramSocket) deliver(seq int, ip net.IP, unreach *UnreachableError, now time.Time) {
+	s.mu.Lock()
+	pr, ok := s.pending[seq]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	pr.answer(ip, now, unreach)
+}
+
+// echoSeq returns the sequence number of an ICMP echo message.
+func echoSeq(b []byte) (int, bool) {
+	if len(b) < 8 {
+		return 0, false
+	}
+	return int(b[6])<<8 | int(b[7]), true
+}
@@ -0,0 +1,171 @@
+package mtr
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// datagramTraces is set where ICMP datagram sockets can trace, i.e. report
+// TTL-exceeded errors through IP_RECVERR.
+const datagramTraces = true
+
+// Origins of a sock_extended_err, from linux/errqueue.h.
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+func (s *dgramSocket) open() {
+	family, proto, level, opt := syscall.AF_INET, syscall.IPPROTO_ICMP, syscall.IPPROTO_IP, syscall.IP_RECVERR
+	if !s.v4 {
+		family, proto, level, opt = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		s.err = os.NewSyscallError("socket", err)
+		return
+	}
+	if err := syscall.SetsockoptInt(fd, level, opt, 1); err != nil {
+		syscall.Close(fd)
+		s.err = os.NewSyscallError("setsockopt", err)
+		return
+	}
+	var sa syscall.Sockaddr
+	if ip := net.ParseIP(s.src); s.v4 {
+		a := &syscall.SockaddrInet4{}
+		copy(a.Addr[:], ip.To4())
+		sa = a
+	} else {
+		a := &syscall.SockaddrInet6{}
+		copy(a.Addr[:], ip.To16())
+		sa = a
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		s.err = os.NewSyscallError("bind", err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "icmp-datagram")
+	s.conn, s.err = net.FilePacketConn(f)
+	f.Close()
+	if s.err != nil {
+		return
+	}
+	sc, ok := s.conn.(syscall.Conn)
+	if !ok {
+		s.conn.Close()
+		s.err = os.ErrInvalid
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		s.conn.Close()
+		s.err = err
+		return
+	}
+	metrics.Add("open_sockets", 1)
+	go s.serve(raw)
+}
+
+// serve reads echo replies and the error queue until the socket is closed.
+func (s *dgramSocket) serve(raw syscall.RawConn) {
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	for {
+		var n, oobn int
+		var from syscall.Sockaddr
+		var queued bool
+		var rerr error
+		err := raw.Read(func(fd uintptr) bool {
+			n, oobn, _, from, rerr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_ERRQUEUE)
+			if rerr == nil {
+				queued = true
+				return true
+			}
+			n, _, _, from, rerr = syscall.Recvmsg(int(fd), buf, nil, 0)
+			return rerr != syscall.EAGAIN
+		})
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		if rerr != nil {
+			// a plain read reporting an error that is also queued
+			continue
+		}
+		if queued {
+			s.handleQueued(buf[:n], oob[:oobn], now)
+			continue
+		}
+		if n < 8 || (buf[0] != 0 && buf[0] != 129) {
+			// not an echo reply
+			continue
+		}
+		if seq, ok := echoSeq(buf[:n]); ok {
+			s.deliver(seq, sockaddrIP(from), nil, now)
+		}
+	}
+}
+
+// handleQueued delivers an ICMP error from the error queue: b is the echo
+// request it quotes and oob holds the sock_extended_err naming the router.
+func (s *dgramSocket) handleQueued(b, oob []byte, now time.Time) {
+	seq, ok := echoSeq(b)
+	if !ok {
+		return
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if !(m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR) &&
+			!(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+		// struct sock_extended_err, then the offender's sockaddr
+		d := m.Data
+		if len(d) < 16 || (d[4] != soEEOriginICMP && d[4] != soEEOriginICMP6) {
+			continue
+		}
+		var unreach *UnreachableError
+		if (d[4] == soEEOriginICMP && d[5] == 3) || (d[4] == soEEOriginICMP6 && d[5] == 1) {
+			unreach = &UnreachableError{Code: int(d[6])}
+		}
+		if ip := offenderIP(d[16:]); ip != nil {
+			s.deliver(seq, ip, unreach, now)
+		}
+	}
+}
+
+// offenderIP decodes the sockaddr_in or sockaddr_in6 following a
+// sock_extended_err.
+func offenderIP(b []byte) net.IP {
+	if len(b) < 2 {
+		return nil
+	}
+	switch *(*uint16)(unsafe.Pointer(&b[0])) {
+	case syscall.AF_INET:
+		if len(b) >= 8 {
+			return net.IP(append([]byte(nil), b[4:8]...))
+		}
+	case syscall.AF_INET6:
+		if len(b) >= 24 {
+			return net.IP(append([]byte(nil), b[8:24]...))
+		}
+	}
+	return nil
+}
+
+func sockaddrIP(sa syscall.Sockaddr) net.IP {
+	switch a := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(append([]byte(nil), a.Addr[:]...))
+	case *syscall.SockaddrInet6:
+		return net.IP(append([]byte(nil), a.Addr[:]...))
+	}
+	return nil
+}
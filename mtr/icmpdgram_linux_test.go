@@ -0,0 +1,66 @@
+package mtr
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// queuedEcho is the echo request with sequence number 2 returned from the
+// error queue.
+var queuedEcho = []byte{8, 0, 0, 0, 0, 1, 0, 2}
+
+// queuedError returns the control message of a Time Exceeded read from the
+// error queue: a sock_extended_err of origin followed by the sockaddr sa.
+func queuedError(level, typ int32, origin byte, sa []byte) []byte {
+	data := append(make([]byte, 16), sa...)
+	data[4], data[5] = origin, 11
+	oob := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = level, typ
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(oob[syscall.CmsgLen(0):], data)
+	return oob
+}
+
+var (
+	queuedError4 = queuedError(syscall.IPPROTO_IP, syscall.IP_RECVERR, soEEOriginICMP,
+		[]byte{syscall.AF_INET, 0, 0, 0, 10, 0, 0, 1})
+	queuedError6 = queuedError(syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, soEEOriginICMP6,
+		append([]byte{syscall.AF_INET6, 0, 0, 0, 0, 0, 0, 0, 0x20, 0x01, 0x0d, 0xb8}, make([]byte, 16)...))
+)
+
+func TestHandleQueued(t *testing.T) {
+	for _, c := range []struct {
+		oob  []byte
+		from net.IP
+	}{
+		{queuedError4, net.IPv4(10, 0, 0, 1)},
+		{queuedError6, net.ParseIP("2001:db8::")},
+	} {
+		pr := &pendingProbe{ch: make(chan probeAnswer, 1)}
+		s := &dgramSocket{pending: map[int]*pendingProbe{2: pr}}
+		s.handleQueued(queuedEcho, c.oob, time.Now())
+		select {
+		case a := <-pr.ch:
+			if !a.reply.IP.Equal(c.from) {
+				t.Errorf("answered by %v, want %v", a.reply.IP, c.from)
+			}
+		default:
+			t.Errorf("no answer from %v", c.from)
+		}
+	}
+}
+
+// FuzzQueuedError feeds quoted echo requests and control messages to the
+// error queue handling of ICMP datagram sockets.
+func FuzzQueuedError(f *testing.F) {
+	f.Add(queuedEcho, queuedError4)
+	f.Add(queuedEcho, queuedError6)
+	f.Fuzz(func(t *testing.T, b, oob []byte) {
+		s := &dgramSocket{pending: map[int]*pendingProbe{2: {ch: make(chan probeAnswer, 1)}}}
+		s.handleQueued(b, oob, time.Now())
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package mtr
+
+import (
+	"errors"
+	"runtime"
+)
+
+// datagramTraces is set where ICMP datagram sockets can trace. Elsewhere
+// they don't report the routers whose TTL-exceeded errors they receive.
+const datagramTraces = false
+
+func (s *dgramSocket) open() {
+	s.err = errors.New("ICMP datagram traces are not supported on " + runtime.GOOS)
+}
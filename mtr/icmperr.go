@@ -0,0 +1,161 @@
+package mtr
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// protocolICMP is the IANA protocol number of ICMP.
+const protocolICMP = 1
+
+// icmpListener reads ICMP and ICMPv6 error messages (Time Exceeded and
+// Destination Unreachable) from one raw socket per family and hands the
+// packet quoted in them to handle, with unreach set for Destination
+// Unreachable. MPLS label stacks in them are recorded in labels.
+type icmpListener struct {
+	handle func(from net.IP, quoted []byte, unreach *UnreachableError, now time.Time)
+	labels *labelBook
+
+	once4, once6 sync.Once
+	conn4, conn6 *icmp.PacketConn
+	err4, err6   error
+}
+
+func (l *icmpListener) listen(v4 bool) error {
+	if v4 {
+		l.once4.Do(func() {
+			l.conn4, l.err4 = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+			if l.err4 == nil {
+				metrics.Add("open_sockets", 1)
+				go l.serve(l.conn4, protocolICMP)
+			}
+		})
+		return l.err4
+	}
+	l.once6.Do(func() {
+		l.conn6, l.err6 = icmp.ListenPacket("ip6:ipv6-icmp", "::")
+		if l.err6 == nil {
+			metrics.Add("open_sockets", 1)
+			go l.serve(l.conn6, protocolICMPv6)
+		}
+	})
+	return l.err6
+}
+
+func (l *icmpListener) serve(conn *icmp.PacketConn, proto int) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if addr, _ := from.(*net.IPAddr); addr != nil {
+			l.receive(proto, addr.IP, buf[:n], time.Now())
+		}
+	}
+}
+
+// receive hands the ICMP error b sent by from to handle.
+func (l *icmpListener) receive(proto int, from net.IP, b []byte, now time.Time) {
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return
+	}
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		l.labels.record(from, body.Data, body.Extensions, now)
+		l.handle(from, body.Data, nil, now)
+	case *icmp.DstUnreach:
+		l.labels.record(from, body.Data, body.Extensions, now)
+		l.handle(from, body.Data, &UnreachableError{Code: msg.Code}, now)
+	}
+}
+
+// echoReply is an echo reply, or an ICMP error quoting an echo request,
+// read by the raw ICMP probers.
+type echoReply struct {
+	id, seq int
+	unreach *UnreachableError
+	quoted  []byte
+	exts    []icmp.Extension
+}
+
+// parseEchoReply decodes the ICMP (proto protocolICMP) or ICMPv6 message
+// b, reporting false for anything but an echo reply or an error.
+func parseEchoReply(proto int, b []byte) (echoReply, bool) {
+	var r echoReply
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return r, false
+	}
+	quotedEcho, reply := quotedEcho4, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if proto == protocolICMPv6 {
+		quotedEcho, reply = quotedEcho6, ipv6.ICMPTypeEchoReply
+	}
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if msg.Type != reply {
+			return r, false
+		}
+		r.id, r.seq = body.ID, body.Seq
+	case *icmp.TimeExceeded:
+		r.id, r.seq = quotedEcho(body.Data)
+		r.quoted, r.exts = body.Data, body.Extensions
+	case *icmp.DstUnreach:
+		r.id, r.seq = quotedEcho(body.Data)
+		r.quoted, r.exts = body.Data, body.Extensions
+		r.unreach = &UnreachableError{Code: msg.Code}
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+func (l *icmpListener) close() {
+	l.once4.Do(func() {})
+	l.once6.Do(func() {})
+	for _, c := range []*icmp.PacketConn{l.conn4, l.conn6} {
+		if c != nil {
+			c.Close()
+			metrics.Add("open_sockets", -1)
+		}
+	}
+}
+
+// quotedTransport splits the invoking packet quoted in an ICMP error into its
+// transport protocol number and the transport header bytes that follow the
+// IP header. It returns a nil header if the quote is too short.
+func quotedTransport(b []byte) (proto byte, l4 []byte) {
+	if len(b) < 1 {
+		return 0, nil
+	}
+	var hl int
+	switch b[0] >> 4 {
+	case ipv4.Version:
+		if len(b) < ipv4.HeaderLen {
+			return 0, nil
+		}
+		hl = int(b[0]&0x0f) << 2
+		proto = b[9]
+		if hl < ipv4.HeaderLen {
+			return 0, nil
+		}
+	case ipv6.Version:
+		if len(b) < ipv6.HeaderLen {
+			return 0, nil
+		}
+		hl = ipv6.HeaderLen
+		proto = b[6]
+	default:
+		return 0, nil
+	}
+	if len(b) < hl+8 {
+		return proto, nil
+	}
+	return proto, b[hl:]
+}
@@ -0,0 +1,87 @@
+package mtr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// addICMPSeeds adds echo replies and errors with MPLS label stacks, for
+// both families, to the corpus of a fuzz target taking (v6, message).
+func addICMPSeeds(f *testing.F) {
+	stack := []icmp.Extension{&icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{
+		{Label: 16005, TTL: 1},
+		{Label: 24001, TC: 5, S: true, TTL: 1},
+	}}}
+	udp4 := quoted4(net.IPv4(192, 0, 2, 1))
+	udp4[9] = 17
+	for _, m := range []struct {
+		v6  bool
+		msg icmp.Message
+	}{
+		{false, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 2}}},
+		{false, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoted4(net.IPv4(192, 0, 2, 1)), Extensions: stack}}},
+		{false, icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: udp4}}},
+		{true, icmp.Message{Type: ipv6.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 2}}},
+		{true, icmp.Message{Type: ipv6.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoted6(net.ParseIP("2001:db8::1")), Extensions: stack}}},
+		{true, icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 4, Body: &icmp.DstUnreach{Data: quoted6(net.ParseIP("2001:db8::1"))}}},
+	} {
+		b, err := m.msg.Marshal(nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(m.v6, b)
+	}
+}
+
+func icmpProto(v6 bool) int {
+	if v6 {
+		return protocolICMPv6
+	}
+	return protocolICMP
+}
+
+// FuzzEchoReply feeds messages to the parsing of the raw ICMP probers and
+// records the labels they carry as the probers do.
+func FuzzEchoReply(f *testing.F) {
+	addICMPSeeds(f)
+	f.Fuzz(func(t *testing.T, v6 bool, b []byte) {
+		r, ok := parseEchoReply(icmpProto(v6), b)
+		if !ok {
+			return
+		}
+		var book labelBook
+		now := time.Now()
+		book.record(net.IPv4(10, 0, 0, 1), r.quoted, r.exts, now)
+		if dst := quotedDst(r.quoted); dst != nil {
+			book.take("10.0.0.1", dst.String(), now)
+		}
+	})
+}
+
+// FuzzICMPError feeds ICMP errors to the listener shared by the UDP and
+// TCP probers, and to their handlers of the quoted packet.
+func FuzzICMPError(f *testing.F) {
+	addICMPSeeds(f)
+	f.Fuzz(func(t *testing.T, v6 bool, b []byte) {
+		udp, tcp := &udpProber{}, &tcpProber{}
+		l := &icmpListener{labels: &labelBook{}, handle: func(from net.IP, quoted []byte, unreach *UnreachableError, now time.Time) {
+			udp.handleError(from, quoted, unreach, now)
+			tcp.handleError(from, quoted, unreach, now)
+		}}
+		l.receive(icmpProto(v6), net.IPv4(10, 0, 0, 1), b, time.Now())
+	})
+}
+
+func TestQuotedTransportShortHeader(t *testing.T) {
+	// an IPv4 header length below the minimum must not be trusted
+	b := quoted4(net.IPv4(192, 0, 2, 1))
+	b[0] = 0x41
+	if proto, l4 := quotedTransport(b); l4 != nil {
+		t.Errorf("quotedTransport with IHL 1 = %d, %v", proto, l4)
+	}
+}
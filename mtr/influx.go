@@ -0,0 +1,37 @@
+package mtr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LineMeasurement is the measurement of the points ToLineProtocol emits.
+const LineMeasurement = "mtr"
+
+// tagEscaper escapes tag keys and values in line protocol.
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// ToLineProtocol renders the report as InfluxDB line protocol, one point
+// per hup timestamped in nanoseconds, for piping into InfluxDB or
+// Telegraf. Points are tagged with src, dst, hop, host and, if known, asn,
+// in the sorted order InfluxDB prefers, and carry the loss, last, avg,
+// best, wrst and snt fields.
+func (r MTRReport) ToLineProtocol() string {
+	var b strings.Builder
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	ts := strconv.FormatInt(r.Time*1e9, 10)
+	for _, h := range r.Hups {
+		b.WriteString(LineMeasurement)
+		if h.ASN != 0 {
+			b.WriteString(",asn=" + strconv.Itoa(h.ASN))
+		}
+		b.WriteString(",dst=" + tagEscaper.Replace(r.Dst))
+		b.WriteString(",hop=" + strconv.Itoa(h.Count))
+		b.WriteString(",host=" + tagEscaper.Replace(h.Host))
+		b.WriteString(",src=" + tagEscaper.Replace(r.Src))
+		b.WriteString(" loss=" + f(h.Loss) + ",last=" + f(h.Last) + ",avg=" + f(h.Avg) +
+			",best=" + f(h.Best) + ",wrst=" + f(h.Wrst) + ",snt=" + strconv.Itoa(int(h.Snt)) + "i")
+		b.WriteString(" " + ts + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package mtr_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/SgtDaJim/op-mtr/mtr/mtrtest"
+)
+
+func TestWriteJSONMatchesToJSON(t *testing.T) {
+	reports := map[string]mtr.MTRReport{
+		"nil hups":   {Version: mtr.ReportVersion, ID: "a", Dst: "10.0.0.3"},
+		"empty hups": {Version: mtr.ReportVersion, ID: "a", Dst: "10.0.0.3", Hups: []mtr.MTRHup{}},
+		"key in strings": {
+			Version: mtr.ReportVersion, ID: "a", Dst: "10.0.0.3",
+			Note: `"hups":null`, Hups: []mtr.MTRHup{{Count: 1, Host: "10.0.0.3", Note: `"hups":[`}},
+			Errors:   []mtr.MTRRunError{{Hop: 1, Error: "boom"}},
+			Baseline: &mtr.BaselineResult{Snt: 2, Avg: 1.5},
+		},
+	}
+	golden, _ := filepath.Glob(filepath.Join("testdata", "*.json"))
+	for _, path := range golden {
+		r, err := mtrtest.ReadGolden(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports[path] = r
+	}
+	for name, r := range reports {
+		want, err := r.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := r.WriteJSON(&b); err != nil {
+			t.Errorf("%s: WriteJSON: %v", name, err)
+			continue
+		}
+		if b.String() != want {
+			t.Errorf("%s: WriteJSON = %s\nToJSON = %s", name, b.String(), want)
+		}
+	}
+}
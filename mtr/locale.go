@@ -0,0 +1,107 @@
+package mtr
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale formats numbers and timestamps in the human-readable renderers,
+// e.g. for reports pasted into customer-facing status pages. The zero
+// Locale formats like Go: "." decimals, no digit grouping, Time.String
+// timestamps. Separators should be ASCII so tables stay aligned.
+type Locale struct {
+	// Decimal separates the fraction, "." if empty.
+	Decimal string
+	// Group separates thousands in the integer part, none if empty.
+	Group string
+	// TimeLayout formats timestamps in the local time zone, with
+	// time.Time.String if empty.
+	TimeLayout string
+}
+
+// locales are the conventions of common languages, with 24h timestamps.
+var locales = map[string]Locale{
+	"en": {Decimal: ".", Group: ",", TimeLayout: "2006-01-02 15:04:05 MST"},
+	"de": {Decimal: ",", Group: ".", TimeLayout: "02.01.2006 15:04:05 MST"},
+	"fr": {Decimal: ",", Group: " ", TimeLayout: "02/01/2006 15:04:05 MST"},
+	"es": {Decimal: ",", Group: ".", TimeLayout: "02/01/2006 15:04:05 MST"},
+	"it": {Decimal: ",", Group: ".", TimeLayout: "02/01/2006 15:04:05 MST"},
+	"pt": {Decimal: ",", Group: ".", TimeLayout: "02/01/2006 15:04:05 MST"},
+	"nl": {Decimal: ",", Group: ".", TimeLayout: "02-01-2006 15:04:05 MST"},
+	"pl": {Decimal: ",", Group: " ", TimeLayout: "02.01.2006 15:04:05 MST"},
+	"ru": {Decimal: ",", Group: " ", TimeLayout: "02.01.2006 15:04:05 MST"},
+	"sv": {Decimal: ",", Group: " ", TimeLayout: "2006-01-02 15:04:05 MST"},
+	"ja": {Decimal: ".", Group: ",", TimeLayout: "2006/01/02 15:04:05 MST"},
+	"zh": {Decimal: ".", Group: ",", TimeLayout: "2006/01/02 15:04:05 MST"},
+}
+
+// regionLocales override locales for regions differing from their
+// language's convention.
+var regionLocales = map[string]Locale{
+	"en-us": {Decimal: ".", Group: ",", TimeLayout: "01/02/2006 15:04:05 MST"},
+	"en-gb": {Decimal: ".", Group: ",", TimeLayout: "02/01/2006 15:04:05 MST"},
+	"de-ch": {Decimal: ".", Group: "'", TimeLayout: "02.01.2006 15:04:05 MST"},
+	"fr-ch": {Decimal: ".", Group: "'", TimeLayout: "02.01.2006 15:04:05 MST"},
+	"pt-br": {Decimal: ",", Group: ".", TimeLayout: "02/01/2006 15:04:05 MST"},
+}
+
+// LookupLocale returns the Locale of a language tag such as "de",
+// "de-CH" or "fr_FR.UTF-8", and whether its language is known.
+func LookupLocale(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.Replace(tag, "_", "-", -1))
+	if i := strings.IndexAny(tag, ".@"); i >= 0 {
+		tag = tag[:i]
+	}
+	if l, ok := regionLocales[tag]; ok {
+		return l, true
+	}
+	lang := tag
+	if i := strings.Index(tag, "-"); i >= 0 {
+		lang = tag[:i]
+	}
+	l, ok := locales[lang]
+	return l, ok
+}
+
+// Float formats v with prec decimals, or as few as needed if prec is -1.
+func (l Locale) Float(v float64, prec int) string {
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	if l.Decimal == "" && l.Group == "" {
+		return s
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if l.Group != "" {
+		var b strings.Builder
+		for i, c := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(l.Group)
+			}
+			b.WriteRune(c)
+		}
+		whole = b.String()
+	}
+	if frac == "" {
+		return sign + whole
+	}
+	dec := l.Decimal
+	if dec == "" {
+		dec = "."
+	}
+	return sign + whole + dec + frac
+}
+
+// Time formats t in the local time zone.
+func (l Locale) Time(t time.Time) string {
+	if l.TimeLayout == "" {
+		return t.String()
+	}
+	return t.Local().Format(l.TimeLayout)
+}
@@ -0,0 +1,116 @@
+package mtr
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Kinds of local events.
+const (
+	LocalEventLink  = "link"
+	LocalEventRoute = "route"
+)
+
+// LocalEvent is a change on the probe host itself, such as an interface
+// going down or the default route moving, that may explain an apparent
+// path change.
+type LocalEvent struct {
+	Time   int64  `json:"ts"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+	// Prefix is the destination of a changed route, e.g. "0.0.0.0/0".
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// affects reports whether e may change the path to ip: link events and
+// routes covering ip do.
+func (e LocalEvent) affects(ip net.IP) bool {
+	if e.Kind != LocalEventRoute {
+		return true
+	}
+	_, n, err := net.ParseCIDR(e.Prefix)
+	return err != nil || n.Contains(ip)
+}
+
+// EventLog keeps recent local events so they can be attached to the reports
+// they may have affected. It is safe for concurrent use.
+type EventLog struct {
+	// Window is how long before a run an event is still attached to its
+	// report, one minute if zero.
+	Window time.Duration
+	// Max is the number of events kept, 256 if zero.
+	Max int
+
+	mu     sync.Mutex
+	events []LocalEvent
+	subs   map[chan LocalEvent]struct{}
+}
+
+// Record adds e to the log, dropping the oldest events beyond Max.
+func (l *EventLog) Record(e LocalEvent) {
+	max := l.Max
+	if max <= 0 {
+		max = 256
+	}
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	if len(l.events) > max {
+		l.events = append([]LocalEvent(nil), l.events[len(l.events)-max:]...)
+	}
+	for ch := range l.subs {
+		// Subscribers only need to know something happened; bursts coalesce.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	l.mu.Unlock()
+}
+
+// Subscribe returns a channel receiving events as they are recorded, and a
+// function that ends the subscription. Events arriving while one is still
+// pending are dropped.
+func (l *EventLog) Subscribe() (<-chan LocalEvent, func()) {
+	ch := make(chan LocalEvent, 1)
+	l.mu.Lock()
+	if l.subs == nil {
+		l.subs = map[chan LocalEvent]struct{}{}
+	}
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.subs, ch)
+		l.mu.Unlock()
+	}
+}
+
+// Since returns the events recorded at or after t, oldest first.
+func (l *EventLog) Since(t time.Time) []LocalEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []LocalEvent
+	for _, e := range l.events {
+		if e.Time >= t.Unix() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// annotate attaches to r the events affecting its destination from Window
+// before the run until now.
+func (l *EventLog) annotate(r *MTRReport) {
+	window := l.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+	dst := net.ParseIP(r.Dst)
+	r.LocalEvents = nil
+	for _, e := range l.Since(time.Unix(r.Time, 0).Add(-window)) {
+		if e.affects(dst) {
+			r.LocalEvents = append(r.LocalEvents, e)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mtr
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+// Log levels, least severe first.
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL%d", int(l))
+}
+
+// Logger receives the diagnostics of the package, such as failed pings, as
+// a message with alternating keys and values, e.g.
+//
+//	Log(LevelWarn, "ping failed", "hop", 3, "host", "192.0.2.1", "err", err)
+//
+// It must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// StdLogger returns a Logger writing entries of level min and above to l as
+// one line each, like "WARN ping failed hop=3 err=...".
+func StdLogger(l *log.Logger, min LogLevel) Logger {
+	return stdLogger{l: l, min: min}
+}
+
+type stdLogger struct {
+	l   *log.Logger
+	min LogLevel
+}
+
+func (s stdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "MISSING"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], v)
+	}
+	s.l.Println(b.String())
+}
+
+// logTo logs to l, dropping the entry if l is nil.
+func logTo(l Logger, level LogLevel, msg string, keyvals ...interface{}) {
+	if l != nil {
+		l.Log(level, msg, keyvals...)
+	}
+}
@@ -0,0 +1,55 @@
+package mtr
+
+import (
+	"expvar"
+	"runtime"
+)
+
+// metrics are the agent's self-metrics, published through expvar under
+// "opmtr" (served at /debug/vars when net/http/expvar handlers are used).
+// The gauges are:
+//   - active_runs, active_monitors: runs and monitors in progress
+//   - open_tracers: OPMTRs not yet closed
+//   - open_sockets: probing sockets held open, raw, datagram and UDP
+//   - pings_waiting: pings queued on the priority gate or rate limit
+//   - goroutines
+//
+// The others are counters, see counters.
+var metrics = expvar.NewMap("opmtr")
+
+// counters are the self-metrics that only ever grow.
+var counters = []string{"pings_sent", "ping_errors", "pings_lost", "pings_preempted", "pings_rate_limited"}
+
+func init() {
+	metrics.Set("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	for _, k := range []string{"active_runs", "active_monitors", "open_tracers", "open_sockets", "pings_waiting"} {
+		metrics.Add(k, 0)
+	}
+	for _, k := range counters {
+		metrics.Add(k, 0)
+	}
+}
+
+// Metrics returns a snapshot of the self-metrics.
+func Metrics() map[string]int64 {
+	m := map[string]int64{"goroutines": int64(runtime.NumGoroutine())}
+	metrics.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			m[kv.Key] = v.Value()
+		}
+	})
+	return m
+}
+
+// IsCounter reports whether the self-metric name only ever grows, as
+// opposed to a gauge going up and down.
+func IsCounter(name string) bool {
+	for _, c := range counters {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
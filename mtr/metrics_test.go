@@ -0,0 +1,41 @@
+package mtr_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+func TestMetricsSettle(t *testing.T) {
+	op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(4), mtr.WithMaxHops(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.Prober = &fakeNet{path: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}
+	before := mtr.Metrics()
+	if _, err := op.RunContext(context.Background(), "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	op.Close()
+	after := mtr.Metrics()
+	if n := after["pings_sent"] - before["pings_sent"]; n != 9 {
+		t.Errorf("pings_sent grew by %d, want 9", n)
+	}
+	for _, k := range []string{"active_runs", "active_monitors", "open_sockets", "pings_waiting"} {
+		if after[k] != before[k] {
+			t.Errorf("%s is %d after the run, was %d", k, after[k], before[k])
+		}
+	}
+	if after["open_tracers"] != before["open_tracers"]-1 {
+		t.Errorf("open_tracers %d after Close, was %d", after["open_tracers"], before["open_tracers"])
+	}
+	for _, k := range []string{"pings_sent", "ping_errors", "pings_lost", "pings_preempted", "pings_rate_limited"} {
+		if !mtr.IsCounter(k) {
+			t.Errorf("%s isn't a counter", k)
+		}
+	}
+	if mtr.IsCounter("open_sockets") {
+		t.Error("open_sockets is a counter")
+	}
+}
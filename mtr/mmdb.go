@@ -0,0 +1,325 @@
+package mtr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+var mmdbMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// MMDB is a MaxMind DB file such as GeoLite2-City or GeoLite2-ASN, read
+// into memory. It implements GeoSource and ASNSource and is safe for
+// concurrent use.
+type MMDB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// OpenMMDB reads the MaxMind DB at path.
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// parseMMDB checks the metadata and search tree of the MaxMind DB in buf.
+func parseMMDB(buf []byte) (*MMDB, error) {
+	i := bytes.LastIndex(buf, mmdbMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB")
+	}
+	meta, _, err := mmdbDecode(buf[i+len(mmdbMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := meta.(map[string]interface{})
+	db := &MMDB{
+		buf:        buf,
+		nodeCount:  mmdbUint(m["node_count"]),
+		recordSize: mmdbUint(m["record_size"]),
+		ipVersion:  mmdbUint(m["ip_version"]),
+	}
+	switch {
+	case db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32:
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	case db.nodeCount > uint(i)/(db.recordSize/4) || db.recordSize/4*db.nodeCount+16 > uint(i):
+		// the first check keeps the multiplication from overflowing
+		return nil, errors.New("corrupt search tree")
+	}
+	treeSize := db.recordSize / 4 * db.nodeCount
+	db.data = buf[treeSize+16 : i]
+	if db.ipVersion == 6 {
+		// IPv4 addresses live under ::/96.
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start, _ = db.node(db.ipv4Start)
+		}
+	}
+	return db, nil
+}
+
+// node returns the left and right records of node n.
+func (db *MMDB) node(n uint) (uint, uint) {
+	switch db.recordSize {
+	case 24:
+		b := db.buf[n*6:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]),
+			uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		b := db.buf[n*7:]
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]),
+			uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b := db.buf[n*8:]
+		return uint(binary.BigEndian.Uint32(b)), uint(binary.BigEndian.Uint32(b[4:]))
+	}
+}
+
+// Lookup returns the record for ip, or nil if the database has none.
+func (db *MMDB) Lookup(ip net.IP) (interface{}, error) {
+	var bits []byte
+	n := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		bits, n = ip4, db.ipv4Start
+	} else if db.ipVersion == 6 {
+		bits = ip.To16()
+	} else {
+		return nil, nil
+	}
+	for i := 0; i < len(bits)*8 && n < db.nodeCount; i++ {
+		left, right := db.node(n)
+		if bits[i/8]&(0x80>>uint(i%8)) == 0 {
+			n = left
+		} else {
+			n = right
+		}
+	}
+	if n <= db.nodeCount {
+		return nil, nil
+	}
+	v, _, err := mmdbDecode(db.data, n-db.nodeCount-16)
+	return v, err
+}
+
+// LookupGeo implements GeoSource with the city, country and location of a
+// City or Country database.
+func (db *MMDB) LookupGeo(ctx context.Context, addr string) (GeoInfo, error) {
+	r, err := db.lookupMap(addr)
+	if err != nil || r == nil {
+		return GeoInfo{}, err
+	}
+	g := GeoInfo{
+		City:    mmdbString(r, "city", "names", "en"),
+		Country: mmdbString(r, "country", "iso_code"),
+	}
+	if loc, ok := r["location"].(map[string]interface{}); ok {
+		g.Lat, _ = loc["latitude"].(float64)
+		g.Lon, _ = loc["longitude"].(float64)
+	}
+	return g, nil
+}
+
+// LookupASN implements ASNSource with an ASN database.
+func (db *MMDB) LookupASN(ctx context.Context, addr string) (ASInfo, error) {
+	r, err := db.lookupMap(addr)
+	if err != nil || r == nil {
+		return ASInfo{}, err
+	}
+	return ASInfo{
+		Number: int(mmdbUint(r["autonomous_system_number"])),
+		Name:   mmdbString(r, "autonomous_system_organization"),
+	}, nil
+}
+
+func (db *MMDB) lookupMap(addr string) (map[string]interface{}, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", addr)
+	}
+	v, err := db.Lookup(ip)
+	m, _ := v.(map[string]interface{})
+	return m, err
+}
+
+// mmdbString follows keys through nested maps to a string, "" if missing.
+func mmdbString(m map[string]interface{}, keys ...string) string {
+	var v interface{} = m
+	for _, k := range keys {
+		mm, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = mm[k]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func mmdbUint(v interface{}) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int32:
+		return uint(n)
+	}
+	return 0
+}
+
+var errMMDBData = errors.New("corrupt MaxMind DB data section")
+
+// mmdbDecode decodes the data field at off in data and returns it with the
+// offset following it. Maps decode to map[string]interface{}, arrays to
+// []interface{} and unsigned integers to uint64.
+func mmdbDecode(data []byte, off uint) (interface{}, uint, error) {
+	left := mmdbMaxValues
+	return mmdbDecodeDepth(data, off, 0, &left)
+}
+
+const (
+	// mmdbMaxDepth bounds nesting so corrupt pointers can't loop forever.
+	mmdbMaxDepth = 32
+	// mmdbMaxValues bounds the values decoded for one field, since arrays
+	// of pointers to the same array multiply at each level.
+	mmdbMaxValues = 1 << 16
+)
+
+// mmdbDecodeDepth decodes like mmdbDecode at nesting depth, counting the
+// values decoded down from *left.
+func mmdbDecodeDepth(data []byte, off uint, depth int, left *int) (interface{}, uint, error) {
+	if off >= uint(len(data)) || depth > mmdbMaxDepth || *left <= 0 {
+		return nil, 0, errMMDBData
+	}
+	*left--
+	ctrl := data[off]
+	off++
+	typ := uint(ctrl >> 5)
+	if typ == 1 {
+		// Pointer: 1 to 4 further bytes of offset into the data section.
+		ss, v := uint(ctrl>>3)&3, uint(ctrl&7)
+		if off+ss+1 > uint(len(data)) {
+			return nil, 0, errMMDBData
+		}
+		b := data[off : off+ss+1]
+		var p uint
+		switch ss {
+		case 0:
+			p = v<<8 | uint(b[0])
+		case 1:
+			p = (v<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (v<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		val, _, err := mmdbDecodeDepth(data, p, depth+1, left)
+		return val, off + ss + 1, err
+	}
+	if typ == 0 {
+		if off >= uint(len(data)) {
+			return nil, 0, errMMDBData
+		}
+		typ = 7 + uint(data[off])
+		off++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > uint(len(data)) {
+			return nil, 0, errMMDBData
+		}
+		var ext uint
+		for _, c := range data[off : off+n] {
+			ext = ext<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + ext
+		off += n
+	}
+
+	switch typ {
+	case 7: // map
+		// every key and value takes a byte at least, so a size beyond
+		// what's left is corrupt and must not size the allocation
+		if size > (uint(len(data))-off)/2 {
+			return nil, 0, errMMDBData
+		}
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := mmdbDecodeDepth(data, off, depth+1, left)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := mmdbDecodeDepth(data, next, depth+1, left)
+			if err != nil {
+				return nil, 0, err
+			}
+			ks, _ := k.(string)
+			m[ks] = v
+			off = next
+		}
+		return m, off, nil
+	case 11: // array
+		if size > uint(len(data))-off {
+			return nil, 0, errMMDBData
+		}
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := mmdbDecodeDepth(data, off, depth+1, left)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case 14: // boolean
+		return size != 0, off, nil
+	}
+	if off+size > uint(len(data)) {
+		return nil, 0, errMMDBData
+	}
+	b := data[off : off+size]
+	off += size
+	switch typ {
+	case 2: // UTF-8 string
+		return string(b), off, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errMMDBData
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errMMDBData
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 8: // int32
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int32(n), off, nil
+	}
+	// Bytes, uint128 and the rest aren't used by the fields we read.
+	return b, off, nil
+}
@@ -0,0 +1,97 @@
+package mtr
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// tinyMMDB is a MaxMind DB with one search tree node whose records both
+// point at {"country": {"iso_code": "DE"}}.
+var tinyMMDB = mmdbWithNodeCount([]byte{0xc1, 1})
+
+// mmdbWithNodeCount returns tinyMMDB with the encoded node_count.
+func mmdbWithNodeCount(nodeCount []byte) []byte {
+	b := []byte{0, 0, 17, 0, 0, 17} // node 0, both records node_count+16
+	b = append(b, make([]byte, 16)...)
+	b = append(b, 0xe1, 0x47)
+	b = append(b, "country"...)
+	b = append(b, 0xe1, 0x48)
+	b = append(b, "iso_code"...)
+	b = append(b, 0x42, 'D', 'E')
+	b = append(b, mmdbMarker...)
+	b = append(b, 0xe3, 0x4a)
+	b = append(b, "node_count"...)
+	b = append(b, nodeCount...)
+	b = append(b, 0x4b)
+	b = append(b, "record_size"...)
+	b = append(b, 0xa1, 24, 0x4a)
+	b = append(b, "ip_version"...)
+	return append(b, 0xa1, 4)
+}
+
+func TestMMDB(t *testing.T) {
+	db, err := parseMMDB(tinyMMDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := db.LookupGeo(context.Background(), "192.0.2.1")
+	if err != nil || g.Country != "DE" {
+		t.Errorf("LookupGeo = %+v, %v", g, err)
+	}
+	if v, err := db.Lookup(net.ParseIP("2001:db8::1")); v != nil || err != nil {
+		t.Errorf("IPv6 lookup in an IPv4 database = %v, %v", v, err)
+	}
+}
+
+func TestMMDBCorrupt(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		// a map of 16 million entries in four bytes
+		{"map size", []byte{0xff, 0xff, 0xff, 0xff}},
+		// an array of as many entries
+		{"array size", []byte{0x1f, 4, 0xff, 0xff, 0xff}},
+		// 10 levels of arrays of 8 pointers to the next, 8^10 values
+		{"pointer fan-out", pointerFanOut(10, 8)},
+	} {
+		if _, _, err := mmdbDecode(c.data, 0); err != errMMDBData {
+			t.Errorf("%s: err = %v, want %v", c.name, err, errMMDBData)
+		}
+	}
+	// a node count whose search tree size overflows
+	db := mmdbWithNodeCount([]byte{0x08, 2, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xab})
+	if _, err := parseMMDB(db); err == nil {
+		t.Error("parseMMDB with node_count 2^64/6+1 = nil error")
+	}
+}
+
+// pointerFanOut returns levels arrays, each of n pointers to the next,
+// and a string.
+func pointerFanOut(levels, n int) []byte {
+	var b []byte
+	for l := 1; l <= levels; l++ {
+		next := byte(l * (2 + 2*n))
+		b = append(b, byte(n), 4)
+		for i := 0; i < n; i++ {
+			b = append(b, 0x20, next)
+		}
+	}
+	return append(b, 0x41, 'x')
+}
+
+// FuzzMMDB opens arbitrary databases and looks up an address of each
+// family.
+func FuzzMMDB(f *testing.F) {
+	f.Add(tinyMMDB)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		mmdbDecode(b, 0)
+		db, err := parseMMDB(b)
+		if err != nil {
+			return
+		}
+		db.LookupGeo(context.Background(), "192.0.2.1")
+		db.LookupASN(context.Background(), "2001:db8::1")
+	})
+}
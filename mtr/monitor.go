@@ -0,0 +1,337 @@
+package mtr
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// monitorSamples caps the RTTs kept per hop for cumulative percentiles.
+const monitorSamples = 10000
+
+// Monitor runs dst every interval until ctx is done and sends a report per
+// cycle on the returned channel, which is closed when monitoring stops.
+// Each report carries statistics accumulated over all cycles so far; a hop
+// whose address changes starts over. Failed cycles are logged to op.Logger
+// and skipped; a panic stops the monitor, see Monitor.Err.
+//
+// If op.Events is set, a link change or a route change covering dst (see
+// WatchLocalEvents) triggers a cycle right away instead of at the next tick.
+//
+// Use NewMonitor to also pause, resume or reset the monitor.
+func (op *OPMTR) Monitor(ctx context.Context, dst string, interval time.Duration) (<-chan MTRReport, error) {
+	m, err := op.NewMonitor(ctx, dst, interval)
+	if err != nil {
+		return nil, err
+	}
+	return m.Reports(), nil
+}
+
+// Monitor is a running OPMTR.Monitor that can be paused, e.g. during
+// maintenance, and have its statistics reset, e.g. after a fix, without
+// being recreated. It is safe for concurrent use.
+type Monitor struct {
+	reports chan MTRReport
+	// kick starts the next cycle right away
+	kick chan struct{}
+
+	mu     sync.Mutex
+	paused bool
+	// reset counts ResetStats calls, cycles started before the last one
+	// are dropped
+	reset int
+	// cancelCycle cancels the cycle in flight, nil between cycles
+	cancelCycle context.CancelFunc
+	// err is the panic that stopped the monitor
+	err error
+}
+
+// NewMonitor starts monitoring dst as OPMTR.Monitor does and returns the
+// Monitor, whose reports arrive on Reports.
+func (op *OPMTR) NewMonitor(ctx context.Context, dst string, interval time.Duration) (*Monitor, error) {
+	dstIP, _, err := op.resolve(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	var events <-chan LocalEvent
+	unsubscribe := func() {}
+	if op.Events != nil {
+		events, unsubscribe = op.Events.Subscribe()
+	}
+	m := &Monitor{reports: make(chan MTRReport), kick: make(chan struct{}, 1)}
+	SafeGo("monitor", 0, func() {
+		metrics.Add("active_monitors", 1)
+		defer metrics.Add("active_monitors", -1)
+		defer unsubscribe()
+		op.monitor(ctx, m, dst, dstIP, interval, events)
+		close(m.reports)
+	}, func(e MTRRunError) {
+		logTo(op.Logger, LevelError, "monitor stopped", "dst", dst, "err", e.Error)
+		m.mu.Lock()
+		m.err = e.err()
+		m.mu.Unlock()
+		close(m.reports)
+	})
+	return m, nil
+}
+
+// monitor runs the cycles of m until ctx is done.
+func (op *OPMTR) monitor(ctx context.Context, m *Monitor, dst string, dstIP net.IP, interval time.Duration, events <-chan LocalEvent) {
+	var acc []MTRHup
+	var base *BaselineResult
+	cycles, reset := 0, 0
+	clk := op.clock()
+	for {
+		cctx, gen, ok := m.startCycle(ctx)
+		if !ok {
+			// paused
+			select {
+			case <-m.kick:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		start := clk.Now()
+		r, err := op.RunContext(cctx, dst)
+		stale := m.endCycle(cctx, gen)
+		if ctx.Err() != nil {
+			return
+		}
+		if gen != reset {
+			acc, base, cycles, reset = nil, nil, 0, gen
+		}
+		if stale {
+			// paused or reset midway
+			continue
+		}
+		if err != nil {
+			logTo(op.Logger, LevelError, "monitor cycle failed", "dst", dst, "err", err)
+		} else {
+			cycles++
+			acc = accumulateHups(acc, r.Hups, op.KeepSamples)
+			if r.Baseline != nil {
+				if base == nil {
+					base = &BaselineResult{}
+				}
+				base.merge(r.Baseline)
+				b := *base
+				r.Baseline = &b
+			}
+			r.Count = cycles * op.PingCount
+			r.Hups = append([]MTRHup(nil), acc...)
+			estimateSegments(r.Hups)
+			select {
+			case m.reports <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+		// The next cycle starts interval after this one started.
+		t := clk.NewTimer(interval - clk.Now().Sub(start))
+	wait:
+		for {
+			select {
+			case <-t.C():
+				break wait
+			case e := <-events:
+				if e.affects(dstIP) {
+					break wait
+				}
+			case <-m.kick:
+				break wait
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		t.Stop()
+	}
+}
+
+// Reports returns the channel the cumulative report of every cycle is sent
+// on. It is closed when monitoring stops.
+func (m *Monitor) Reports() <-chan MTRReport {
+	return m.reports
+}
+
+// Err returns the error that stopped the monitor once Reports is closed: a
+// recovered panic, matching ErrPanic, or nil if ctx was done.
+func (m *Monitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// startCycle returns the context of a new cycle under ctx and the reset
+// count it starts with, or false if the monitor is paused.
+func (m *Monitor) startCycle(ctx context.Context) (context.Context, int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.paused {
+		return nil, 0, false
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	m.cancelCycle = cancel
+	return cctx, m.reset, true
+}
+
+// endCycle ends the cycle with cctx, started with reset count gen, and
+// reports whether its results are stale: cut short by Pause, or started
+// before ResetStats.
+func (m *Monitor) endCycle(cctx context.Context, gen int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancelCycle()
+	m.cancelCycle = nil
+	return m.paused || gen != m.reset
+}
+
+// Pause stops probing: the cycle in flight is cancelled and dropped, and no
+// cycle starts until Resume. Statistics are kept.
+func (m *Monitor) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused = true
+	if m.cancelCycle != nil {
+		m.cancelCycle()
+	}
+}
+
+// Resume restarts a paused monitor with a cycle right away.
+func (m *Monitor) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.paused {
+		return
+	}
+	m.paused = false
+	m.wake()
+}
+
+// Paused reports whether the monitor is paused.
+func (m *Monitor) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// ResetStats discards the statistics accumulated so far, so the next report
+// covers only cycles started afterwards. Unless the monitor is paused, a
+// cycle in flight is cancelled and a new one starts right away.
+func (m *Monitor) ResetStats() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset++
+	if m.cancelCycle != nil {
+		m.cancelCycle()
+	} else if !m.paused {
+		m.wake()
+	}
+}
+
+// wake starts the next cycle right away. m.mu must be held.
+func (m *Monitor) wake() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+// accumulateHups merges the hups of one cycle into acc by TTL, keeping up
+// to keep raw samples per hop. The path may have grown or shrunk; hops past
+// the new end are dropped.
+func accumulateHups(acc, hups []MTRHup, keep int) []MTRHup {
+	out := make([]MTRHup, len(hups))
+	for i, h := range hups {
+		if i < len(acc) && acc[i].Host == h.Host {
+			out[i] = acc[i]
+			out[i].merge(h, keep)
+		} else {
+			out[i] = h
+		}
+	}
+	return out
+}
+
+// mergeHost adds the statistics of hh to the matching entry of Hosts.
+func (a *MTRHup) mergeHost(hh HopHost) {
+	for i := range a.Hosts {
+		if ah := &a.Hosts[i]; ah.Host == hh.Host {
+			n := ah.Rcv + hh.Rcv
+			ah.Avg = (ah.Avg*float64(ah.Rcv) + hh.Avg*float64(hh.Rcv)) / float64(n)
+			ah.Rcv = n
+			ah.Last = hh.Last
+			ah.Best = math.Min(ah.Best, hh.Best)
+			ah.Wrst = math.Max(ah.Wrst, hh.Wrst)
+			return
+		}
+	}
+	a.Hosts = append(a.Hosts, hh)
+}
+
+// merge adds the statistics of h, a later measurement of the same hop,
+// keeping the last keep raw samples.
+func (a *MTRHup) merge(h MTRHup, keep int) {
+	a.Snt += h.Snt
+	a.LossPoint += h.LossPoint
+	if a.Snt > 0 {
+		a.Loss = float64(a.LossPoint) / a.Snt
+	}
+	if h.Hostname != "" {
+		a.Hostname = h.Hostname
+	}
+	if h.Display != "" {
+		a.Display = h.Display
+	}
+	if h.ASN != 0 {
+		a.ASN, a.ASName = h.ASN, h.ASName
+	}
+	if h.Geo != nil {
+		a.Geo = h.Geo
+	}
+	a.Note = h.Note
+	if len(h.Samples) > 0 {
+		a.Samples = lastSamples(append(append([]float64(nil), a.Samples...), h.Samples...), keep)
+	}
+	if h.samples == 0 {
+		return
+	}
+	if a.samples == 0 {
+		a.Best, a.Wrst = h.Best, h.Wrst
+	}
+	na, nb := float64(a.samples), float64(h.samples)
+	n := na + nb
+	a.Last = h.Last
+	a.Avg = (a.Avg*na + h.Avg*nb) / n
+	if h.Best < a.Best {
+		a.Best = h.Best
+	}
+	if h.Wrst > a.Wrst {
+		a.Wrst = h.Wrst
+	}
+	if na == 0 {
+		a.Jitter = h.Jitter
+	} else if diffs := na + nb - 2; diffs > 0 {
+		a.Jitter = (a.Jitter*(na-1) + h.Jitter*(nb-1)) / diffs
+	}
+	d := h.mean - a.mean
+	a.mean += d * nb / n
+	a.m2 += h.m2 + d*d*na*nb/n
+	a.samples += h.samples
+	if a.samples > 1 {
+		a.StDev = math.Sqrt(a.m2 / float64(a.samples-1))
+	}
+	// earlier reports share Hosts with a
+	a.Hosts = append([]HopHost(nil), a.Hosts...)
+	for _, hh := range h.Hosts {
+		a.mergeHost(hh)
+	}
+	a.rtts = append(a.rtts, h.rtts...)
+	if len(a.rtts) > monitorSamples {
+		a.rtts = append([]float64(nil), a.rtts[len(a.rtts)-monitorSamples:]...)
+	}
+	a.percentiles()
+}
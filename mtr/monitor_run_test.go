@@ -0,0 +1,96 @@
+package mtr_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/SgtDaJim/op-mtr/mtr/mtrtest"
+)
+
+func TestMonitorCycles(t *testing.T) {
+	clk := mtrtest.NewClock(time.Unix(1000, 0))
+	fake := &fakeNet{path: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, drop: map[int]int{3: 4}}
+	op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(4), mtr.WithMaxHops(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer op.Close()
+	op.Prober, op.Clock = fake, clk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := op.NewMonitor(ctx, "10.0.0.3", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() mtr.MTRReport {
+		t.Helper()
+		select {
+		case r, ok := <-m.Reports():
+			if !ok {
+				t.Fatalf("monitor stopped: %v", m.Err())
+			}
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("no report")
+		}
+		return mtr.MTRReport{}
+	}
+	tick := func() {
+		for clk.Timers() == 0 {
+			time.Sleep(50 * time.Microsecond)
+		}
+		clk.Advance(time.Minute)
+	}
+
+	next()
+	tick()
+	next()
+	tick()
+	r := next()
+	if r.Count != 12 {
+		t.Errorf("Count %d after 3 cycles, want 12", r.Count)
+	}
+	// Hop 1 answered the trace after 1, 1.1 and 1.2 ms and its pings
+	// after 1 to 1.8 ms.
+	h := r.Hups[0]
+	if h.Snt != 12 || h.Loss != 0 || math.Abs(h.Avg-1.325) > 1e-9 || h.Best != 1 || math.Abs(h.Wrst-1.8) > 1e-9 {
+		t.Errorf("hop 1: Snt %v, Loss %v, Avg %v, Best %v, Wrst %v", h.Snt, h.Loss, h.Avg, h.Best, h.Wrst)
+	}
+	// The destination dropped its 4th and 8th ping.
+	if h := r.Hups[2]; h.Snt != 12 || math.Abs(h.Loss-2.0/12) > 1e-9 {
+		t.Errorf("hop 3: Snt %v, Loss %v", h.Snt, h.Loss)
+	}
+
+	// a hop changing address starts over, the others go on
+	fake.path[1] = "10.0.0.20"
+	tick()
+	r = next()
+	if h := r.Hups[1]; h.Host != "10.0.0.20" || h.Snt != 4 {
+		t.Errorf("changed hop 2 is %s with Snt %v, want 10.0.0.20 with 4", h.Host, h.Snt)
+	}
+	if h := r.Hups[0]; h.Snt != 16 {
+		t.Errorf("hop 1 Snt %v, want 16", h.Snt)
+	}
+
+	// ResetStats starts over right away
+	m.ResetStats()
+	if r = next(); r.Count != 4 || r.Hups[0].Snt != 4 {
+		t.Errorf("after ResetStats: Count %d, hop 1 Snt %v, want 4", r.Count, r.Hups[0].Snt)
+	}
+
+	// no cycles while paused, one right away on Resume
+	m.Pause()
+	tick()
+	select {
+	case <-m.Reports():
+		t.Fatal("report while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.Resume()
+	if r = next(); r.Count != 8 {
+		t.Errorf("after Resume: Count %d, want 8", r.Count)
+	}
+}
@@ -0,0 +1,72 @@
+package mtr
+
+import (
+	"math"
+	"testing"
+)
+
+// hupOf returns a hup of host that recorded rtts, 0 for a lost probe.
+func hupOf(host string, rtts ...float64) MTRHup {
+	s := NewHopStats(MTRHup{Count: 1, Host: host})
+	for _, rtt := range rtts {
+		if rtt == 0 {
+			s.Add("", 0)
+		} else {
+			s.Add(host, rtt)
+		}
+	}
+	h := s.Snapshot()
+	h.percentiles()
+	return h
+}
+
+func TestHupMergeEqualsOneSeries(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycles [][]float64
+	}{
+		{"clean", [][]float64{{1, 2, 3, 4}, {3, 9, 5, 8}, {5, 6, 7, 2}}},
+		{"lossy", [][]float64{{1, 2, 0, 4}, {3, 0, 0, 8}, {5, 6, 7, 2}}},
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	for _, tt := range tests {
+		var all []float64
+		var acc []MTRHup
+		for _, c := range tt.cycles {
+			all = append(all, c...)
+			acc = accumulateHups(acc, []MTRHup{hupOf("10.0.0.1", c...)}, 0)
+		}
+		got, want := acc[0], hupOf("10.0.0.1", all...)
+		if got.Snt != want.Snt || got.LossPoint != want.LossPoint || !near(got.Loss, want.Loss) {
+			t.Errorf("%s: Snt %v, LossPoint %d, Loss %v, want %v, %d, %v", tt.name, got.Snt, got.LossPoint, got.Loss, want.Snt, want.LossPoint, want.Loss)
+		}
+		if !near(got.Avg, want.Avg) || got.Best != want.Best || got.Wrst != want.Wrst || got.Last != want.Last {
+			t.Errorf("%s: Avg %v, Best %v, Wrst %v, Last %v, want %v, %v, %v, %v",
+				tt.name, got.Avg, got.Best, got.Wrst, got.Last, want.Avg, want.Best, want.Wrst, want.Last)
+		}
+		if !near(got.StDev, want.StDev) {
+			t.Errorf("%s: StDev %v, want %v", tt.name, got.StDev, want.StDev)
+		}
+		if got.P50 != want.P50 || got.P90 != want.P90 {
+			t.Errorf("%s: P50 %v, P90 %v, want %v, %v", tt.name, got.P50, got.P90, want.P50, want.P90)
+		}
+		if len(got.Hosts) != 1 || got.Hosts[0].Rcv != want.Hosts[0].Rcv || !near(got.Hosts[0].Avg, want.Hosts[0].Avg) {
+			t.Errorf("%s: Hosts %+v, want %+v", tt.name, got.Hosts, want.Hosts)
+		}
+	}
+}
+
+func TestAccumulateHupsChangedPath(t *testing.T) {
+	acc := accumulateHups(nil, []MTRHup{hupOf("10.0.0.1", 1, 2), hupOf("10.0.0.2", 3, 4), hupOf("10.0.0.3", 5, 6)}, 0)
+	// hop 2 changed address and the path got shorter
+	acc = accumulateHups(acc, []MTRHup{hupOf("10.0.0.1", 1, 2), hupOf("10.0.0.20", 3, 4)}, 0)
+	if len(acc) != 2 {
+		t.Fatalf("%d hups, want 2", len(acc))
+	}
+	if acc[0].Snt != 4 {
+		t.Errorf("unchanged hop has Snt %v, want 4", acc[0].Snt)
+	}
+	if acc[1].Host != "10.0.0.20" || acc[1].Snt != 2 {
+		t.Errorf("changed hop is %s with Snt %v, want 10.0.0.20 starting over", acc[1].Host, acc[1].Snt)
+	}
+}
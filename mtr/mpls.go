@@ -0,0 +1,113 @@
+package mtr
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// MPLSLabel is one entry of the MPLS label stack a router inside a tunnel
+// quotes in the ICMP extensions (RFC 4950) of its Time Exceeded or
+// Destination Unreachable messages, top of the stack first.
+type MPLSLabel struct {
+	Label int `json:"label"`
+	// Exp is the traffic class, formerly experimental, bits.
+	Exp int `json:"exp"`
+	// S is set on the bottom of the stack.
+	S   bool `json:"s"`
+	TTL int  `json:"ttl"`
+}
+
+// labelTTL is how long label stacks are kept for traces to take.
+const labelTTL = time.Minute
+
+// labelBook keeps the label stacks routers reported for probes to each
+// destination until traceHups takes them. The backends record them since
+// replies carry none.
+type labelBook struct {
+	mu    sync.Mutex
+	m     map[labelKey]labelEntry
+	swept time.Time
+}
+
+type labelKey struct{ router, dst string }
+
+type labelEntry struct {
+	labels []MPLSLabel
+	at     time.Time
+}
+
+// record keeps the labels in exts reported by router in an ICMP error
+// quoting the packet quoted.
+func (b *labelBook) record(router net.IP, quoted []byte, exts []icmp.Extension, now time.Time) {
+	if b == nil {
+		return
+	}
+	labels := mplsLabels(exts)
+	dst := quotedDst(quoted)
+	if labels == nil || dst == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.m == nil {
+		b.m = map[labelKey]labelEntry{}
+	}
+	if now.Sub(b.swept) > labelTTL {
+		for k, e := range b.m {
+			if now.Sub(e.at) > labelTTL {
+				delete(b.m, k)
+			}
+		}
+		b.swept = now
+	}
+	b.m[labelKey{router.String(), dst.String()}] = labelEntry{labels, now}
+}
+
+// take removes and returns the last label stack router reported for probes
+// to dst, or nil if there is none or it is older than labelTTL at now.
+func (b *labelBook) take(router, dst string, now time.Time) []MPLSLabel {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k := labelKey{router, dst}
+	e, ok := b.m[k]
+	if !ok || now.Sub(e.at) > labelTTL {
+		return nil
+	}
+	delete(b.m, k)
+	return e.labels
+}
+
+// mplsLabels returns the labels of the MPLS label stack objects in exts.
+func mplsLabels(exts []icmp.Extension) []MPLSLabel {
+	var labels []MPLSLabel
+	for _, e := range exts {
+		ls, ok := e.(*icmp.MPLSLabelStack)
+		if !ok {
+			continue
+		}
+		for _, l := range ls.Labels {
+			labels = append(labels, MPLSLabel{Label: l.Label, Exp: l.TC, S: l.S, TTL: l.TTL})
+		}
+	}
+	return labels
+}
+
+// quotedDst returns the destination of the invoking packet quoted in an
+// ICMP error, or nil.
+func quotedDst(b []byte) net.IP {
+	switch {
+	case len(b) >= ipv4.HeaderLen && b[0]>>4 == ipv4.Version:
+		return net.IP(b[16:20])
+	case len(b) >= ipv6.HeaderLen && b[0]>>4 == ipv6.Version:
+		return net.IP(b[24:40])
+	}
+	return nil
+}
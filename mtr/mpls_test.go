@@ -0,0 +1,142 @@
+package mtr
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// quoted4 returns an IPv4 echo request to dst as quoted in ICMP errors.
+func quoted4(dst net.IP) []byte {
+	b := make([]byte, 28)
+	b[0], b[9] = 0x45, protocolICMP
+	copy(b[16:20], dst.To4())
+	b[20] = byte(ipv4.ICMPTypeEcho)
+	return b
+}
+
+// quoted6 returns an IPv6 echo request to dst as quoted in ICMP errors.
+func quoted6(dst net.IP) []byte {
+	b := make([]byte, 48)
+	b[0], b[6] = 0x60, protocolICMPv6
+	copy(b[24:40], dst.To16())
+	b[40] = byte(ipv6.ICMPTypeEchoRequest)
+	return b
+}
+
+func TestMPLSFromTimeExceeded(t *testing.T) {
+	// A Time Exceeded with an RFC 4884 length and an RFC 4950 label stack
+	// of two entries, as sent by a router inside a tunnel.
+	msg := []byte{11, 0, 0, 0, 0, 32, 0, 0}
+	original := make([]byte, 128)
+	copy(original, quoted4(net.IPv4(10, 0, 0, 9)))
+	msg = append(msg, original...)
+	msg = append(msg,
+		0x20, 0, 0, 0, // extension header, version 2
+		0, 12, 1, 1, // MPLS label stack object, 2 entries
+		0x03, 0xe8, 0x50, 0x01, // label 16005, exp 0, ttl 1
+		0x05, 0xdc, 0x1b, 0x01, // label 24001, exp 5, bottom of stack, ttl 1
+	)
+	m, err := icmp.ParseMessage(protocolICMP, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, ok := m.Body.(*icmp.TimeExceeded)
+	if !ok {
+		t.Fatalf("parsed %T", m.Body)
+	}
+	want := []MPLSLabel{{Label: 16005, TTL: 1}, {Label: 24001, Exp: 5, S: true, TTL: 1}}
+	if got := mplsLabels(body.Extensions); !reflect.DeepEqual(got, want) {
+		t.Errorf("labels %+v, want %+v", got, want)
+	}
+	if dst := quotedDst(body.Data); !dst.Equal(net.IPv4(10, 0, 0, 9)) {
+		t.Errorf("quoted destination %v", dst)
+	}
+}
+
+func TestMPLSRoundTrip(t *testing.T) {
+	stack := &icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{
+		{Label: 1048575, TC: 7, TTL: 255},
+		{Label: 3, S: true, TTL: 64},
+	}}
+	want := []MPLSLabel{{Label: 1048575, Exp: 7, TTL: 255}, {Label: 3, S: true, TTL: 64}}
+	for _, tt := range []struct {
+		name   string
+		proto  int
+		typ    icmp.Type
+		quoted []byte
+		dst    net.IP
+	}{
+		{"ipv4 time exceeded", protocolICMP, ipv4.ICMPTypeTimeExceeded, quoted4(net.IPv4(192, 0, 2, 1)), net.IPv4(192, 0, 2, 1)},
+		{"ipv4 unreachable", protocolICMP, ipv4.ICMPTypeDestinationUnreachable, quoted4(net.IPv4(192, 0, 2, 1)), net.IPv4(192, 0, 2, 1)},
+		{"ipv6 time exceeded", protocolICMPv6, ipv6.ICMPTypeTimeExceeded, quoted6(net.ParseIP("2001:db8::1")), net.ParseIP("2001:db8::1")},
+	} {
+		var body icmp.MessageBody = &icmp.TimeExceeded{Data: tt.quoted, Extensions: []icmp.Extension{stack}}
+		if tt.typ == ipv4.ICMPTypeDestinationUnreachable {
+			body = &icmp.DstUnreach{Data: tt.quoted, Extensions: []icmp.Extension{stack}}
+		}
+		b, err := (&icmp.Message{Type: tt.typ, Body: body}).Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := icmp.ParseMessage(tt.proto, b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var exts []icmp.Extension
+		var quoted []byte
+		switch body := m.Body.(type) {
+		case *icmp.TimeExceeded:
+			quoted, exts = body.Data, body.Extensions
+		case *icmp.DstUnreach:
+			quoted, exts = body.Data, body.Extensions
+		}
+		if got := mplsLabels(exts); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: labels %+v, want %+v", tt.name, got, want)
+		}
+		if dst := quotedDst(quoted); !dst.Equal(tt.dst) {
+			t.Errorf("%s: quoted destination %v, want %v", tt.name, dst, tt.dst)
+		}
+	}
+}
+
+func TestMPLSWithoutLabels(t *testing.T) {
+	if got := mplsLabels([]icmp.Extension{&icmp.InterfaceIdent{Class: 3, Type: 1, Name: "eth0"}}); got != nil {
+		t.Errorf("labels from an interface object: %+v", got)
+	}
+	if dst := quotedDst([]byte{0x45, 0}); dst != nil {
+		t.Errorf("destination of a truncated quote: %v", dst)
+	}
+}
+
+func TestLabelBook(t *testing.T) {
+	var b labelBook
+	router, dst := net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 9)
+	exts := []icmp.Extension{&icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{{Label: 100, S: true, TTL: 1}}}}
+	// a fake time far from the wall clock, as a run with OPMTR.Clock sees
+	now := time.Unix(1000, 0)
+	b.record(router, quoted4(dst), exts, now)
+	if got := b.take("10.0.0.2", "10.0.0.8", now); got != nil {
+		t.Errorf("labels for another destination: %+v", got)
+	}
+	if got := b.take("10.0.0.2", "10.0.0.9", now.Add(labelTTL)); len(got) != 1 || got[0].Label != 100 {
+		t.Errorf("take = %+v", got)
+	}
+	if got := b.take("10.0.0.2", "10.0.0.9", now); got != nil {
+		t.Errorf("labels taken twice: %+v", got)
+	}
+	b.record(router, quoted4(dst), exts, now)
+	if got := b.take("10.0.0.2", "10.0.0.9", now.Add(labelTTL+time.Second)); got != nil {
+		t.Errorf("expired labels taken: %+v", got)
+	}
+	var none *labelBook
+	none.record(router, quoted4(dst), exts, now)
+	if got := none.take("10.0.0.2", "10.0.0.9", now); got != nil {
+		t.Errorf("nil book took %+v", got)
+	}
+}
@@ -1,207 +1,606 @@
 package mtr
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pixelbender/go-traceroute/traceroute"
 )
 
+// MTRReport is the result of one MTR run. JSON fields are emitted in
+// declaration order and Hups are always sorted by TTL, so machine consumers
+// can rely on the layout.
+//
+// ID is assigned once per run and never changes afterwards, so consumers can
+// use it as an idempotency key when a report is delivered more than once.
 type MTRReport struct {
-	Time  int64    `json:"ts"`
-	Src   string   `json:"src"`
-	Dst   string   `json:"dst"`
-	Count int      `json:"count"`
-	Hups  []MTRHup `json:"hups"`
+	Version int           `json:"version"`
+	ID      string        `json:"id"`
+	Time    int64         `json:"ts"`
+	Src     string        `json:"src"`
+	Dst     string        `json:"dst"`
+	DstName string        `json:"dst_name,omitempty"`
+	Group   string        `json:"group,omitempty"`
+	Note    string        `json:"note,omitempty"`
+	Count   int           `json:"count"`
+	Hups    []MTRHup      `json:"hups"`
+	Errors  []MTRRunError `json:"errors,omitempty"`
+	// PacketSize is the size of the probes' IP packets, headers included,
+	// if OPMTR.PayloadSize set it.
+	PacketSize int `json:"packet_size,omitempty"`
+	// Seed is the seed of the run's randomized probe schedule, if
+	// OPMTR.ProbeJitter or Shuffle randomized it. Setting it as OPMTR.Seed
+	// reproduces the schedule.
+	Seed int64 `json:"seed,omitempty"`
+	// LocalEvents are changes on the probe host around the run, e.g. an
+	// uplink failover, that may explain a path change.
+	LocalEvents []LocalEvent `json:"local_events,omitempty"`
+	// DNS is the result of querying Dst as a resolver, see OPMTR.DNS.
+	DNS *DNSResult `json:"dns,omitempty"`
+	// NTP is the result of querying Dst as a time server, see OPMTR.NTP.
+	NTP *NTPResult `json:"ntp,omitempty"`
+	// Baseline is the end-to-end ping series to Dst, see OPMTR.Baseline.
+	Baseline *BaselineResult `json:"baseline,omitempty"`
+}
+
+// MTRRunError is an error raised while probing a hup, e.g. a recovered panic
+// in a ping worker. The hup keeps the statistics collected before the failure.
+// Hop is 0 for failures not tied to a hop, e.g. of the baseline.
+type MTRRunError struct {
+	Hop   int    `json:"hop"`
+	Error string `json:"error"`
+}
+
+// HopHost is one address answering at a hop. Lost probes can't be
+// attributed to an address, so there is no loss here.
+type HopHost struct {
+	Host string  `json:"host"`
+	Rcv  int     `json:"rcv"`
+	Last float64 `json:"last"`
+	Avg  float64 `json:"avg"`
+	Best float64 `json:"best"`
+	Wrst float64 `json:"wrst"`
 }
 
 type MTRHup struct {
-	Count     int     `json:"count"`
-	Host      string  `json:"host"`
-	Loss      float64 `json:"Loss"`
-	LossPoint int     `json:"-"`
-	Snt       float64 `json:"Snt"`
-	Last      float64 `json:"Last"`
-	Avg       float64 `json:"Avg"`
-	Best      float64 `json:"Best"`
-	Wrst      float64 `json:"Wrst"`
+	Count     int      `json:"count"`
+	Host      string   `json:"host"`
+	Hostname  string   `json:"hostname,omitempty"`
+	Display   string   `json:"display,omitempty"`
+	Iface     string   `json:"iface,omitempty"`
+	Location  string   `json:"location,omitempty"`
+	ASN       int      `json:"asn,omitempty"`
+	ASName    string   `json:"as_name,omitempty"`
+	Geo       *GeoInfo `json:"geo,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	Loss      float64  `json:"Loss"`
+	LossPoint int      `json:"-"`
+	Snt       float64  `json:"Snt"`
+	Last      float64  `json:"Last"`
+	Avg       float64  `json:"Avg"`
+	Best      float64  `json:"Best"`
+	Wrst      float64  `json:"Wrst"`
+	StDev     float64  `json:"StDev"`
+	Jitter    float64  `json:"Jitter"`
+	P50       float64  `json:"P50"`
+	P90       float64  `json:"P90"`
+	P99       float64  `json:"P99"`
+	// SegRaw is the delay the segment ending at this hop adds by minimum
+	// RTT. SegEst is the same after fitting a non-decreasing curve to the
+	// minimum RTTs along the path, so it is never negative.
+	SegRaw float64 `json:"SegRaw"`
+	SegEst float64 `json:"SegEst"`
+	// ReplyType is ReplyUnreachable if the hup answered with Destination
+	// Unreachable, of code ICMPCode (omitted if 0), and empty otherwise.
+	ReplyType string `json:"reply_type,omitempty"`
+	ICMPCode  int    `json:"icmp_code,omitempty"`
+	// MPLS is the label stack the hup reported if OPMTR.MPLS is set and
+	// it is inside an MPLS tunnel.
+	MPLS []MPLSLabel `json:"mpls,omitempty"`
+	// Hosts are the distinct addresses that answered at this TTL, e.g.
+	// routers behind ECMP, with their own statistics.
+	Hosts []HopHost `json:"hosts,omitempty"`
+	// Samples are the RTTs of the last replies, oldest first, if
+	// OPMTR.KeepSamples is set. Lost probes have no sample.
+	Samples []float64 `json:"Samples,omitempty"`
+
+	// running state for StDev and Jitter, and the RTTs for percentiles
+	samples int
+	mean    float64
+	m2      float64
+	rtts    []float64
+}
+
+// lastSamples returns a copy of the last keep RTTs, nil if keep is zero.
+func lastSamples(rtts []float64, keep int) []float64 {
+	if keep <= 0 || len(rtts) == 0 {
+		return nil
+	}
+	if len(rtts) > keep {
+		rtts = rtts[len(rtts)-keep:]
+	}
+	return append([]float64(nil), rtts...)
+}
+
+// percentiles sets P50, P90 and P99 from the received RTTs using the
+// nearest-rank method.
+func (h *MTRHup) percentiles() {
+	if len(h.rtts) == 0 {
+		return
+	}
+	s := append([]float64(nil), h.rtts...)
+	sort.Float64s(s)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(s)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return s[i]
+	}
+	h.P50, h.P90, h.P99 = rank(0.5), rank(0.9), rank(0.99)
+}
+
+// record adds an RTT in milliseconds received from ip to the hup, whose Snt
+// must already count it. Avg is the mean of the received RTTs; lost pings
+// don't pull it down.
+func (h *MTRHup) record(ip string, rtt float64) {
+	h.recordHost(ip, rtt)
+	h.addSample(rtt)
+	h.Last = rtt
+	h.Avg = h.mean
+	if h.Best > rtt || h.samples == 1 {
+		h.Best = rtt
+	}
+	if h.Wrst < rtt {
+		h.Wrst = rtt
+	}
+}
+
+// recordHost adds an RTT to the statistics of ip in Hosts.
+func (h *MTRHup) recordHost(ip string, rtt float64) {
+	for i := range h.Hosts {
+		if hh := &h.Hosts[i]; hh.Host == ip {
+			hh.Rcv++
+			hh.Last = rtt
+			hh.Avg += (rtt - hh.Avg) / float64(hh.Rcv)
+			if rtt < hh.Best {
+				hh.Best = rtt
+			}
+			if rtt > hh.Wrst {
+				hh.Wrst = rtt
+			}
+			return
+		}
+	}
+	h.Hosts = append(h.Hosts, HopHost{Host: ip, Rcv: 1, Last: rtt, Avg: rtt, Best: rtt, Wrst: rtt})
+}
+
+// addSample updates StDev and Jitter online with rtt. It must run before
+// Last is set to rtt.
+func (h *MTRHup) addSample(rtt float64) {
+	if h.samples > 0 {
+		h.Jitter += (math.Abs(rtt-h.Last) - h.Jitter) / float64(h.samples)
+	}
+	h.samples++
+	h.rtts = append(h.rtts, rtt)
+	d := rtt - h.mean
+	h.mean += d / float64(h.samples)
+	h.m2 += d * (rtt - h.mean)
+	if h.samples > 1 {
+		h.StDev = math.Sqrt(h.m2 / float64(h.samples-1))
+	}
 }
 
 type OPMTR struct {
 	Tracer      *traceroute.Tracer
 	MaxUnknowns int
 	PingCount   int
+	// Faults, if set, injects failures into pings for resilience testing.
+	Faults *FaultConfig
+	// PathDB, if set, is updated with the path of every run.
+	PathDB *PathDB
+	// Probe, if set, replaces the built-in ICMP probe for traces and pings.
+	Probe ProbeFunc
+	// Prober, if set, replaces the built-in backends and Probe for traces
+	// and pings.
+	Prober Prober
+	// ProbeMode selects ICMP echo (ProbeICMP, the default), UDP (ProbeUDP)
+	// or TCP SYN (ProbeTCP) probes for traces and pings.
+	ProbeMode string
+	// Flow controls whether probes keep a constant flow (FlowParis), vary
+	// it on purpose to enumerate ECMP paths (FlowEnumerate), or neither.
+	Flow string
+	// TCPPort is the destination port of TCP probes, DefaultTCPPort if zero.
+	TCPPort int
+	// Backend selects the sockets of ICMP probes: BackendRaw, or
+	// BackendDatagram for unprivileged ICMP datagram sockets. If empty,
+	// DetectBackend picks the first one permitted.
+	Backend string
+	// Resolver looks up hostname destinations, net.DefaultResolver if nil.
+	Resolver Resolver
+	// Prefer picks the address family (PreferIPv4, the default, or
+	// PreferIPv6) when a hostname resolves to both.
+	Prefer string
+	// PTR, if set, looks up the hostname of every responding hup.
+	PTR *PTRResolver
+	// Notes, if set, annotates reports with operator notes.
+	Notes *NoteBook
+	// ASN, if set, looks up the autonomous system of every responding hup.
+	ASN ASNSource
+	// Geo, if set, locates every responding hup.
+	Geo GeoSource
+	// DNS, if set, also queries the destination as a DNS resolver on every
+	// run.
+	DNS *DNSProbe
+	// NTP, if set, also queries the destination as a time server on every
+	// run.
+	NTP *NTPProbe
+	// Baseline, if set, also pings the destination PingCount times while
+	// the hups are pinged, and reports the series in MTRReport.Baseline to
+	// compare the hups against.
+	Baseline bool
+	// BGP, if set, is searched for the announcement behind each path change
+	// recorded in PathDB.
+	BGP BGPFeed
+	// Hooks, if set, receive progress events while a run is in flight.
+	Hooks *Hooks
+	// Events, if set, attaches recent local events (see WatchLocalEvents)
+	// to reports.
+	Events *EventLog
+	// Workers is the number of concurrent runs of RunMulti, DefaultWorkers
+	// if zero.
+	Workers int
+	// KeepSamples is the number of raw RTT samples kept per hop in
+	// MTRHup.Samples. Zero keeps none.
+	KeepSamples int
+	// Clock is the time source, SystemClock if nil.
+	Clock Clock
+	// Logger, if set, receives diagnostics such as failed pings. Nothing is
+	// logged without it.
+	Logger Logger
+	// PayloadSize is the payload length of probes in bytes, up to
+	// MaxPayloadSize, e.g. 1472 to probe with full 1500 byte IPv4 packets;
+	// runs fail with any other. Zero keeps each probe mode's default. The payload repeats
+	// PayloadPattern, or is zeros if it is empty.
+	PayloadSize    int
+	PayloadPattern []byte
+	// ProbeJitter, if set, delays every ping by a random duration up to
+	// ProbeJitter, so probes don't leave in lockstep.
+	ProbeJitter time.Duration
+	// Shuffle, if set, pings the hups in random order rather than by TTL:
+	// one after another in RunMTR and RunMTRWithNoRetryPing, and Run starts
+	// its workers in that order.
+	Shuffle bool
+	// Seed seeds ProbeJitter and Shuffle. If zero, every run draws its own.
+	// Either way the seed is recorded in MTRReport.Seed.
+	Seed int64
+	// Interval is the least time between the pings of a hop. Zero sends
+	// the next as soon as the last is answered or times out.
+	Interval time.Duration
+	// RateLimit, if set, limits the probes of every hop and run, and of
+	// other OPMTRs sharing it, including traces. ICMPv4 traces then probe
+	// one TTL at a time.
+	RateLimit *RateLimiter
+	// HopNames, if set, renders the Display name of every responding hup.
+	HopNames *HopTemplate
+	// MPLS, if set, reports the MPLS label stacks of hups inside tunnels in
+	// MTRHup.MPLS. ICMPv4 traces then probe one TTL at a time, and ICMP
+	// datagram sockets never see the labels.
+	MPLS bool
+
+	icmp4 *icmp4Prober
+	icmp6 *icmp6Prober
+	udp   *udpProber
+	tcp   *tcpProber
+	dgram *dgramProber
+	gate  *priorityGate
+	// labels are the MPLS label stacks reported to the backends
+	labels *labelBook
 }
 
-func NewOPMTR(src string, maxHops, count, maxUnknowns int, timeout time.Duration) (*OPMTR, error) {
+// ProbeFunc sends one probe to ip limited to ttl hops and waits up to timeout
+// for the reply. It returns a nil reply and nil error on timeout, and
+// ctx.Err() as soon as ctx is done. A Destination Unreachable answer is
+// returned as the reply along with an *UnreachableError. Setting
+// OPMTR.Probe lets other transports reuse op-mtr's scheduling and statistics.
+type ProbeFunc func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error)
+
+// NewOPMTR returns an OPMTR probing from src. Without options it traces up
+// to 30 hops, stops after 5 unknown hops in a row and sends 20 pings per hop
+// with a 1s timeout.
+func NewOPMTR(src string, opts ...Option) (*OPMTR, error) {
 	srcIP := net.ParseIP(src)
 	if srcIP == nil {
-		return nil, errors.New("Unknown source IP")
+		return nil, fmt.Errorf("%w: %q is not an IP address", ErrInvalidSource, src)
+	}
+	o := options{
+		maxHops:     30,
+		count:       20,
+		maxUnknowns: 5,
+		timeout:     time.Second,
+		delay:       10 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	networks := []string{"ip4:icmp"}
+	src4, src6 := src, "::"
+	if srcIP.To4() == nil {
+		networks = []string{"ip6:ipv6-icmp"}
+		src4, src6 = "0.0.0.0", src
+	}
+	if o.networks != nil {
+		networks = o.networks
 	}
+	labels := &labelBook{}
 	op := &OPMTR{
 		Tracer: &traceroute.Tracer{
 			Config: traceroute.Config{
-				Delay:    10 * time.Millisecond,
-				Timeout:  timeout,
-				MaxHops:  maxHops,
+				Delay:    o.delay,
+				Timeout:  o.timeout,
+				MaxHops:  o.maxHops,
 				Count:    1,
-				Networks: []string{"ip4:icmp"},
+				Networks: networks,
 				Addr:     &net.IPAddr{IP: srcIP},
 			},
 		},
-		MaxUnknowns: maxUnknowns,
-		PingCount:   count,
+		MaxUnknowns: o.maxUnknowns,
+		PingCount:   o.count,
+		icmp4:       newICMP4Prober(src4, labels),
+		icmp6:       newICMP6Prober(src6, labels),
+		udp:         newUDPProber(srcIP, labels),
+		tcp:         newTCPProber(srcIP, labels),
+		dgram:       newDgramProber(src4, src6),
+		gate:        newPriorityGate(),
+		labels:      labels,
 	}
+	metrics.Add("open_tracers", 1)
 	return op, nil
 }
 
 func (op *OPMTR) Close() {
 	op.Tracer.Close()
+	op.icmp4.close()
+	op.icmp6.close()
+	op.udp.close()
+	op.tcp.close()
+	op.dgram.close()
+	metrics.Add("open_tracers", -1)
 }
 
 func (op *OPMTR) RunMTRWithNoRetryPing(dst string) (MTRReport, error) {
-	dstIP := net.ParseIP(dst)
-	if dstIP == nil {
-		return MTRReport{}, errors.New("Unknown dest IP")
+	return op.RunMTRWithNoRetryPingContext(context.Background(), dst)
+}
+
+// RunMTRWithNoRetryPingContext is RunMTRWithNoRetryPing bounded by ctx.
+func (op *OPMTR) RunMTRWithNoRetryPingContext(ctx context.Context, dst string) (MTRReport, error) {
+	ctx, err := op.withPayload(ctx)
+	if err != nil {
+		return MTRReport{}, err
+	}
+	dstIP, dstName, err := op.resolve(ctx, dst)
+	if err != nil {
+		return MTRReport{}, runError(err)
 	}
+	metrics.Add("active_runs", 1)
+	defer metrics.Add("active_runs", -1)
+	defer op.gate.enter(PriorityFrom(ctx))()
 	report := MTRReport{
-		Src:   op.Tracer.Addr.String(),
-		Dst:   dst,
-		Count: op.PingCount,
+		Version: ReportVersion,
+		ID:      newReportID(),
+		Src:     op.Tracer.Addr.String(),
+		Dst:     dstIP.String(),
+		DstName: dstName,
+		Count:   op.PingCount,
 	}
-	routes := map[int]*traceroute.Reply{}
-	if err := op.Tracer.Trace(context.Background(), dstIP, func(reply *traceroute.Reply) {
-		if ex, ok := routes[reply.Hops]; ok {
-			log.Printf("Conflict. Hop: %v, newIP: %v, exIP: %v", reply.Hops, reply.IP, ex.IP)
-		} else {
-			routes[reply.Hops] = reply
-		}
-	}); err != nil {
-		return report, err
+	hups, err := op.traceHups(ctx, dstIP)
+	if err != nil {
+		return report, runError(err)
 	}
+	report.Time = op.clock().Now().Unix()
 
-	report.Time = time.Now().Unix()
-	// trace first
-	hups := map[int]*MTRHup{}
-	var unknownCount int
-	for i := 1; i <= op.Tracer.MaxHops; i++ {
-		if r, ok := routes[i]; ok {
-			rtt := r.RTT.Seconds() * 1000
-			hups[i] = &MTRHup{
-				Count:     i,
-				Host:      r.IP.String(),
-				Snt:       1,
-				LossPoint: 0,
-				Last:      rtt,
-				Avg:       rtt,
-				Best:      rtt,
-				Wrst:      rtt,
-			}
-			unknownCount = 0
-			if r.IP.String() == dstIP.String() {
-				break
-			}
+	// ping
+	// only the baseline runs alongside, and is waited for before Errors is read
+	baseline := op.startBaseline(ctx, dstIP, func(e MTRRunError) { report.Errors = append(report.Errors, e) })
+	sched := op.schedule()
+	path := newPathStats(hups)
+	for _, i := range sched.order(len(path.hops)) {
+		st := path.hops[i]
+		if st.Host() != "???" {
+			op.pingKnown(ctx, dstIP, st, op.PingCount-1, op.pacer(sched, hups[i].Count))
 		} else {
-			hups[i] = &MTRHup{
-				Count:     i,
-				Host:      "???",
-				Snt:       1,
-				LossPoint: 1,
-				Last:      0,
-				Avg:       0,
-				Best:      0,
-				Wrst:      0,
+			// not retried, count the rounds as lost
+			for j := 1; j <= op.PingCount-1 && ctx.Err() == nil; j++ {
+				st.Add("", 0)
 			}
-			unknownCount++
-		}
-		h := hups[i]
-		h.Loss = float64(h.LossPoint) / float64(h.Snt)
-		hups[i] = h
-		if unknownCount >= op.MaxUnknowns {
-			break
-		}
-		if h.Host == dst {
-			break
 		}
+		h := st.Snapshot()
+		op.hopDone(&h)
 	}
+	hups = path.snapshot()
+	if sched != nil {
+		report.Seed = sched.seed
+	}
+	report.Baseline = baseline()
 
-	// ping
-	hupsLen := len(hups)
-	for i := 1; i <= hupsLen; i++ {
-		hup := hups[i]
-		for j := 1; j <= op.PingCount-1; j++ {
-			var rp *traceroute.Reply
-			var err error
-			hup.Snt++
-			if hup.Host != "???" {
-				rp, err = ping(op.Tracer, hup.Host, op.Tracer.MaxHops, op.Tracer.Timeout)
-				if err == nil && rp != nil {
-					rtt := rp.RTT.Seconds() * 1000
-					hup.Last = rtt
-					hup.Avg = (hup.Avg*(hup.Snt-1) + rtt) / hup.Snt
-					if hup.Best > rtt {
-						hup.Best = rtt
-					}
-					if hup.Wrst < rtt {
-						hup.Wrst = rtt
-					}
-				} else {
-					if err != nil {
-						log.Println(err)
-					}
-					hup.LossPoint++
-				}
-			}
-		}
-		// log.Println(hup)
-		if hup.Host != "???" {
-			hup.Loss = float64(hup.LossPoint) / float64(hup.Snt)
-		}
+	op.finish(ctx, &report, hups)
+	return report, runError(ctx.Err())
+}
 
+func (op *OPMTR) RunMTR(dst string) (MTRReport, error) {
+	return op.RunMTRContext(context.Background(), dst)
+}
+
+// RunMTRContext is RunMTR bounded by ctx.
+func (op *OPMTR) RunMTRContext(ctx context.Context, dst string) (MTRReport, error) {
+	ctx, err := op.withPayload(ctx)
+	if err != nil {
+		return MTRReport{}, err
 	}
+	dstIP, dstName, err := op.resolve(ctx, dst)
+	if err != nil {
+		return MTRReport{}, runError(err)
+	}
+	metrics.Add("active_runs", 1)
+	defer metrics.Add("active_runs", -1)
+	defer op.gate.enter(PriorityFrom(ctx))()
+	report := MTRReport{
+		Version: ReportVersion,
+		ID:      newReportID(),
+		Src:     op.Tracer.Addr.String(),
+		Dst:     dstIP.String(),
+		DstName: dstName,
+		Count:   op.PingCount,
+	}
+	hups, err := op.traceHups(ctx, dstIP)
+	if err != nil {
+		return report, runError(err)
+	}
+	report.Time = op.clock().Now().Unix()
 
-	for i := 1; i <= hupsLen; i++ {
-		v := hups[i]
-		report.Hups = append(report.Hups, *v)
+	// only the baseline runs alongside, and is waited for before Errors is read
+	baseline := op.startBaseline(ctx, dstIP, func(e MTRRunError) { report.Errors = append(report.Errors, e) })
+	sched := op.schedule()
+	path := newPathStats(hups)
+	for _, i := range sched.order(len(path.hops)) {
+		st := path.hops[i]
+		op.pingHup(ctx, dstIP, path, st, op.pacer(sched, hups[i].Count))
+		h := st.Snapshot()
+		op.hopDone(&h)
+	}
+	hups = path.snapshot()
+	if sched != nil {
+		report.Seed = sched.seed
 	}
-	return report, nil
+	report.Baseline = baseline()
+
+	op.finish(ctx, &report, hups)
+	return report, runError(ctx.Err())
 }
 
-func (op *OPMTR) RunMTR(dst string) (MTRReport, error) {
-	dstIP := net.ParseIP(dst)
-	if dstIP == nil {
-		return MTRReport{}, errors.New("Unknown dest IP")
+// Run traces dst and then pings every hup concurrently, retrying silent hups
+// against the destination with growing timeouts.
+//
+// Failed runs, here and in the other Run methods, still return what was
+// collected along with the error: an empty report if dst can't be
+// resolved (ErrResolve), the report without hups if the trace fails, e.g.
+// with ErrPermission, and the statistics so far if the run is cut short.
+// Errors match ErrInvalidSource, ErrResolve, ErrPermission or ErrTimeout
+// with errors.Is where they apply.
+func (op *OPMTR) Run(dst string) (Report, error) {
+	return op.RunContext(context.Background(), dst)
+}
+
+// RunContext is Run bounded by ctx. When ctx is cancelled or its deadline
+// passes, pending pings stop promptly and the statistics collected so far
+// are returned along with ctx.Err(), wrapped as ErrTimeout if the deadline
+// passed.
+func (op *OPMTR) RunContext(ctx context.Context, dst string) (Report, error) {
+	ctx, err := op.withPayload(ctx)
+	if err != nil {
+		return MTRReport{}, err
+	}
+	dstIP, dstName, err := op.resolve(ctx, dst)
+	if err != nil {
+		return MTRReport{}, runError(err)
 	}
+	metrics.Add("active_runs", 1)
+	defer metrics.Add("active_runs", -1)
+	defer op.gate.enter(PriorityFrom(ctx))()
 	report := MTRReport{
-		Src:   op.Tracer.Addr.String(),
-		Dst:   dst,
-		Count: op.PingCount,
+		Version: ReportVersion,
+		ID:      newReportID(),
+		Src:     op.Tracer.Addr.String(),
+		Dst:     dstIP.String(),
+		DstName: dstName,
+		Count:   op.PingCount,
+	}
+	hups, err := op.traceHups(ctx, dstIP)
+	if err != nil {
+		return report, runError(err)
 	}
+	report.Time = op.clock().Now().Unix()
+
+	// ping cocurrently
+	var errMu sync.Mutex
+	failed := func(e MTRRunError) {
+		errMu.Lock()
+		report.Errors = append(report.Errors, e)
+		errMu.Unlock()
+	}
+	baseline := op.startBaseline(ctx, dstIP, failed)
+	sched := op.schedule()
+	path := newPathStats(hups)
+	var wg sync.WaitGroup
+	for _, i := range sched.order(len(path.hops)) {
+		wg.Add(1)
+		st, hop := path.hops[i], hups[i].Count
+		SafeGo("ping worker", hop, func() {
+			defer wg.Done()
+			op.pingHup(ctx, dstIP, path, st, op.pacer(sched, hop))
+			h := st.Snapshot()
+			op.hopDone(&h)
+		}, failed)
+	}
+
+	wg.Wait()
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Hop < report.Errors[j].Hop })
+	hups = path.snapshot()
+	if sched != nil {
+		report.Seed = sched.seed
+	}
+	report.Baseline = baseline()
+
+	op.finish(ctx, &report, hups)
+	return report, runError(ctx.Err())
+}
+
+// traceHups runs a single trace to dstIP and returns one hup per TTL, ordered
+// by TTL, stopping at the destination or after MaxUnknowns silent hops.
+func (op *OPMTR) traceHups(ctx context.Context, dstIP net.IP) ([]*MTRHup, error) {
 	routes := map[int]*traceroute.Reply{}
-	if err := op.Tracer.Trace(context.Background(), dstIP, func(reply *traceroute.Reply) {
+	// other routers answering at a TTL, e.g. behind ECMP
+	extra := map[int][]*traceroute.Reply{}
+	add := func(reply *traceroute.Reply) {
 		if ex, ok := routes[reply.Hops]; ok {
-			log.Printf("Conflict. Hop: %v, newIP: %v, exIP: %v", reply.Hops, reply.IP, ex.IP)
+			if !ex.IP.Equal(reply.IP) {
+				extra[reply.Hops] = append(extra[reply.Hops], reply)
+			}
 		} else {
 			routes[reply.Hops] = reply
+			if op.Hooks != nil && op.Hooks.OnTraceReply != nil {
+				op.Hooks.OnTraceReply(reply.Hops, reply.IP.String(), reply.RTT)
+			}
 		}
-	}); err != nil {
-		return report, err
+	}
+	cfg := TraceConfig{MaxHops: op.Tracer.MaxHops, MaxUnknowns: op.MaxUnknowns, Timeout: op.Tracer.Timeout}
+	var unreach *UnreachableError
+	if err := op.prober(dstIP.String()).Trace(ctx, dstIP, cfg, add); err != nil && !errors.As(err, &unreach) {
+		return nil, err
 	}
 
-	report.Time = time.Now().Unix()
-	// trace first
-	hups := map[int]*MTRHup{}
+	var hups []*MTRHup
 	var unknownCount int
 	for i := 1; i <= op.Tracer.MaxHops; i++ {
+		var h *MTRHup
 		if r, ok := routes[i]; ok {
 			rtt := r.RTT.Seconds() * 1000
-			hups[i] = &MTRHup{
+			h = &MTRHup{
 				Count:     i,
 				Host:      r.IP.String(),
 				Snt:       1,
@@ -211,12 +610,17 @@ func (op *OPMTR) RunMTR(dst string) (MTRReport, error) {
 				Best:      rtt,
 				Wrst:      rtt,
 			}
-			unknownCount = 0
-			if r.IP.String() == dstIP.String() {
-				break
+			h.addSample(rtt)
+			h.recordHost(h.Host, rtt)
+			if op.MPLS {
+				h.MPLS = op.labels.take(h.Host, dstIP.String(), op.clock().Now())
 			}
+			for _, e := range extra[i] {
+				h.recordHost(e.IP.String(), e.RTT.Seconds()*1000)
+			}
+			unknownCount = 0
 		} else {
-			hups[i] = &MTRHup{
+			h = &MTRHup{
 				Count:     i,
 				Host:      "???",
 				Snt:       1,
@@ -228,292 +632,267 @@ func (op *OPMTR) RunMTR(dst string) (MTRReport, error) {
 			}
 			unknownCount++
 		}
-		h := hups[i]
 		h.Loss = float64(h.LossPoint) / float64(h.Snt)
-		hups[i] = h
-		if unknownCount >= op.MaxUnknowns {
+		hups = append(hups, h)
+		if h.Host == dstIP.String() {
 			break
 		}
-		if h.Host == dst {
+		if unreach != nil && i == unreach.TTL {
+			// nothing is forwarded beyond
+			h.unreachable(unreach.Code)
 			break
 		}
-	}
-
-	// ping cocurrently
-	hupsLen := len(hups)
-	for i := 1; i <= hupsLen; i++ {
-		hup := hups[i]
-		to := op.Tracer.Timeout
-		var retryTime int
-		var workTimeout time.Duration
-		var comeback bool
-		for j := 1; j <= op.PingCount-1; j++ {
-			var rp *traceroute.Reply
-			var err error
-			hup.Snt++
-			if hup.Host != "???" {
-				if comeback {
-					rp, err = ping(op.Tracer, hup.Host, hup.Count, workTimeout)
-				} else {
-					rp, err = ping(op.Tracer, hup.Host, op.Tracer.MaxHops, op.Tracer.Timeout)
-				}
-				if err == nil && rp != nil {
-					rtt := rp.RTT.Seconds() * 1000
-					hup.Last = rtt
-					hup.Avg = (hup.Avg*(hup.Snt-1) + rtt) / hup.Snt
-					if hup.Best > rtt {
-						hup.Best = rtt
-					}
-					if hup.Wrst < rtt {
-						hup.Wrst = rtt
-					}
-				} else {
-					if err != nil {
-						log.Println(err)
-					}
-					hup.LossPoint++
-				}
-			} else {
-				if retryTime >= 4 {
-					continue
-				}
-				if rp, err = ping(op.Tracer, dstIP.String(), hup.Count, to); err == nil && rp != nil {
-					hupsCopy := hups
-					toComeback := true
-					for _, v := range hupsCopy {
-						if v.Host == rp.IP.String() {
-							toComeback = false
-							break
-						}
-					}
-					if toComeback {
-						comeback = true
-						workTimeout = to
-						hup.Host = rp.IP.String()
-						rtt := rp.RTT.Seconds() * 1000
-						hup.Last = rtt
-						hup.Avg = (hup.Avg*(hup.Snt-1) + rtt) / hup.Snt
-						if hup.Best > rtt {
-							hup.Best = rtt
-						}
-						if hup.Wrst < rtt {
-							hup.Wrst = rtt
-						}
-					} else {
-						if to < time.Second*5 {
-							to += time.Second
-						}
-						hup.LossPoint++
-					}
-				} else {
-					if err != nil {
-						log.Println(err)
-					}
-					if to < time.Second*5 {
-						to += time.Second
-					}
-					hup.LossPoint++
-				}
-				retryTime++
-			}
-		}
-		// log.Println(hup)
-		if hup.Host != "???" {
-			hup.Loss = float64(hup.LossPoint) / float64(hup.Snt)
+		if unknownCount >= op.MaxUnknowns {
+			break
 		}
+	}
+	return hups, nil
+}
 
+// checkFamily rejects destinations the source address cannot reach.
+func (op *OPMTR) checkFamily(dstIP net.IP) error {
+	src := op.Tracer.Addr.IP
+	if src.IsUnspecified() || (src.To4() == nil) == (dstIP.To4() == nil) {
+		return nil
 	}
+	return fmt.Errorf("%w: %s can't reach %s, the address families differ", ErrInvalidSource, src, dstIP)
+}
 
-	for i := 1; i <= hupsLen; i++ {
-		v := hups[i]
-		// log.Printf("Count: %v, Host: %v, Snt: %v, Loss: %.2f, Last: %v, Avg: %v, Best: %v, Wrst: %v", v.Count, v.Host, v.Snt, v.Loss, v.Last, v.Avg, v.Best, v.Wrst)
-		report.Hups = append(report.Hups, *v)
+// prober returns the backend for probes to ip.
+func (op *OPMTR) prober(ip string) Prober {
+	if op.Prober != nil {
+		return op.Prober
+	}
+	if op.Probe != nil {
+		return stepProber{op, op.Probe}
+	}
+	paris := op.Flow == FlowParis
+	switch op.ProbeMode {
+	case ProbeUDP:
+		return stepProber{op, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			return op.udp.probe(ctx, ip, ttl, timeout, paris, op.runPayload(ctx))
+		}}
+	case ProbeTCP:
+		port := op.TCPPort
+		if port == 0 {
+			port = DefaultTCPPort
+		}
+		return stepProber{op, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			return op.tcp.probe(ctx, ip, port, ttl, timeout, paris, op.runPayload(ctx))
+		}}
 	}
-	return report, nil
+	if op.datagram() {
+		return stepProber{op, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			return op.dgram.probe(ctx, ip, ttl, timeout, paris, op.runPayload(ctx))
+		}}
+	}
+	if dst := net.ParseIP(ip); dst != nil && dst.To4() == nil {
+		return stepProber{op, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			return op.icmp6.probe(ctx, ip, ttl, timeout, paris, op.runPayload(ctx))
+		}}
+	}
+	if op.Flow != FlowDefault || op.MPLS || op.PayloadSize > 0 || op.RateLimit != nil {
+		// the batch Tracer can't control the flow or payload, see ICMP
+		// extensions or be rate limited
+		return stepProber{op, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			return op.icmp4.probe(ctx, ip, ttl, timeout, paris, op.runPayload(ctx))
+		}}
+	}
+	return tracerProber{op, paris}
 }
 
-func (op *OPMTR) RunMTRWithCocurrentPing(dst string) (MTRReport, error) {
-	dstIP := net.ParseIP(dst)
-	if dstIP == nil {
-		return MTRReport{}, errors.New("Unknown dest IP")
+// finish copies the probed hups into report and records its path.
+func (op *OPMTR) finish(ctx context.Context, report *MTRReport, hups []*MTRHup) {
+	for _, h := range hups {
+		h.percentiles()
+		h.Samples = lastSamples(h.rtts, op.KeepSamples)
 	}
-	report := MTRReport{
-		Src:   op.Tracer.Addr.String(),
-		Dst:   dst,
-		Count: op.PingCount,
+	if op.PTR != nil {
+		op.PTR.Annotate(ctx, hups)
 	}
-	routes := map[int]*traceroute.Reply{}
-	if err := op.Tracer.Trace(context.Background(), dstIP, func(reply *traceroute.Reply) {
-		if ex, ok := routes[reply.Hops]; ok {
-			log.Printf("Conflict. Hop: %v, newIP: %v, exIP: %v", reply.Hops, reply.IP, ex.IP)
-		} else {
-			routes[reply.Hops] = reply
+	if op.ASN != nil {
+		annotateASN(ctx, op.ASN, hups)
+	}
+	if op.Geo != nil {
+		annotateGeo(ctx, op.Geo, hups)
+	}
+	report.PacketSize = op.packetSize(net.ParseIP(report.Dst))
+	for _, v := range hups {
+		report.Hups = append(report.Hups, *v)
+	}
+	estimateSegments(report.Hups)
+	if op.DNS != nil {
+		res := op.DNS.Check(ctx, report.Dst)
+		report.DNS = &res
+	}
+	if op.NTP != nil {
+		res := op.NTP.Check(ctx, report.Dst)
+		report.NTP = &res
+	}
+	if op.Notes != nil {
+		op.Notes.Annotate(report)
+	}
+	if op.Events != nil {
+		op.Events.annotate(report)
+	}
+	if op.HopNames != nil {
+		op.HopNames.annotate(report)
+	}
+	if op.PathDB != nil && op.PathDB.Update(*report) && op.BGP != nil {
+		if e, ok := CorrelateBGP(op.BGP, report.Dst, time.Unix(report.Time, 0), DefaultBGPWindow); ok {
+			op.PathDB.SetTrigger(report.Src, report.Dst, e)
 		}
-	}); err != nil {
-		return report, err
 	}
+}
 
-	report.Time = time.Now().Unix()
-	// trace first
-	hups := map[int]*MTRHup{}
-	var unknownCount int
-	for i := 1; i <= op.Tracer.MaxHops; i++ {
-		if r, ok := routes[i]; ok {
-			rtt := r.RTT.Seconds() * 1000
-			hups[i] = &MTRHup{
-				Count:     i,
-				Host:      r.IP.String(),
-				Snt:       1,
-				LossPoint: 0,
-				Last:      rtt,
-				Avg:       rtt,
-				Best:      rtt,
-				Wrst:      rtt,
-			}
-			unknownCount = 0
-			if r.IP.String() == dstIP.String() {
-				break
+// pingKnown sends up to n pings to the known host of s, stopping early if
+// ctx is done.
+func (op *OPMTR) pingKnown(ctx context.Context, dst net.IP, s *HopStats, n int, pace *hopPacer) {
+	h := s.Snapshot()
+	for j := 0; j < n && ctx.Err() == nil; j++ {
+		rp, err := op.pingHop(ctx, dst, h.Count, h.Host, op.Tracer.MaxHops, op.Tracer.Timeout, pace)
+		if !op.pingInto(ctx, s, rp, err) {
+			return
+		}
+	}
+}
+
+// pingHup sends the remaining PingCount-1 pings of the hup s. An unknown
+// hup is retried up to 4 times towards dst with its TTL and growing
+// timeouts; if a router not yet on the path answers, it becomes the hup's
+// host and is pinged with that TTL from then on. Pings wait for pace first.
+func (op *OPMTR) pingHup(ctx context.Context, dst net.IP, path *pathStats, s *HopStats, pace *hopPacer) {
+	hop := s.Snapshot().Count
+	to := op.Tracer.Timeout
+	var retryTime int
+	var comeback bool
+	for j := 1; j <= op.PingCount-1; j++ {
+		if ctx.Err() != nil {
+			return
+		}
+		if host := s.Host(); host != "???" {
+			ttl, timeout := op.Tracer.MaxHops, op.Tracer.Timeout
+			if comeback {
+				ttl, timeout = hop, to
 			}
-		} else {
-			hups[i] = &MTRHup{
-				Count:     i,
-				Host:      "???",
-				Snt:       1,
-				LossPoint: 1,
-				Last:      0,
-				Avg:       0,
-				Best:      0,
-				Wrst:      0,
+			rp, err := op.pingHop(ctx, dst, hop, host, ttl, timeout, pace)
+			if !op.pingInto(ctx, s, rp, err) {
+				return
 			}
-			unknownCount++
+			continue
 		}
-		h := hups[i]
-		h.Loss = float64(h.LossPoint) / float64(h.Snt)
-		hups[i] = h
-		if unknownCount >= op.MaxUnknowns {
-			break
+		if retryTime >= 4 {
+			s.Add("", 0)
+			continue
 		}
-		if h.Host == dst {
-			break
+		retryTime++
+		rp, err := op.pingHop(ctx, dst, hop, dst.String(), hop, to, pace)
+		if answered(rp, err) && path.claim(s, rp.IP.String(), rp.RTT.Seconds()*1000) {
+			s.unreachable(err)
+			comeback = true
+			continue
+		}
+		if ctx.Err() != nil {
+			// interrupted, not lost
+			return
+		}
+		if err != nil && !answered(rp, err) {
+			logTo(op.Logger, LevelWarn, "ping failed", "hop", hop, "host", dst, "err", err)
 		}
+		if to < time.Second*5 {
+			to += time.Second
+		}
+		s.Add("", 0)
 	}
+}
 
-	// ping cocurrently
-	hupsLen := len(hups)
-	var wg sync.WaitGroup
-	for i := 1; i <= hupsLen; i++ {
-		wg.Add(1)
-		hup := hups[i]
-		go func() {
-			defer wg.Done()
-			to := op.Tracer.Timeout
-			var retryTime int
-			var workTimeout time.Duration
-			var comeback bool
-			for j := 1; j <= op.PingCount-1; j++ {
-				var rp *traceroute.Reply
-				var err error
-				hup.Snt++
-				if hup.Host != "???" {
-					if comeback {
-						rp, err = ping(op.Tracer, hup.Host, hup.Count, workTimeout)
-					} else {
-						rp, err = ping(op.Tracer, hup.Host, op.Tracer.MaxHops, op.Tracer.Timeout)
-					}
-					if err == nil && rp != nil {
-						rtt := rp.RTT.Seconds() * 1000
-						hup.Last = rtt
-						hup.Avg = (hup.Avg*(hup.Snt-1) + rtt) / hup.Snt
-						if hup.Best > rtt {
-							hup.Best = rtt
-						}
-						if hup.Wrst < rtt {
-							hup.Wrst = rtt
-						}
-					} else {
-						if err != nil {
-							log.Println(err)
-						}
-						hup.LossPoint++
-					}
-				} else {
-					if retryTime >= 4 {
-						continue
-					}
-					if rp, err = ping(op.Tracer, dstIP.String(), hup.Count, to); err == nil && rp != nil {
-						hupsCopy := hups
-						toComeback := true
-						for _, v := range hupsCopy {
-							if v.Host == rp.IP.String() {
-								toComeback = false
-								break
-							}
-						}
-						if toComeback {
-							comeback = true
-							workTimeout = to
-							hup.Host = rp.IP.String()
-							rtt := rp.RTT.Seconds() * 1000
-							hup.Last = rtt
-							hup.Avg = (hup.Avg*(hup.Snt-1) + rtt) / hup.Snt
-							if hup.Best > rtt {
-								hup.Best = rtt
-							}
-							if hup.Wrst < rtt {
-								hup.Wrst = rtt
-							}
-						} else {
-							if to < time.Second*5 {
-								to += time.Second
-							}
-							hup.LossPoint++
-						}
-					} else {
-						if err != nil {
-							log.Println(err)
-						}
-						if to < time.Second*5 {
-							to += time.Second
-						}
-						hup.LossPoint++
-					}
-					retryTime++
-				}
-			}
-			// log.Println(hup)
-			if hup.Host != "???" {
-				hup.Loss = float64(hup.LossPoint) / float64(hup.Snt)
-			}
-		}()
+// pingInto adds the outcome of a ping to s. It reports false if the ping
+// was interrupted by ctx, which is not counted as a loss.
+func (op *OPMTR) pingInto(ctx context.Context, s *HopStats, rp *traceroute.Reply, err error) bool {
+	if answered(rp, err) {
+		s.Add(rp.IP.String(), rp.RTT.Seconds()*1000)
+		s.unreachable(err)
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
 	}
+	if err != nil {
+		h := s.Snapshot()
+		logTo(op.Logger, LevelWarn, "ping failed", "hop", h.Count, "host", h.Host, "err", err)
+	}
+	s.Add("", 0)
+	return true
+}
 
-	wg.Wait()
+// pingHop pings on behalf of hop on the way to dst, publishing the ping to
+// op.Hooks. With FlowEnumerate the probe goes towards dst limited to hop
+// hops instead, so whichever router answers at that TTL is recorded. The
+// ping waits for pace first.
+func (op *OPMTR) pingHop(ctx context.Context, dst net.IP, hop int, ip string, ttl int, timeout time.Duration, pace *hopPacer) (*traceroute.Reply, error) {
+	if err := pace.wait(ctx, op.clock()); err != nil {
+		return nil, err
+	}
+	if op.Flow == FlowEnumerate {
+		ip, ttl = dst.String(), hop
+	}
+	h := op.Hooks
+	if h != nil && h.OnPingSent != nil {
+		h.OnPingSent(hop, ip)
+	}
+	r, err := op.ping(ctx, ip, ttl, timeout)
+	if h != nil && h.OnPingReply != nil && ctx.Err() == nil {
+		if answered(r, err) {
+			h.OnPingReply(hop, r.IP.String(), r.RTT, false)
+		} else {
+			h.OnPingReply(hop, "", 0, true)
+		}
+	}
+	return r, err
+}
 
-	for i := 1; i <= hupsLen; i++ {
-		v := hups[i]
-		// log.Printf("Count: %v, Host: %v, Snt: %v, Loss: %.2f, Last: %v, Avg: %v, Best: %v, Wrst: %v", v.Count, v.Host, v.Snt, v.Loss, v.Last, v.Avg, v.Best, v.Wrst)
-		report.Hups = append(report.Hups, *v)
+// hopDone finalizes a hup whose pings are over.
+func (op *OPMTR) hopDone(h *MTRHup) {
+	h.percentiles()
+	h.Samples = lastSamples(h.rtts, op.KeepSamples)
+	if op.Hooks != nil && op.Hooks.OnHopComplete != nil {
+		op.Hooks.OnHopComplete(*h)
 	}
-	return report, nil
 }
 
-func ping(t *traceroute.Tracer, ip string, ttl int, timeout time.Duration) (r *traceroute.Reply, err error) {
-	sess, err := t.NewSession(net.ParseIP(ip))
-	if err != nil {
-		return
+func (op *OPMTR) ping(ctx context.Context, ip string, ttl int, timeout time.Duration) (r *traceroute.Reply, err error) {
+	metrics.Add("pings_waiting", 1)
+	err = op.gate.wait(ctx, PriorityFrom(ctx))
+	if err == nil {
+		err = op.RateLimit.wait(ctx, op.clock())
 	}
-	defer sess.Close()
-	err = sess.Ping(ttl)
+	metrics.Add("pings_waiting", -1)
 	if err != nil {
 		return
 	}
-	select {
-	case r = <-sess.Receive():
-		return
-	case <-time.After(timeout):
-		return
+	metrics.Add("pings_sent", 1)
+	probe := op.prober(ip).Probe
+	if op.Faults != nil {
+		r, err = op.Faults.apply(ctx, op.clock(), timeout, func() (*traceroute.Reply, error) {
+			return probe(ctx, ip, ttl, timeout)
+		})
+	} else {
+		r, err = probe(ctx, ip, ttl, timeout)
+	}
+	if !answered(r, err) && err != nil {
+		metrics.Add("ping_errors", 1)
+	} else if r == nil {
+		metrics.Add("pings_lost", 1)
 	}
+	return
+}
 
+func newReportID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 // ToJSON convert struct to JSON String
@@ -534,27 +913,221 @@ func (r MTRReport) ToRawJSON() ([]byte, error) {
 	return b, nil
 }
 
+// WriteJSON writes the report to w as ToJSON does, but encodes one hup at a
+// time, so long reports with raw samples never sit in memory as a whole.
+func (r MTRReport) WriteJSON(w io.Writer) error {
+	hups := r.Hups
+	r.Hups = nil
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if hups == nil {
+		_, err = w.Write(b)
+		return err
+	}
+	// Quotes inside strings are escaped, so this can only be the key.
+	i := bytes.Index(b, []byte(`"hups":null`))
+	if i < 0 {
+		return errors.New("hups missing from encoded report")
+	}
+	i += len(`"hups":`)
+	if _, err := w.Write(b[:i]); err != nil {
+		return err
+	}
+	sep := []byte("[")
+	for _, h := range hups {
+		hb, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(hb); err != nil {
+			return err
+		}
+		sep = []byte(",")
+	}
+	if len(hups) == 0 {
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte("]")); err != nil {
+		return err
+	}
+	_, err = w.Write(b[i+len("null"):])
+	return err
+}
+
 // PrettyPrint print the MTR report in format
 func (r MTRReport) PrettyPrint() {
-	fmt.Printf("Time: %s\tSrc: %s\tDst: %s\tCount: %d\n", time.Unix(r.Time, 0).String(), r.Src, r.Dst, r.Count)
-	fmt.Printf("%4s    %-20s %5s%%  %4s  %6s  %6s  %6s  %6s\n", "HOP:|", "Address", "Loss", "Snt", "Last", "Avg", "Best", "Wrst")
+	r.Fprint(os.Stdout, Locale{})
+}
+
+// Fprint writes the report to w as PrettyPrint does, with numbers and the
+// time formatted for l.
+func (r MTRReport) Fprint(w io.Writer, l Locale) {
+	dst := r.Dst
+	if r.DstName != "" {
+		dst = fmt.Sprintf("%s (%s)", r.DstName, r.Dst)
+	}
+	fmt.Fprintf(w, "Time: %s\tSrc: %s\tDst: %s\tCount: %d", l.Time(time.Unix(r.Time, 0)), r.Src, dst, r.Count)
+	if r.PacketSize > 0 {
+		fmt.Fprintf(w, "\tSize: %d", r.PacketSize)
+	}
+	if r.Seed != 0 {
+		fmt.Fprintf(w, "\tSeed: %d", r.Seed)
+	}
+	fmt.Fprintln(w)
+	if r.Note != "" {
+		fmt.Fprintf(w, "Note: %s\n", r.Note)
+	}
+	if d := r.DNS; d != nil {
+		status := "ok"
+		if !d.OK {
+			status = d.Error
+		}
+		fmt.Fprintf(w, "DNS: %s %s -> %s in %s ms (%s)\n", d.Type, d.Name, strings.Join(d.Answers, ", "), l.Float(d.RTT, 1), status)
+	}
+	if n := r.NTP; n != nil {
+		status := "ok"
+		if !n.OK {
+			status = n.Error
+		}
+		offset := l.Float(n.Offset, 3)
+		if n.Offset >= 0 {
+			offset = "+" + offset
+		}
+		fmt.Fprintf(w, "NTP: offset %s ms, delay %s ms, stratum %d %s (%s)\n", offset, l.Float(n.Delay, 3), n.Stratum, n.RefID, status)
+	}
+	asn := false
+	for _, h := range r.Hups {
+		asn = asn || h.ASN != 0
+	}
+	fmt.Fprintf(w, "%4s    %-20s %5s%%  %4s  %6s  %6s  %6s  %6s  %6s  %6s\n", "HOP:|", "Address", "Loss", "Snt", "Last", "Avg", "Best", "Wrst", "StDev", "Jitter")
 	for _, h := range r.Hups {
 		if h.Host != "???" {
-			fmt.Printf("%3d:|-- %-20s %5.1f%%  %4v  %6.1f  %6.1f  %6.1f  %6.1f\n",
+			host := h.DisplayName()
+			if asn && h.Display == "" {
+				host = fmt.Sprintf("%-8s %s", asLabel(h.ASN), host)
+			}
+			if a := h.Annotation(); a != "" {
+				host += " " + a
+			}
+			fmt.Fprintf(w, "%3d:|-- %-20s %5s%%  %4s  %6s  %6s  %6s  %6s  %6s  %6s\n",
 				h.Count,
-				h.Host,
-				h.Loss*100.0,
-				h.Snt,
-				h.Last,
-				h.Avg,
-				h.Best,
-				h.Wrst,
+				host,
+				l.Float(h.Loss*100.0, 1),
+				l.Float(h.Snt, -1),
+				l.Float(h.Last, 1),
+				l.Float(h.Avg, 1),
+				l.Float(h.Best, 1),
+				l.Float(h.Wrst, 1),
+				l.Float(h.StDev, 1),
+				l.Float(h.Jitter, 1),
 			)
 		} else {
-			fmt.Printf("%3d:|-- %-20s\n",
+			fmt.Fprintf(w, "%3d:|-- %-20s\n",
 				h.Count,
 				h.Host,
 			)
 		}
+		for _, hh := range h.Hosts {
+			if hh.Host != h.Host {
+				fmt.Fprintf(w, "    |  `|-- %s\n", hh.Host)
+			}
+		}
+		for _, m := range h.MPLS {
+			fmt.Fprintf(w, "    |  [MPLS: Lbl %d Exp %d S %d TTL %d]\n", m.Label, m.Exp, boolInt(m.S), m.TTL)
+		}
+		if h.Note != "" {
+			fmt.Fprintf(w, "        # %s\n", h.Note)
+		}
+	}
+	if b := r.Baseline; b != nil {
+		fmt.Fprintf(w, "%3s:|-- %-20s %5s%%  %4s  %6s  %6s  %6s  %6s  %6s  %6s\n",
+			"e2e",
+			"ping "+r.Dst,
+			l.Float(b.Loss*100.0, 1),
+			l.Float(float64(b.Snt), -1),
+			l.Float(b.Last, 1),
+			l.Float(b.Avg, 1),
+			l.Float(b.Best, 1),
+			l.Float(b.Wrst, 1),
+			l.Float(b.StDev, 1),
+			l.Float(b.Jitter, 1),
+		)
+		if hops := r.AboveBaseline(); len(hops) > 0 {
+			s := make([]string, len(hops))
+			for i, h := range hops {
+				s[i] = strconv.Itoa(h)
+			}
+			verb := "Hops %s lose"
+			if len(hops) == 1 {
+				verb = "Hop %s loses"
+			}
+			fmt.Fprintf(w, verb+" more than the end-to-end pings, likely to ICMP limits rather than path loss\n", strings.Join(s, ", "))
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// asLabel renders an AS number like mtr -z.
+func asLabel(asn int) string {
+	if asn == 0 {
+		return "AS???"
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
+
+// ToCSV writes the report as CSV, a header row followed by one row per hup.
+// Report fields are repeated on every row so rows can be concatenated
+// across reports.
+func (r MTRReport) ToCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "ts", "src", "dst", "dst_name", "hop", "host", "hostname",
+		"loss", "snt", "last", "avg", "best", "wrst", "stdev", "jitter", "p50", "p90", "p99"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, h := range r.Hups {
+		cw.Write([]string{r.ID, strconv.FormatInt(r.Time, 10), r.Src, r.Dst, r.DstName,
+			strconv.Itoa(h.Count), h.Host, h.Hostname,
+			f(h.Loss), f(h.Snt), f(h.Last), f(h.Avg), f(h.Best), f(h.Wrst),
+			f(h.StDev), f(h.Jitter), f(h.P50), f(h.P90), f(h.P99)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ToReport renders the report like `mtr --report --report-wide`, so tools
+// parsing mtr output can read op-mtr reports unchanged.
+func (r MTRReport) ToReport() string {
+	local, err := os.Hostname()
+	if err != nil {
+		local = r.Src
+	}
+	names := make([]string, len(r.Hups))
+	width := len(local)
+	for i, h := range r.Hups {
+		names[i] = h.DisplayName()
+		if len(names[i]) > width {
+			width = len(names[i])
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Start: %s\n", time.Unix(r.Time, 0).Format("2006-01-02T15:04:05-0700"))
+	fmt.Fprintf(&b, "HOST: %-*s%6s%6s%1s%6s%6s%6s%6s%6s\n", width, local,
+		"Loss%", "Snt", " ", "Last", "Avg", "Best", "Wrst", "StDev")
+	for i, h := range r.Hups {
+		fmt.Fprintf(&b, " %2d.|-- %-*s %4.1f%% %5d  %5.1f %5.1f %5.1f %5.1f %5.1f\n",
+			h.Count, width, names[i], h.Loss*100, int(h.Snt), h.Last, h.Avg, h.Best, h.Wrst, h.StDev)
 	}
+	return b.String()
 }
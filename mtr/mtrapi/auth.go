@@ -0,0 +1,140 @@
+package mtrapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Roles, each allowed everything the previous one is.
+const (
+	// RoleViewer may view jobs, monitors and paths.
+	RoleViewer = "viewer"
+	// RoleOperator may also run ad-hoc MTRs.
+	RoleOperator = "operator"
+	// RoleAdmin may also start and stop monitors.
+	RoleAdmin = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ErrUnauthenticated is returned by an Authenticator for requests without
+// valid credentials.
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Authenticator identifies the caller of a request and returns their role,
+// empty if they have none. Errors other than ErrUnauthenticated mean the
+// caller couldn't be checked and are answered with 503.
+type Authenticator interface {
+	Authenticate(r *http.Request) (role string, err error)
+}
+
+// PrincipalAuthenticator is an Authenticator that also names the caller,
+// e.g. by the subject of their token, so Limits count all their tokens
+// together. Callers authenticated otherwise are told apart by token.
+type PrincipalAuthenticator interface {
+	Authenticator
+	AuthenticatePrincipal(r *http.Request) (role, principal string, err error)
+}
+
+// callerKey is the context key of the caller authorize authenticated.
+type callerKey struct{}
+
+// StaticAuth authenticates bearer tokens listed in a static configuration.
+type StaticAuth struct {
+	// Tokens maps each token to its role.
+	Tokens map[string]string `json:"tokens"`
+}
+
+// LoadStaticAuth reads a StaticAuth from a JSON file like
+//
+//	{"tokens": {"<token>": "viewer", "<other token>": "admin"}}
+func LoadStaticAuth(path string) (*StaticAuth, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a StaticAuth
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, err
+	}
+	for _, role := range a.Tokens {
+		if roleRank[role] == 0 {
+			return nil, fmt.Errorf("unknown role %q in %s", role, path)
+		}
+	}
+	return &a, nil
+}
+
+// Authenticate returns the role of the request's bearer token.
+func (a *StaticAuth) Authenticate(r *http.Request) (string, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", ErrUnauthenticated
+	}
+	for t, role := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return role, nil
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// authorize checks that the caller has at least role, answering 401, 403
+// or 503 if not. Without an Authenticator everyone is admin.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, role string) bool {
+	_, ok := s.authorizeCaller(w, r, role)
+	return ok
+}
+
+// authorizeCaller is authorize also returning r carrying the caller for
+// clientKey.
+func (s *Server) authorizeCaller(w http.ResponseWriter, r *http.Request, role string) (*http.Request, bool) {
+	if s.Auth == nil {
+		return r, true
+	}
+	var got, caller string
+	var err error
+	if pa, ok := s.Auth.(PrincipalAuthenticator); ok {
+		got, caller, err = pa.AuthenticatePrincipal(r)
+		caller = "principal " + caller
+	} else if got, err = s.Auth.Authenticate(r); err == nil {
+		// the token is valid, hash it so it isn't kept around
+		sum := sha256.Sum256([]byte(bearerToken(r)))
+		caller = "token " + hex.EncodeToString(sum[:8])
+	}
+	if err != nil && !errors.Is(err, ErrUnauthenticated) {
+		writeError(w, http.StatusServiceUnavailable, "cannot authenticate: "+err.Error())
+		return r, false
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="op-mtr"`)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return r, false
+	}
+	if got == "" {
+		writeError(w, http.StatusForbidden, "no role granted")
+		return r, false
+	}
+	if roleRank[got] < roleRank[role] {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("role %s may not do this, %s required", got, role))
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)), true
+}
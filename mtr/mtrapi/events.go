@@ -0,0 +1,133 @@
+package mtrapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// further ones are dropped for it. Hop events are snapshots, so a later
+// one makes up for a dropped one.
+const eventBuffer = 64
+
+// eventKeepAlive is how often idle streams get a comment, so proxies don't
+// time them out.
+const eventKeepAlive = 15 * time.Second
+
+// event is a Server-Sent Event: name and JSON data.
+type event struct {
+	name string
+	data interface{}
+}
+
+// feed fans the events of a job or monitor out to its subscribers.
+type feed struct {
+	mu     sync.Mutex
+	subs   map[chan event]bool
+	closed bool
+}
+
+func newFeed() *feed {
+	return &feed{subs: map[chan event]bool{}}
+}
+
+// subscribe returns a channel of the feed's events, closed with the feed,
+// and the func unsubscribing it.
+func (f *feed) subscribe() (<-chan event, func()) {
+	ch := make(chan event, eventBuffer)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	f.subs[ch] = true
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.subs[ch] {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *feed) publish(e event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// close publishes last, if set, as the final event and ends the streams.
+func (f *feed) close(last *event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for ch := range f.subs {
+		if last != nil {
+			select {
+			case ch <- *last:
+			default:
+				// make room, the final event matters most
+				<-ch
+				ch <- *last
+			}
+		}
+		close(ch)
+	}
+	f.subs = nil
+}
+
+// stream writes first and then the events of ch as Server-Sent Events
+// until ch closes, the client leaves or the server closes.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, first []event, ch <-chan event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range first {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e event) {
+	b, err := json.Marshal(e.data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, b)
+}
@@ -0,0 +1,202 @@
+package mtrapi
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// progress collects the statistics of a running job from its run's hooks.
+type progress struct {
+	dst   string
+	count int
+	// events, if set, gets the statistics of a hop whenever they change.
+	events *feed
+
+	mu   sync.Mutex
+	hops map[int]*mtr.HopStats
+}
+
+func newProgress(t mtr.Target, count int) *progress {
+	if t.Count != 0 {
+		count = t.Count
+	}
+	return &progress{dst: t.Dst, count: count, hops: map[int]*mtr.HopStats{}}
+}
+
+// hooks returns hooks feeding p that also call next, if set.
+func (p *progress) hooks(next *mtr.Hooks) *mtr.Hooks {
+	h := &mtr.Hooks{}
+	if next != nil {
+		*h = *next
+	}
+	h.OnTraceReply = func(hop int, host string, rtt time.Duration) {
+		if next != nil && next.OnTraceReply != nil {
+			next.OnTraceReply(hop, host, rtt)
+		}
+		p.stats(hop, host).Add(host, rtt.Seconds()*1000)
+		p.changed(hop)
+	}
+	h.OnPingReply = func(hop int, from string, rtt time.Duration, lost bool) {
+		if next != nil && next.OnPingReply != nil {
+			next.OnPingReply(hop, from, rtt, lost)
+		}
+		if lost {
+			from = ""
+		}
+		p.stats(hop, "???").Add(from, rtt.Seconds()*1000)
+		p.changed(hop)
+	}
+	return h
+}
+
+// stats returns the statistics of hop, starting them at host if new.
+func (p *progress) stats(hop int, host string) *mtr.HopStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.hops[hop]
+	if !ok {
+		s = mtr.NewHopStats(mtr.MTRHup{Count: hop, Host: host})
+		p.hops[hop] = s
+	}
+	return s
+}
+
+// snapshot returns the statistics of hop so far. A hop the trace missed
+// is shown at the first address that answered its pings.
+func (p *progress) snapshot(hop int) mtr.MTRHup {
+	h := p.stats(hop, "").Snapshot()
+	if h.Host == "???" && len(h.Hosts) > 0 {
+		h.Host = h.Hosts[0].Host
+	}
+	return h
+}
+
+// changed publishes the statistics of hop to p.events.
+func (p *progress) changed(hop int) {
+	if p.events != nil {
+		p.events.publish(event{"hop", p.snapshot(hop)})
+	}
+}
+
+// report returns the statistics so far as a partial report, without an
+// id or time.
+func (p *progress) report() *mtr.MTRReport {
+	p.mu.Lock()
+	hops := make([]int, 0, len(p.hops))
+	for hop := range p.hops {
+		hops = append(hops, hop)
+	}
+	p.mu.Unlock()
+	sort.Ints(hops)
+	r := &mtr.MTRReport{Version: mtr.ReportVersion, Dst: p.dst, Count: p.count}
+	for _, hop := range hops {
+		r.Hups = append(r.Hups, p.snapshot(hop))
+	}
+	return r
+}
+
+// startJob runs t in the background as a new job and returns a copy of it.
+// done is called once the run is over.
+func (s *Server) startJob(t mtr.Target, done func()) Job {
+	ctx, cancel := context.WithCancel(mtr.WithPriority(s.ctx, mtr.PriorityInteractive))
+	job := &Job{ID: newJobID(), Status: StatusRunning, cancel: cancel, progress: newProgress(t, s.OPMTR.PingCount), events: newFeed()}
+	job.progress.events = job.events
+	s.store(job)
+	accepted := *job
+	mtr.SafeGo("job", 0, func() {
+		defer done()
+		defer cancel()
+		s.finish(ctx, job, t)
+	}, func(e mtr.MTRRunError) {
+		s.logError("job failed", "id", job.ID, "err", e.Error)
+		s.mu.Lock()
+		job.progress = nil
+		if job.Status == StatusRunning {
+			job.Status, job.Error = StatusFailed, e.Error
+		}
+		last := *job
+		s.mu.Unlock()
+		if job.events != nil {
+			job.events.close(&event{"done", last})
+		}
+	})
+	return accepted
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	done, ok := s.admit(w, r)
+	if !ok {
+		return
+	}
+	job := s.startJob(req.Target, done)
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var status string
+	if ok {
+		status = job.Status
+	}
+	running := status == StatusRunning
+	if running {
+		job.cancelled = true
+		job.cancel()
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "unknown id")
+	case !running:
+		writeError(w, http.StatusConflict, "job already "+status)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// jobEvents streams a job as Server-Sent Events: "hop" events with the
+// statistics of a hop whenever they change, starting with those so far,
+// and a final "done" event with the job once it is over.
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var j Job
+	var ch <-chan event
+	unsubscribe := func() {}
+	if ok {
+		j = *job
+		if j.Status == StatusRunning && job.events != nil {
+			ch, unsubscribe = job.events.subscribe()
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown id")
+		return
+	}
+	defer unsubscribe()
+	var first []event
+	if j.progress != nil {
+		for _, h := range j.progress.report().Hups {
+			first = append(first, event{"hop", h})
+		}
+	}
+	if ch == nil {
+		first = append(first, event{"done", j})
+		closed := make(chan event)
+		close(closed)
+		ch = closed
+	}
+	s.stream(w, r, first, ch)
+}
@@ -0,0 +1,124 @@
+package mtrapi
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits protects the probe host from callers of POST /mtr, such as
+// dashboards refreshing too often or runaway scripts. Zero fields don't
+// limit. Callers are told apart by whom Server.Auth authenticates them as,
+// or by address without Auth.
+type Limits struct {
+	// RunsPerMinute is the rate at which each caller may start runs, in
+	// bursts of up to Burst runs (at least 1).
+	RunsPerMinute float64
+	Burst         int
+	// MaxRunsPerClient is the number of runs each caller may have in
+	// flight, async ones included.
+	MaxRunsPerClient int
+	// MaxRuns is the number of runs in flight for all callers together.
+	MaxRuns int
+	// MaxCount is the most pings per hop a run or monitor may ask for, so
+	// a single admitted run can't hold its slot for hours.
+	MaxCount int
+}
+
+// clientIdle is how long a caller's limit state is kept after its last run.
+const clientIdle = 10 * time.Minute
+
+// client is the limit state of one caller.
+type client struct {
+	// tokens is the number of runs it may start as of refilled.
+	tokens   float64
+	refilled time.Time
+	inFlight int
+	// seen is when it last started or ended a run.
+	seen time.Time
+}
+
+// clientKey identifies the caller of r: as authenticated by authorize, or
+// by address. Tokens that weren't authenticated don't count, or callers
+// could escape their limits by sending random ones.
+func clientKey(r *http.Request) string {
+	if caller, ok := r.Context().Value(callerKey{}).(string); ok {
+		return caller
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr " + host
+}
+
+// admit starts a run for the caller of r within s.Limits and returns the
+// func ending it. If a limit is hit it answers 429 and returns false.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	c, retry, msg := s.take(clientKey(r), time.Now())
+	if c == nil {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, http.StatusTooManyRequests, msg)
+		return nil, false
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.inFlight--
+		s.inFlight--
+		c.seen = time.Now()
+	}, true
+}
+
+// take counts a run of the caller key starting at now and returns its
+// state. If a limit is hit it returns nil, the seconds to wait and why.
+func (s *Server) take(key string, now time.Time) (*client, int, string) {
+	l := s.Limits
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients == nil {
+		s.clients = map[string]*client{}
+	}
+	if now.Sub(s.swept) > time.Minute {
+		for k, c := range s.clients {
+			if c.inFlight == 0 && now.Sub(c.seen) > clientIdle {
+				delete(s.clients, k)
+			}
+		}
+		s.swept = now
+	}
+	c := s.clients[key]
+	if c == nil {
+		c = &client{tokens: float64(burst(l)), refilled: now}
+		s.clients[key] = c
+	}
+	c.seen = now
+	if l.MaxRuns > 0 && s.inFlight >= l.MaxRuns {
+		return nil, 1, "too many runs in flight, try again later"
+	}
+	if l.MaxRunsPerClient > 0 && c.inFlight >= l.MaxRunsPerClient {
+		return nil, 1, fmt.Sprintf("at most %d runs in flight per client", l.MaxRunsPerClient)
+	}
+	if l.RunsPerMinute > 0 {
+		perSec := l.RunsPerMinute / 60
+		c.tokens = math.Min(float64(burst(l)), c.tokens+now.Sub(c.refilled).Seconds()*perSec)
+		c.refilled = now
+		if c.tokens < 1 {
+			return nil, int(math.Ceil((1 - c.tokens) / perSec)), fmt.Sprintf("rate limit of %g runs per minute exceeded", l.RunsPerMinute)
+		}
+		c.tokens--
+	}
+	c.inFlight++
+	s.inFlight++
+	return c, 0, ""
+}
+
+func burst(l Limits) int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
@@ -0,0 +1,112 @@
+package mtrapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTakeRate(t *testing.T) {
+	s := &Server{Limits: Limits{RunsPerMinute: 60, Burst: 2}}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 2; i++ {
+		if c, _, msg := s.take("a", now); c == nil {
+			t.Fatalf("run %d of the burst refused: %s", i+1, msg)
+		}
+	}
+	c, retry, _ := s.take("a", now)
+	if c != nil || retry != 1 {
+		t.Fatalf("run past the burst = %v, retry %d, want refused for 1s", c, retry)
+	}
+	if c, _, _ := s.take("b", now); c == nil {
+		t.Error("other caller refused")
+	}
+	if c, _, msg := s.take("a", now.Add(time.Second)); c == nil {
+		t.Errorf("run after refill refused: %s", msg)
+	}
+}
+
+func TestTakeInFlight(t *testing.T) {
+	s := &Server{Limits: Limits{MaxRunsPerClient: 1, MaxRuns: 2}}
+	now := time.Unix(1000, 0)
+	a, _, _ := s.take("a", now)
+	if c, _, _ := s.take("a", now); c != nil {
+		t.Error("second run in flight of a caller admitted")
+	}
+	if c, _, _ := s.take("b", now); c == nil {
+		t.Error("run of another caller refused")
+	}
+	if c, _, _ := s.take("c", now); c != nil {
+		t.Error("run past MaxRuns admitted")
+	}
+	a.inFlight--
+	s.inFlight--
+	if c, _, _ := s.take("a", now); c == nil {
+		t.Error("run after the last ended refused")
+	}
+}
+
+func TestLimitsKey(t *testing.T) {
+	const body = `{"dst":"10.0.0.3"}`
+	// without Auth, made-up tokens don't escape the address's limit
+	s := newTestServer(t)
+	s.Limits = Limits{RunsPerMinute: 1}
+	if w := do(s, http.MethodPost, "/v1/jobs", body, "a"); w.Code != http.StatusAccepted {
+		t.Fatalf("first run = %d %s", w.Code, w.Body)
+	}
+	if w := do(s, http.MethodPost, "/v1/jobs", body, "b"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("run with another token = %d, want 429", w.Code)
+	}
+
+	// with Auth, each authenticated caller has their own
+	s = newTestServer(t)
+	s.Limits = Limits{RunsPerMinute: 1}
+	s.Auth = &StaticAuth{Tokens: map[string]string{"t1": RoleOperator, "t2": RoleOperator}}
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"t1", http.StatusAccepted},
+		{"t1", http.StatusTooManyRequests},
+		{"t2", http.StatusAccepted},
+		{"bogus", http.StatusUnauthorized},
+	} {
+		if w := do(s, http.MethodPost, "/v1/jobs", body, tt.token); w.Code != tt.want {
+			t.Errorf("run with token %s = %d, want %d", tt.token, w.Code, tt.want)
+		}
+	}
+}
+
+func TestLimitsMaxCount(t *testing.T) {
+	s := newTestServer(t)
+	s.Limits = Limits{MaxCount: 5, MaxRuns: 1}
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/mtr", `{"dst": "10.0.0.3", "count": 100000000}`, http.StatusBadRequest},
+		{http.MethodPost, "/v1/jobs", `{"dst": "10.0.0.3", "count": 6}`, http.StatusBadRequest},
+		{http.MethodPost, "/monitors", `{"dst": "10.0.0.3", "interval": 60, "count": 6}`, http.StatusBadRequest},
+		{http.MethodPost, "/mtr", `{"dst": "10.0.0.3", "count": -1}`, http.StatusBadRequest},
+		{http.MethodPost, "/mtr", `{"dst": "10.0.0.3", "count": 5}`, http.StatusOK},
+	} {
+		if w := do(s, c.method, c.path, c.body, ""); w.Code != c.code {
+			t.Errorf("%s %s %s = %d %s, want %d", c.method, c.path, c.body, w.Code, w.Body, c.code)
+		}
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	s := newTestServer(t)
+	pad := strings.Repeat(" ", maxRequestBody)
+	for _, path := range []string{"/mtr", "/v1/jobs", "/monitors"} {
+		body := `{"dst": "10.0.0.3", "interval": 60` + pad + `}`
+		if w := do(s, http.MethodPost, path, body, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s with a %d byte body = %d, want 400", path, len(body), w.Code)
+		}
+	}
+	if w := do(s, http.MethodPost, "/mtr", `{"dst": "10.0.0.3"}`, ""); w.Code != http.StatusOK {
+		t.Errorf("POST /mtr = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,445 @@
+package mtrapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// DefaultMaxMonitors is the number of monitors a Server runs at once when
+// MaxMonitors is zero.
+const DefaultMaxMonitors = 100
+
+// monitorHistory bounds Monitor.History.
+const monitorHistory = 720
+
+// A failed monitor is restarted after monitorBackoff, doubling with every
+// failure in a row up to maxMonitorBackoff.
+const (
+	monitorBackoff    = time.Second
+	maxMonitorBackoff = 5 * time.Minute
+)
+
+// MonitorRequest is the body of POST /monitors.
+type MonitorRequest struct {
+	Dst string `json:"dst"`
+	// Interval is the time between cycles in seconds, at least 1.
+	Interval float64 `json:"interval"`
+	Count    int     `json:"count,omitempty"`
+	// Group names the target group the monitor is scored with in
+	// GET /health.
+	Group string `json:"group,omitempty"`
+}
+
+// Monitor is a destination measured every Interval seconds with
+// mtr.OPMTR.Monitor. Report is the latest cumulative report and History
+// the destination's loss and average RTT per cycle, oldest first; both are
+// left out of listings.
+//
+// A monitor that fails, e.g. on a panic, is restarted with exponential
+// backoff and its statistics start over. Restarts counts the restarts and
+// Error is the last failure, until the next cycle completes.
+type Monitor struct {
+	ID       string         `json:"id"`
+	Dst      string         `json:"dst"`
+	Group    string         `json:"group,omitempty"`
+	Interval float64        `json:"interval"`
+	Started  int64          `json:"started"`
+	Cycles   int            `json:"cycles"`
+	Paused   bool           `json:"paused"`
+	Restarts int            `json:"restarts,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Report   *mtr.MTRReport `json:"report,omitempty"`
+	History  []MonitorPoint `json:"history,omitempty"`
+}
+
+// MonitorPoint is the destination's loss and average RTT in one cycle.
+type MonitorPoint struct {
+	Time int64   `json:"ts"`
+	Loss float64 `json:"loss"`
+	Avg  float64 `json:"avg"`
+}
+
+type monitorEntry struct {
+	Monitor
+	// mon is the running monitor, replaced on restarts
+	mon    *mtr.Monitor
+	cancel context.CancelFunc
+	events *feed
+	// prev is the destination hup of the previous cumulative report.
+	prev mtr.MTRHup
+}
+
+// observe records the cumulative report r of a new cycle.
+func (m *monitorEntry) observe(r mtr.MTRReport) {
+	m.Cycles++
+	m.Error = ""
+	r.Group = m.Group
+	m.Report = &r
+	if len(r.Hups) == 0 {
+		return
+	}
+	h := r.Hups[len(r.Hups)-1]
+	// Undo the accumulation to get this cycle's figures, unless the hop
+	// changed and its statistics started over.
+	p := MonitorPoint{Time: r.Time, Loss: h.Loss, Avg: h.Avg}
+	if prev := m.prev; prev.Host == h.Host && h.Snt > prev.Snt {
+		lost := float64(h.LossPoint - prev.LossPoint)
+		p.Loss = lost / (h.Snt - prev.Snt)
+		rcv, prevRcv := h.Snt-float64(h.LossPoint), prev.Snt-float64(prev.LossPoint)
+		p.Avg = 0
+		if rcv > prevRcv {
+			p.Avg = (h.Avg*rcv - prev.Avg*prevRcv) / (rcv - prevRcv)
+		}
+	}
+	m.prev = h
+	m.History = append(m.History, p)
+	if len(m.History) > monitorHistory {
+		m.History = append([]MonitorPoint(nil), m.History[len(m.History)-monitorHistory:]...)
+	}
+}
+
+func (s *Server) listMonitors(w http.ResponseWriter) {
+	s.mu.Lock()
+	list := make([]Monitor, 0, len(s.monitors))
+	for _, id := range s.monitorOrder {
+		m := s.monitors[id].Monitor
+		m.Report, m.History = nil, nil
+		list = append(list, m)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) startMonitor(w http.ResponseWriter, r *http.Request) {
+	var req MonitorRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Dst == "" {
+		writeError(w, http.StatusBadRequest, "missing dst")
+		return
+	}
+	if req.Interval < 1 {
+		writeError(w, http.StatusBadRequest, "interval must be at least 1 second")
+		return
+	}
+	if !s.checkCount(w, req.Count) {
+		return
+	}
+	max := s.MaxMonitors
+	if max <= 0 {
+		max = DefaultMaxMonitors
+	}
+	// reserve a slot, so concurrent requests can't exceed max while the
+	// monitor starts
+	s.mu.Lock()
+	full := len(s.monitors)+s.startingMonitors >= max
+	if !full {
+		s.startingMonitors++
+	}
+	s.mu.Unlock()
+	if full {
+		writeError(w, http.StatusTooManyRequests, "too many monitors")
+		return
+	}
+
+	op := *s.OPMTR
+	if req.Count > 0 {
+		op.PingCount = req.Count
+	}
+	ctx, cancel := context.WithCancel(mtr.WithPriority(s.ctx, mtr.PriorityBackground))
+	interval := time.Duration(req.Interval * float64(time.Second))
+	mon, err := op.NewMonitor(ctx, req.Dst, interval)
+	if err != nil {
+		cancel()
+		s.mu.Lock()
+		s.startingMonitors--
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m := &monitorEntry{
+		Monitor: Monitor{ID: newJobID(), Dst: req.Dst, Group: req.Group, Interval: req.Interval, Started: time.Now().Unix()},
+		mon:     mon,
+		cancel:  cancel,
+		events:  newFeed(),
+	}
+	s.mu.Lock()
+	s.startingMonitors--
+	s.monitors[m.ID] = m
+	s.monitorOrder = append(s.monitorOrder, m.ID)
+	created := m.Monitor
+	s.mu.Unlock()
+	mtr.SafeGo("monitor supervisor", 0, func() {
+		s.supervise(ctx, m, &op, interval)
+		s.mu.Lock()
+		stopped := m.Monitor
+		s.mu.Unlock()
+		stopped.Report, stopped.History = nil, nil
+		m.events.close(&event{"stopped", stopped})
+	}, func(e mtr.MTRRunError) {
+		s.logError("monitor failed", "id", m.ID, "err", e.Error)
+		cancel()
+		s.mu.Lock()
+		m.Error = e.Error
+		stopped := m.Monitor
+		s.mu.Unlock()
+		stopped.Report, stopped.History = nil, nil
+		m.events.close(&event{"stopped", stopped})
+	})
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// supervise feeds the reports of m's monitor into m until ctx is done,
+// restarting the monitor with op after monitorBackoff, doubling up to
+// maxMonitorBackoff, whenever it fails. The backoff starts over once a
+// restarted monitor completes a cycle.
+func (s *Server) supervise(ctx context.Context, m *monitorEntry, op *mtr.OPMTR, interval time.Duration) {
+	backoff := monitorBackoff
+	s.mu.Lock()
+	mon := m.mon
+	s.mu.Unlock()
+	for {
+		if s.feed(m, mon) {
+			backoff = monitorBackoff
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		err := mon.Err()
+		if err == nil {
+			err = errors.New("monitor stopped")
+		}
+		for {
+			s.monitorFailed(m, err, backoff)
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+			if backoff *= 2; backoff > maxMonitorBackoff {
+				backoff = maxMonitorBackoff
+			}
+			if mon, err = op.NewMonitor(ctx, m.Dst, interval); err == nil {
+				break
+			}
+		}
+		s.mu.Lock()
+		m.mon, m.prev = mon, mtr.MTRHup{}
+		m.Restarts++
+		if m.Paused {
+			mon.Pause()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// feed records the reports of mon into m until mon stops, and reports
+// whether any arrived.
+func (s *Server) feed(m *monitorEntry, mon *mtr.Monitor) bool {
+	var cycled bool
+	for r := range mon.Reports() {
+		cycled = true
+		s.mu.Lock()
+		m.observe(r)
+		var point *MonitorPoint
+		if n := len(m.History); n > 0 && len(r.Hups) > 0 {
+			p := m.History[n-1]
+			point = &p
+		}
+		s.mu.Unlock()
+		for _, h := range r.Hups {
+			m.events.publish(event{"hop", h})
+		}
+		if point != nil {
+			m.events.publish(event{"cycle", point})
+		}
+	}
+	return cycled
+}
+
+// monitorFailed records and publishes the failure of m, to be restarted
+// after backoff.
+func (s *Server) monitorFailed(m *monitorEntry, err error, backoff time.Duration) {
+	s.logError("monitor failed", "id", m.ID, "dst", m.Dst, "err", err, "retry", backoff)
+	s.mu.Lock()
+	m.Error = err.Error()
+	failed := m.Monitor
+	s.mu.Unlock()
+	failed.Report, failed.History = nil, nil
+	m.events.publish(event{"failed", failed})
+}
+
+func (s *Server) getMonitor(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	e, ok := s.monitors[id]
+	var m Monitor
+	if ok {
+		m = e.Monitor
+		m.History = append([]MonitorPoint(nil), m.History...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown monitor")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// monitorEvents streams a monitor as Server-Sent Events: "hop" events with
+// the cumulative statistics of every hop after each cycle, starting with
+// the latest ones, a "cycle" event with the destination's figures of the
+// cycle, a "failed" event with the monitor whenever it fails and is to be
+// restarted, and a final "stopped" event when the monitor stops.
+func (s *Server) monitorEvents(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	e, ok := s.monitors[id]
+	var first []event
+	var ch <-chan event
+	unsubscribe := func() {}
+	if ok {
+		if e.Report != nil {
+			for _, h := range e.Report.Hups {
+				first = append(first, event{"hop", h})
+			}
+		}
+		ch, unsubscribe = e.events.subscribe()
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown monitor")
+		return
+	}
+	defer unsubscribe()
+	s.stream(w, r, first, ch)
+}
+
+func (s *Server) stopMonitor(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	e, ok := s.monitors[id]
+	if ok {
+		delete(s.monitors, id)
+		for i, o := range s.monitorOrder {
+			if o == id {
+				s.monitorOrder = append(s.monitorOrder[:i], s.monitorOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown monitor")
+		return
+	}
+	e.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// controlMonitor pauses, resumes or resets the statistics of a monitor,
+// answering with its state. s.mu is held throughout so a restart carries
+// the state over.
+func (s *Server) controlMonitor(w http.ResponseWriter, id, action string) {
+	s.mu.Lock()
+	e, ok := s.monitors[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "unknown monitor")
+		return
+	}
+	switch action {
+	case "pause":
+		e.mon.Pause()
+		e.Paused = true
+	case "resume":
+		e.mon.Resume()
+		e.Paused = false
+	case "reset":
+		e.mon.ResetStats()
+		e.Report, e.prev = nil, mtr.MTRHup{}
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	m := e.Monitor
+	s.mu.Unlock()
+	m.Report, m.History = nil, nil
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) paths(w http.ResponseWriter) {
+	records := []mtr.PathRecord{}
+	if db := s.OPMTR.PathDB; db != nil {
+		records = append(records, db.Records()...)
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// Health is the body of GET /health.
+type Health struct {
+	Targets []TargetHealth `json:"targets"`
+	Groups  []GroupHealth  `json:"groups"`
+}
+
+// TargetHealth is the health score of a monitor's latest report, from 0
+// (down) to 100 (healthy).
+type TargetHealth struct {
+	Monitor string  `json:"monitor"`
+	Dst     string  `json:"dst"`
+	Group   string  `json:"group,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+// GroupHealth is the mean health score of the monitors of a group.
+type GroupHealth struct {
+	Group   string  `json:"group"`
+	Targets int     `json:"targets"`
+	Score   float64 `json:"score"`
+}
+
+// health scores the monitors that completed a cycle, in the order they
+// were started, and their groups by name.
+func (s *Server) health(w http.ResponseWriter) {
+	scorer := s.Scorer
+	if scorer.PathDB == nil {
+		scorer.PathDB = s.OPMTR.PathDB
+	}
+	type scored struct {
+		id, dst string
+		r       mtr.MTRReport
+	}
+	var reports []scored
+	s.mu.Lock()
+	for _, id := range s.monitorOrder {
+		if m := s.monitors[id]; m.Report != nil {
+			reports = append(reports, scored{id, m.Dst, *m.Report})
+		}
+	}
+	s.mu.Unlock()
+
+	h := Health{Targets: []TargetHealth{}, Groups: []GroupHealth{}}
+	groups := map[string][]mtr.MTRReport{}
+	var names []string
+	for _, x := range reports {
+		h.Targets = append(h.Targets, TargetHealth{Monitor: x.id, Dst: x.dst, Group: x.r.Group, Score: scorer.Score(x.r)})
+		if x.r.Group == "" {
+			continue
+		}
+		if _, ok := groups[x.r.Group]; !ok {
+			names = append(names, x.r.Group)
+		}
+		groups[x.r.Group] = append(groups[x.r.Group], x.r)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		h.Groups = append(h.Groups, GroupHealth{Group: g, Targets: len(groups[g]), Score: scorer.GroupScore(groups[g])})
+	}
+	writeJSON(w, http.StatusOK, h)
+}
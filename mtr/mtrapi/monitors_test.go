@@ -0,0 +1,49 @@
+package mtrapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+	for _, body := range []string{
+		`{"dst": "10.0.0.3", "interval": 60, "group": "core"}`,
+		`{"dst": "10.0.0.2", "interval": 60, "group": "core"}`,
+		`{"dst": "10.0.0.1", "interval": 60}`,
+	} {
+		if w := do(s, http.MethodPost, "/monitors", body, ""); w.Code != http.StatusCreated {
+			t.Fatalf("POST /monitors = %d %s", w.Code, w.Body)
+		}
+	}
+	var h Health
+	for deadline := time.Now().Add(5 * time.Second); len(h.Targets) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /health = %+v, want all monitors scored", h)
+		}
+		time.Sleep(10 * time.Millisecond)
+		w := do(s, http.MethodGet, "/health", "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /health = %d %s", w.Code, w.Body)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, x := range h.Targets {
+		if x.Score != 100 {
+			t.Errorf("%s scored %v, want 100", x.Dst, x.Score)
+		}
+	}
+	if h.Targets[0].Group != "core" || h.Targets[2].Group != "" {
+		t.Errorf("targets %+v", h.Targets)
+	}
+	if len(h.Groups) != 1 || h.Groups[0].Group != "core" || h.Groups[0].Targets != 2 || h.Groups[0].Score != 100 {
+		t.Errorf("groups %+v, want core with 2 targets", h.Groups)
+	}
+	if w := do(s, http.MethodPost, "/health", "", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /health = %d", w.Code)
+	}
+}
@@ -0,0 +1,385 @@
+// Package mtrapi serves MTR runs over HTTP, so op-mtr can run as a probe
+// appliance driven by orchestration:
+//
+//	POST   /mtr            run a target, {"dst": ..., "count": ...}; the
+//	                       report is returned, or with "async": true a job
+//	                       id to poll
+//	GET    /mtr/{id}       the job or report with that id
+//	POST   /v1/jobs        run a target in the background, like POST /mtr
+//	                       with "async": true
+//	GET    /v1/jobs/{id}   a job's status, with the statistics so far while
+//	                       it runs
+//	DELETE /v1/jobs/{id}   cancel a running job, keeping its partial report
+//	GET    /v1/jobs/{id}/events
+//	                       a job's hop updates as Server-Sent Events
+//	GET    /monitors       the running monitors
+//	POST   /monitors       monitor a target, {"dst": ..., "interval": ...}
+//	GET    /monitors/{id}  a monitor with its latest report and history
+//	DELETE /monitors/{id}  stop a monitor
+//	POST   /monitors/{id}/pause, /monitors/{id}/resume
+//	                       stop probing for a while, keeping the
+//	                       statistics, and start again
+//	POST   /monitors/{id}/reset
+//	                       start the statistics over
+//	GET    /monitors/{id}/events
+//	                       a monitor's hops after every cycle, and its
+//	                       failures, as Server-Sent Events
+//	GET    /paths          the path records of the OPMTR's PathDB
+//	GET    /health         the health score of every monitor's latest
+//	                       report and of every group of monitors
+//	GET    /healthz        liveness
+//
+// With UI set, a web UI built on these endpoints is served at /.
+//
+// With Auth set, callers need a role (RoleViewer, RoleOperator or RoleAdmin)
+// to view results, run or cancel MTRs, and start, stop or control monitors
+// respectively.
+// /healthz and the UI's static files are open to everyone.
+//
+// Failed monitors are logged and restarted with capped exponential
+// backoff, see Monitor.
+//
+// Runs started through the API have mtr.PriorityInteractive, so they hold
+// back background runs sharing the OPMTR. Limits caps how many runs each
+// caller may start and have in flight; beyond, POST /mtr answers 429. It
+// also caps the count of runs and monitors, answering 400 above it.
+// Request bodies are limited to 64 KiB.
+package mtrapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// DefaultMaxJobs is the number of finished jobs a Server keeps when MaxJobs
+// is zero.
+const DefaultMaxJobs = 1000
+
+// Job states.
+const (
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Job is a run started through the API. Report is the final report, the
+// partial one of a cancelled job, or while the job runs the statistics so
+// far without id or time.
+type Job struct {
+	ID     string         `json:"id"`
+	Status string         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+	Report *mtr.MTRReport `json:"report,omitempty"`
+
+	cancel    context.CancelFunc
+	cancelled bool
+	// progress collects the statistics of background jobs while they run,
+	// and events streams them.
+	progress *progress
+	events   *feed
+}
+
+// Request is the body of POST /mtr.
+type Request struct {
+	mtr.Target
+	// Async returns a job id right away instead of waiting for the report.
+	Async bool `json:"async,omitempty"`
+}
+
+// Server is an http.Handler running MTRs with OPMTR. It is safe for
+// concurrent use.
+type Server struct {
+	OPMTR *mtr.OPMTR
+	// MaxJobs is the number of finished jobs kept for GET /mtr/{id},
+	// DefaultMaxJobs if zero.
+	MaxJobs int
+	// MaxMonitors is the number of monitors run at once,
+	// DefaultMaxMonitors if zero.
+	MaxMonitors int
+	// UI serves the embedded web UI at /.
+	UI bool
+	// Auth, if set, authenticates callers for role-based access control.
+	// Without it every caller is admin.
+	Auth Authenticator
+	// Limits bounds the runs callers start with POST /mtr.
+	Limits Limits
+	// Scorer rates the monitors for GET /health, with the PathDB of OPMTR
+	// if it has none.
+	Scorer mtr.HealthScorer
+
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	order        []string
+	monitors     map[string]*monitorEntry
+	monitorOrder []string
+	// startingMonitors counts the slots taken by monitors being started
+	startingMonitors int
+	// clients is the limit state of each caller, swept of idle ones at
+	// most once a minute, and inFlight the runs of all of them.
+	clients  map[string]*client
+	swept    time.Time
+	inFlight int
+	// ctx bounds the monitors and is cancelled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewServer returns a Server running MTRs with op.
+func NewServer(op *mtr.OPMTR) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{OPMTR: op, jobs: map[string]*Job{}, monitors: map[string]*monitorEntry{}, ctx: ctx, cancel: cancel}
+}
+
+// logError logs msg to the Logger of OPMTR, if any.
+func (s *Server) logError(msg string, keyvals ...interface{}) {
+	if l := s.OPMTR.Logger; l != nil {
+		l.Log(mtr.LevelError, msg, keyvals...)
+	}
+}
+
+// Close stops all monitors and background jobs.
+func (s *Server) Close() {
+	s.cancel()
+}
+
+// ServeHTTP routes a request to its endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case r.URL.Path == "/mtr" && r.Method == http.MethodPost:
+		if r, ok := s.authorizeCaller(w, r, RoleOperator); ok {
+			s.run(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/mtr/") && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.get(w, strings.TrimPrefix(r.URL.Path, "/mtr/"))
+		}
+	case r.URL.Path == "/v1/jobs" && r.Method == http.MethodPost:
+		if r, ok := s.authorizeCaller(w, r, RoleOperator); ok {
+			s.createJob(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/jobs/") && strings.HasSuffix(r.URL.Path, "/events") && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.jobEvents(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/events"))
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/jobs/") && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.get(w, strings.TrimPrefix(r.URL.Path, "/v1/jobs/"))
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/jobs/") && r.Method == http.MethodDelete:
+		if s.authorize(w, r, RoleOperator) {
+			s.cancelJob(w, strings.TrimPrefix(r.URL.Path, "/v1/jobs/"))
+		}
+	case r.URL.Path == "/monitors" && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.listMonitors(w)
+		}
+	case r.URL.Path == "/monitors" && r.Method == http.MethodPost:
+		if s.authorize(w, r, RoleAdmin) {
+			s.startMonitor(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/monitors/") && strings.HasSuffix(r.URL.Path, "/events") && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.monitorEvents(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/monitors/"), "/events"))
+		}
+	case strings.HasPrefix(r.URL.Path, "/monitors/") && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.getMonitor(w, strings.TrimPrefix(r.URL.Path, "/monitors/"))
+		}
+	case strings.HasPrefix(r.URL.Path, "/monitors/") && strings.Count(r.URL.Path, "/") == 3 && r.Method == http.MethodPost:
+		if s.authorize(w, r, RoleAdmin) {
+			id, action := path.Split(strings.TrimPrefix(r.URL.Path, "/monitors/"))
+			s.controlMonitor(w, strings.TrimSuffix(id, "/"), action)
+		}
+	case strings.HasPrefix(r.URL.Path, "/monitors/") && r.Method == http.MethodDelete:
+		if s.authorize(w, r, RoleAdmin) {
+			s.stopMonitor(w, strings.TrimPrefix(r.URL.Path, "/monitors/"))
+		}
+	case r.URL.Path == "/paths" && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.paths(w)
+		}
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		if s.authorize(w, r, RoleViewer) {
+			s.health(w)
+		}
+	case s.UI && (r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/ui/")) && r.Method == http.MethodGet:
+		serveUI(w, r)
+	case r.URL.Path == "/mtr" || strings.HasPrefix(r.URL.Path, "/mtr/"),
+		r.URL.Path == "/v1/jobs" || strings.HasPrefix(r.URL.Path, "/v1/jobs/"),
+		r.URL.Path == "/monitors" || strings.HasPrefix(r.URL.Path, "/monitors/"),
+		r.URL.Path == "/paths", r.URL.Path == "/health":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// maxRequestBody bounds the body of requests.
+const maxRequestBody = 64 << 10
+
+// decode reads the JSON body of r into v, answering 400 if it is malformed
+// or longer than maxRequestBody.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// checkCount answers 400 and returns false if count is negative or above
+// s.Limits.MaxCount.
+func (s *Server) checkCount(w http.ResponseWriter, count int) bool {
+	if count < 0 {
+		writeError(w, http.StatusBadRequest, "negative count")
+		return false
+	}
+	if max := s.Limits.MaxCount; max > 0 && count > max {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("count above %d", max))
+		return false
+	}
+	return true
+}
+
+// decodeRequest reads the Request of POST /mtr or /v1/jobs, answering 400
+// if it is invalid.
+func (s *Server) decodeRequest(w http.ResponseWriter, r *http.Request) (Request, bool) {
+	var req Request
+	if !decode(w, r, &req) {
+		return req, false
+	}
+	if req.Dst == "" {
+		writeError(w, http.StatusBadRequest, "missing dst")
+		return req, false
+	}
+	return req, s.checkCount(w, req.Count)
+}
+
+func (s *Server) run(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	done, ok := s.admit(w, r)
+	if !ok {
+		return
+	}
+	if req.Async {
+		writeJSON(w, http.StatusAccepted, s.startJob(req.Target, done))
+		return
+	}
+	job := &Job{Status: StatusRunning}
+	s.finish(mtr.WithPriority(r.Context(), mtr.PriorityInteractive), job, req.Target)
+	done()
+	if job.Status == StatusFailed {
+		writeError(w, http.StatusInternalServerError, job.Error)
+		return
+	}
+	s.store(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	job.Report.WriteJSON(w)
+	w.Write([]byte("\n"))
+}
+
+// finish runs t and records the outcome in job. A job without an id takes
+// the report's.
+func (s *Server) finish(ctx context.Context, job *Job, t mtr.Target) {
+	op := s.OPMTR
+	if job.progress != nil {
+		o := *op
+		o.Hooks = job.progress.hooks(op.Hooks)
+		op = &o
+	}
+	report, err := op.RunTarget(ctx, t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		if job.events != nil {
+			job.events.close(&event{"done", *job})
+		}
+	}()
+	job.progress = nil
+	if job.cancelled {
+		job.Status, job.Error = StatusCancelled, "cancelled"
+		if report.ID != "" {
+			job.Report = &report
+		}
+		return
+	}
+	if err != nil {
+		job.Status, job.Error = StatusFailed, err.Error()
+		return
+	}
+	if job.ID == "" {
+		job.ID = report.ID
+	}
+	job.Status, job.Report = StatusDone, &report
+}
+
+func (s *Server) store(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	max := s.MaxJobs
+	if max <= 0 {
+		max = DefaultMaxJobs
+	}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	// Drop the oldest finished jobs; running ones are kept until they end.
+	for i := 0; len(s.jobs) > max && i < len(s.order); {
+		if j := s.jobs[s.order[i]]; j.Status != StatusRunning {
+			delete(s.jobs, s.order[i])
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var j Job
+	if ok {
+		j = *job
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown id")
+		return
+	}
+	if j.progress != nil {
+		j.Report = j.progress.report()
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,42 @@
+package mtrapi
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// newTestServer returns a Server probing a fake network, where hop n
+// answers from 10.0.0.n and 10.0.0.3 is the destination.
+func newTestServer(t *testing.T) *Server {
+	op, err := mtr.NewOPMTR("127.0.0.1", mtr.WithPingCount(2), mtr.WithMaxHops(5), mtr.WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.Probe = func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+		if ttl > 3 {
+			ttl = 3
+		}
+		return &traceroute.Reply{IP: net.IPv4(10, 0, 0, byte(ttl)), RTT: time.Millisecond, Hops: ttl}, nil
+	}
+	s := NewServer(op)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// do serves a request with body, if any, and bearer token, if any.
+func do(s *Server, method, path, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,355 @@
+package mtrapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256" // RS256
+	_ "crypto/sha512" // RS384, RS512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcLeeway is the clock skew tolerated when checking exp and nbf.
+	oidcLeeway = time.Minute
+	// jwksMinRefresh limits refetching the issuer's keys for unknown key
+	// IDs, so bogus tokens can't hammer the issuer.
+	jwksMinRefresh = time.Minute
+	// jwksMaxAge is how long fetched keys are used before refetching them.
+	jwksMaxAge = time.Hour
+	// jwksFetchTimeout bounds a fetch of the issuer's keys, which doesn't
+	// end with the request that started it since others may wait for it.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// OIDCAuth authenticates bearer tokens issued by an OpenID Connect provider:
+// JWTs signed with RS256, RS384 or RS512 by one of the keys the issuer
+// publishes, whose issuer and audience match. The caller's role is taken from
+// a claim of the token.
+type OIDCAuth struct {
+	// Issuer is the provider's issuer URL, e.g. "https://sso.example.com".
+	// Its keys are found through Issuer/.well-known/openid-configuration.
+	Issuer string
+	// Audience must be among the token's aud claim, usually the client ID
+	// the agent is registered as. Without it every token is rejected.
+	Audience string
+	// RoleClaim is the claim holding the caller's role or roles, a string
+	// or an array of them, "roles" if empty.
+	RoleClaim string
+	// Roles maps claim values, e.g. group names, to roles. If nil the
+	// values must be role names. Of several roles the caller gets the
+	// highest.
+	Roles map[string]string
+	// DefaultRole is the role of valid tokens without a known role. If
+	// empty such callers are forbidden everything.
+	DefaultRole string
+	// Client fetches the issuer's keys, http.DefaultClient if nil.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	// attempted is when the keys were last fetched, successfully or not,
+	// and fetchErr why that failed.
+	attempted time.Time
+	fetchErr  error
+	// fetch is the fetch in flight, nil if none.
+	fetch *jwksFetch
+}
+
+// jwksFetch is a fetch of the issuer's keys, shared by the requests
+// needing it.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// oidcClaims are the registered claims OIDCAuth checks.
+type oidcClaims struct {
+	Issuer    string       `json:"iss"`
+	Subject   string       `json:"sub"`
+	Audience  stringOrList `json:"aud"`
+	Expires   *float64     `json:"exp"`
+	NotBefore *float64     `json:"nbf"`
+}
+
+// stringOrList decodes a JSON string or array of strings.
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = []string{one}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+var oidcHashes = map[string]crypto.Hash{"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512}
+
+// Authenticate validates the request's bearer token and returns the role
+// it grants. Invalid tokens are reported as ErrUnauthenticated, failures to
+// fetch the issuer's keys as other errors.
+func (a *OIDCAuth) Authenticate(r *http.Request) (string, error) {
+	role, _, err := a.AuthenticatePrincipal(r)
+	return role, err
+}
+
+// AuthenticatePrincipal is Authenticate also returning the caller, the
+// token's issuer and subject.
+func (a *OIDCAuth) AuthenticatePrincipal(r *http.Request) (string, string, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", "", ErrUnauthenticated
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", "", fmt.Errorf("%w: malformed token header", ErrUnauthenticated)
+	}
+	hash, ok := oidcHashes[header.Alg]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unsupported token algorithm %q", ErrUnauthenticated, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: malformed token signature", ErrUnauthenticated)
+	}
+	key, err := a.key(r.Context(), header.Kid)
+	if err != nil {
+		return "", "", err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig); err != nil {
+		return "", "", fmt.Errorf("%w: bad token signature", ErrUnauthenticated)
+	}
+
+	var claims oidcClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", "", fmt.Errorf("%w: malformed token claims", ErrUnauthenticated)
+	}
+	if err := a.check(claims, time.Now()); err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrUnauthenticated, err)
+	}
+	var all map[string]interface{}
+	if err := decodeSegment(parts[1], &all); err != nil {
+		return "", "", fmt.Errorf("%w: malformed token claims", ErrUnauthenticated)
+	}
+	return a.role(all[a.roleClaim()]), claims.Issuer + " " + claims.Subject, nil
+}
+
+// check validates the issuer, audience and lifetime of claims at now.
+func (a *OIDCAuth) check(c oidcClaims, now time.Time) error {
+	if strings.TrimSuffix(c.Issuer, "/") != strings.TrimSuffix(a.Issuer, "/") {
+		return fmt.Errorf("token issued by %q", c.Issuer)
+	}
+	// without an audience any token of the issuer, meant for any of its
+	// clients, would do
+	if a.Audience == "" {
+		return errors.New("no audience configured")
+	}
+	found := false
+	for _, aud := range c.Audience {
+		found = found || aud == a.Audience
+	}
+	if !found {
+		return errors.New("token not meant for this audience")
+	}
+	if c.Expires == nil {
+		return errors.New("token without expiry")
+	}
+	if now.Add(-oidcLeeway).After(time.Unix(int64(*c.Expires), 0)) {
+		return errors.New("token expired")
+	}
+	if c.NotBefore != nil && now.Add(oidcLeeway).Before(time.Unix(int64(*c.NotBefore), 0)) {
+		return errors.New("token not valid yet")
+	}
+	return nil
+}
+
+func (a *OIDCAuth) roleClaim() string {
+	if a.RoleClaim == "" {
+		return "roles"
+	}
+	return a.RoleClaim
+}
+
+// role returns the highest role granted by the role claim v.
+func (a *OIDCAuth) role(v interface{}) string {
+	var values []string
+	switch v := v.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	best := ""
+	for _, s := range values {
+		role := s
+		if a.Roles != nil {
+			role = a.Roles[s]
+		}
+		if roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	if best == "" {
+		return a.DefaultRole
+	}
+	return best
+}
+
+// key returns the issuer's key kid, refetching the keys if it is unknown
+// or they are old. Fetches happen at most every jwksMinRefresh, failed or
+// not, so bogus tokens or an unreachable issuer can't make every request
+// hit the issuer, and concurrent requests share one fetch.
+func (a *OIDCAuth) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	k, ok := a.keys[kid]
+	if (ok && time.Since(a.fetched) < jwksMaxAge) || (a.fetch == nil && time.Since(a.attempted) < jwksMinRefresh) {
+		err := a.fetchErr
+		a.mu.Unlock()
+		return a.found(k, ok, kid, err)
+	}
+	f := a.fetch
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		a.fetch, a.attempted = f, time.Now()
+		a.mu.Unlock()
+		a.refresh(f)
+	} else {
+		a.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	a.mu.Lock()
+	k, ok = a.keys[kid]
+	a.mu.Unlock()
+	return a.found(k, ok, kid, f.err)
+}
+
+// found returns key k of ID kid if ok, or why it is missing: the keys
+// couldn't be fetched, with fetchErr, or kid is unknown. Old keys are kept
+// while the issuer is unreachable.
+func (a *OIDCAuth) found(k *rsa.PublicKey, ok bool, kid string, fetchErr error) (*rsa.PublicKey, error) {
+	switch {
+	case ok:
+		return k, nil
+	case fetchErr != nil:
+		return nil, fmt.Errorf("fetching keys of %s: %w", a.Issuer, fetchErr)
+	}
+	return nil, fmt.Errorf("%w: unknown token key %q", ErrUnauthenticated, kid)
+}
+
+// refresh runs the fetch f and records its outcome.
+func (a *OIDCAuth) refresh(f *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := a.fetchKeys(ctx)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil {
+		a.keys, a.fetched = keys, time.Now()
+	}
+	a.fetchErr, a.fetch, f.err = err, nil, err
+	close(f.done)
+}
+
+// fetchKeys gets the issuer's RSA signing keys by key ID.
+func (a *OIDCAuth) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var config struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(ctx, strings.TrimSuffix(a.Issuer, "/")+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, err
+	}
+	// a mismatch means a misconfigured or impersonated issuer
+	if strings.TrimSuffix(config.Issuer, "/") != strings.TrimSuffix(a.Issuer, "/") {
+		return nil, fmt.Errorf("OpenID configuration is for issuer %q", config.Issuer)
+	}
+	if config.JWKSURI == "" {
+		return nil, errors.New("no jwks_uri in the OpenID configuration")
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := a.getJSON(ctx, config.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (a *OIDCAuth) getJSON(ctx context.Context, url string, v interface{}) error {
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT into v.
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
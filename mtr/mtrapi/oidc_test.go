@@ -0,0 +1,240 @@
+package mtrapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// issuer is a fake OpenID Connect provider signing tokens with key.
+type issuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// name overrides the issuer of the discovery document.
+	name string
+	// fail fails every request while set.
+	fail atomic.Value
+	// fetches counts the key set requests.
+	fetches int32
+	// block, if set, holds up key set requests until closed.
+	block chan struct{}
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &issuer{key: key}
+	iss.fail.Store(false)
+	iss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if iss.fail.Load().(bool) {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			name := iss.name
+			if name == "" {
+				name = iss.URL
+			}
+			json.NewEncoder(w).Encode(map[string]string{"issuer": name, "jwks_uri": iss.URL + "/keys"})
+		case "/keys":
+			atomic.AddInt32(&iss.fetches, 1)
+			if iss.block != nil {
+				<-iss.block
+			}
+			enc := base64.RawURLEncoding
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA", "use": "sig", "kid": "k1",
+				"n": enc.EncodeToString(key.N.Bytes()),
+				"e": enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// token returns a token signed by iss with kid and claims.
+func (iss *issuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(body)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+// claims returns valid claims for iss granting roles.
+func (iss *issuer) claims(roles ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   iss.URL,
+		"sub":   "alice",
+		"aud":   "op-mtr",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": roles,
+	}
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	iss := newIssuer(t)
+	a := &OIDCAuth{Issuer: iss.URL, Audience: "op-mtr"}
+	expired := iss.claims(RoleAdmin)
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	other := iss.claims(RoleAdmin)
+	other["aud"] = "someone-else"
+	others := iss.claims(RoleAdmin)
+	others["aud"] = []string{"someone-else", "op-mtr-staging"}
+	foreign := iss.claims(RoleAdmin)
+	foreign["iss"] = "https://evil.example.com"
+	tampered := iss.token(t, "k1", iss.claims(RoleViewer))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := []struct {
+		name, token, role string
+		unauthenticated   bool
+	}{
+		{"valid", iss.token(t, "k1", iss.claims(RoleOperator)), RoleOperator, false},
+		{"highest role", iss.token(t, "k1", iss.claims(RoleViewer, RoleAdmin)), RoleAdmin, false},
+		{"no role", iss.token(t, "k1", iss.claims()), "", false},
+		{"expired", iss.token(t, "k1", expired), "", true},
+		{"audience", iss.token(t, "k1", other), "", true},
+		{"audience list", iss.token(t, "k1", others), "", true},
+		{"issuer", iss.token(t, "k1", foreign), "", true},
+		{"signature", tampered, "", true},
+		{"unknown key", iss.token(t, "k2", iss.claims(RoleAdmin)), "", true},
+		{"malformed", "not-a-jwt", "", true},
+	}
+	for _, tt := range tests {
+		role, err := a.Authenticate(bearer(tt.token))
+		if tt.unauthenticated {
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("%s: err = %v, want ErrUnauthenticated", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || role != tt.role {
+			t.Errorf("%s: Authenticate = %q, %v, want %q", tt.name, role, err, tt.role)
+		}
+	}
+	_, principal, err := a.AuthenticatePrincipal(bearer(iss.token(t, "k1", iss.claims(RoleViewer))))
+	if err != nil || principal != iss.URL+" alice" {
+		t.Errorf("AuthenticatePrincipal = %q, %v", principal, err)
+	}
+	// the unknown key refetched the keys once, not on every token
+	if n := atomic.LoadInt32(&iss.fetches); n != 1 {
+		t.Errorf("%d key fetches, want 1", n)
+	}
+}
+
+func TestOIDCSharedFetch(t *testing.T) {
+	iss := newIssuer(t)
+	iss.block = make(chan struct{})
+	a := &OIDCAuth{Issuer: iss.URL, Audience: "op-mtr"}
+	token := iss.token(t, "k1", iss.claims(RoleViewer))
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.Authenticate(bearer(token))
+			errs <- err
+		}()
+	}
+	// the fetch in flight doesn't hold the lock
+	for atomic.LoadInt32(&iss.fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.mu.Lock()
+	a.mu.Unlock()
+	close(iss.block)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 1 {
+		t.Errorf("%d key fetches, want 1", n)
+	}
+}
+
+func TestOIDCFailedFetchThrottled(t *testing.T) {
+	iss := newIssuer(t)
+	iss.fail.Store(true)
+	a := &OIDCAuth{Issuer: iss.URL, Audience: "op-mtr"}
+	token := iss.token(t, "k1", iss.claims(RoleViewer))
+	for i := 0; i < 3; i++ {
+		_, err := a.Authenticate(bearer(token))
+		if err == nil || errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("Authenticate with the issuer down = %v, want a fetch error", err)
+		}
+	}
+	a.mu.Lock()
+	attempted := a.attempted
+	a.mu.Unlock()
+	iss.fail.Store(false)
+	if _, err := a.Authenticate(bearer(token)); err == nil {
+		t.Fatal("keys refetched before jwksMinRefresh passed")
+	}
+	a.mu.Lock()
+	if !a.attempted.Equal(attempted) {
+		t.Error("failed fetch retried before jwksMinRefresh passed")
+	}
+	a.attempted = a.attempted.Add(-jwksMinRefresh)
+	a.mu.Unlock()
+	if role, err := a.Authenticate(bearer(token)); err != nil || role != RoleViewer {
+		t.Errorf("Authenticate after jwksMinRefresh = %q, %v", role, err)
+	}
+}
+
+func TestOIDCIssuerMismatch(t *testing.T) {
+	iss := newIssuer(t)
+	iss.name = "https://evil.example.com"
+	a := &OIDCAuth{Issuer: iss.URL, Audience: "op-mtr"}
+	_, err := a.Authenticate(bearer(iss.token(t, "k1", iss.claims(RoleViewer))))
+	if err == nil || errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authenticate = %v, want a discovery error", err)
+	}
+	if n := atomic.LoadInt32(&iss.fetches); n != 0 {
+		t.Errorf("keys fetched from a mismatched issuer")
+	}
+}
+
+func TestOIDCAudienceRequired(t *testing.T) {
+	iss := newIssuer(t)
+	a := &OIDCAuth{Issuer: iss.URL}
+	for _, aud := range []interface{}{"op-mtr", "someone-else", nil} {
+		claims := iss.claims(RoleAdmin)
+		claims["aud"] = aud
+		if _, err := a.Authenticate(bearer(iss.token(t, "k1", claims))); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("aud %v without Audience: err = %v, want ErrUnauthenticated", aud, err)
+		}
+	}
+}
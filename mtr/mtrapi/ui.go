@@ -0,0 +1,29 @@
+package mtrapi
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+var uiHandler = func() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+}()
+
+// serveUI serves index.html at / and the other UI files under /ui/.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		b, _ := uiFiles.ReadFile("ui/index.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+		return
+	}
+	uiHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,221 @@
+'use strict';
+
+let selected = null;
+
+async function api(method, path, body, retried) {
+  const opts = { method: method, headers: {} };
+  if (body !== undefined) {
+    opts.headers['Content-Type'] = 'application/json';
+    opts.body = JSON.stringify(body);
+  }
+  const token = localStorage.getItem('op-mtr-token');
+  if (token) {
+    opts.headers['Authorization'] = 'Bearer ' + token;
+  }
+  const resp = await fetch(path, opts);
+  if (resp.status === 401 && !retried) {
+    const t = prompt('API token');
+    if (t) {
+      localStorage.setItem('op-mtr-token', t);
+      return api(method, path, body, true);
+    }
+  }
+  if (resp.status === 204) {
+    return null;
+  }
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, c => '&#' + c.charCodeAt(0) + ';');
+}
+
+// locale formats numbers and times: the one given as ?locale=, e.g. de-CH,
+// or the browser's. Times are always 24h.
+const locale = (() => {
+  const tag = new URLSearchParams(location.search).get('locale');
+  try {
+    return tag ? Intl.getCanonicalLocales(tag)[0] : undefined;
+  } catch (e) {
+    return undefined;
+  }
+})();
+const oneDecimal = new Intl.NumberFormat(locale, { minimumFractionDigits: 1, maximumFractionDigits: 1 });
+const integer = new Intl.NumberFormat(locale, { maximumFractionDigits: 0 });
+
+function ms(v) {
+  return oneDecimal.format(v);
+}
+
+function num(v) {
+  return integer.format(v);
+}
+
+function time(ts) {
+  return new Date(ts * 1000).toLocaleString(locale, { hourCycle: 'h23' });
+}
+
+function formInts(form, names) {
+  const body = {};
+  for (const name of names) {
+    const v = form.elements[name].value;
+    if (v !== '') {
+      body[name] = Number(v);
+    }
+  }
+  return body;
+}
+
+function hopTable(r) {
+  let html = '<table><thead><tr><th>Hop</th><th>Host</th><th>Loss%</th><th>Snt</th>' +
+    '<th>Last</th><th>Avg</th><th>Best</th><th>Wrst</th><th>StDev</th></tr></thead><tbody>';
+  for (const h of r.hups) {
+    const name = h.display || (h.hostname ? h.hostname + ' (' + h.host + ')' : h.host);
+    if (h.host === '???') {
+      html += '<tr><td>' + h.count + '</td><td>???</td><td colspan="7"></td></tr>';
+      continue;
+    }
+    html += '<tr><td>' + h.count + '</td><td>' + esc(name) + '</td>' +
+      '<td' + (h.Loss > 0 ? ' class="bad"' : '') + '>' + ms(h.Loss * 100) + '</td>' +
+      '<td>' + num(h.Snt) + '</td><td>' + ms(h.Last) + '</td><td>' + ms(h.Avg) + '</td>' +
+      '<td>' + ms(h.Best) + '</td><td>' + ms(h.Wrst) + '</td><td>' + ms(h.StDev) + '</td></tr>';
+  }
+  return html + '</tbody></table>';
+}
+
+document.getElementById('run-form').addEventListener('submit', async e => {
+  e.preventDefault();
+  const form = e.target;
+  const status = document.getElementById('run-status');
+  const body = formInts(form, ['count']);
+  body.dst = form.elements.dst.value;
+  status.textContent = 'Running ' + body.dst + '...';
+  document.getElementById('run-report').innerHTML = '';
+  try {
+    const r = await api('POST', '/mtr', body);
+    status.textContent = r.dst + ' at ' + time(r.ts);
+    document.getElementById('run-report').innerHTML = hopTable(r);
+  } catch (err) {
+    status.textContent = err.message;
+  }
+});
+
+document.getElementById('monitor-form').addEventListener('submit', async e => {
+  e.preventDefault();
+  const form = e.target;
+  const body = formInts(form, ['interval', 'count']);
+  body.dst = form.elements.dst.value;
+  try {
+    const m = await api('POST', '/monitors', body);
+    selected = m.id;
+    await refreshMonitors();
+  } catch (err) {
+    alert(err.message);
+  }
+});
+
+async function refreshMonitors() {
+  const list = await api('GET', '/monitors');
+  const tbody = document.querySelector('#monitors tbody');
+  tbody.innerHTML = '';
+  for (const m of list) {
+    const tr = document.createElement('tr');
+    if (m.id === selected) {
+      tr.className = 'selected';
+    }
+    tr.innerHTML = '<td>' + esc(m.dst) + '</td><td>' + m.interval + 's</td><td>' + m.cycles +
+      (m.paused ? ' (paused)' : '') + (m.error ? ' (failed: ' + esc(m.error) + ')' : '') +
+      (m.restarts ? ' (' + m.restarts + ' restarts)' : '') + '</td><td>' + time(m.started) + '</td><td><button class="pause">' +
+      (m.paused ? 'Resume' : 'Pause') + '</button> <button class="reset">Reset</button> ' +
+      '<button class="stop">Stop</button></td>';
+    tr.addEventListener('click', () => {
+      selected = m.id;
+      refreshMonitors();
+      refreshDetail();
+    });
+    tr.querySelector('.pause').addEventListener('click', async e => {
+      e.stopPropagation();
+      await api('POST', '/monitors/' + m.id + (m.paused ? '/resume' : '/pause'));
+      refreshMonitors();
+    });
+    tr.querySelector('.reset').addEventListener('click', async e => {
+      e.stopPropagation();
+      await api('POST', '/monitors/' + m.id + '/reset');
+      refreshMonitors();
+      refreshDetail();
+    });
+    tr.querySelector('.stop').addEventListener('click', async e => {
+      e.stopPropagation();
+      await api('DELETE', '/monitors/' + m.id);
+      if (selected === m.id) {
+        selected = null;
+      }
+      refreshMonitors();
+      refreshDetail();
+    });
+    tbody.appendChild(tr);
+  }
+  if (selected && !list.some(m => m.id === selected)) {
+    selected = null;
+  }
+}
+
+function polyline(points, max, cls) {
+  if (points.length === 0) {
+    return '';
+  }
+  const step = points.length > 1 ? 600 / (points.length - 1) : 0;
+  const coords = points.map((v, i) => (i * step).toFixed(1) + ',' + (155 - v / max * 150).toFixed(1));
+  return '<polyline class="' + cls + '" points="' + coords.join(' ') + '"/>';
+}
+
+async function refreshDetail() {
+  const detail = document.getElementById('monitor-detail');
+  if (!selected) {
+    detail.hidden = true;
+    return;
+  }
+  let m;
+  try {
+    m = await api('GET', '/monitors/' + selected);
+  } catch (err) {
+    detail.hidden = true;
+    return;
+  }
+  detail.hidden = false;
+  document.getElementById('monitor-title').textContent = m.dst + ', ' + m.cycles + ' cycles';
+  const history = m.history || [];
+  const maxAvg = Math.max(1, ...history.map(p => p.avg));
+  document.getElementById('chart').innerHTML =
+    polyline(history.map(p => p.avg), maxAvg, 'avg') + polyline(history.map(p => p.loss), 1, 'loss');
+  document.getElementById('monitor-report').innerHTML = m.report ? hopTable(m.report) : '';
+}
+
+async function refreshPaths() {
+  const records = await api('GET', '/paths');
+  let html = '';
+  for (const rec of records) {
+    html += '<h3>' + esc(rec.src) + ' &rarr; ' + esc(rec.dst) + ', ' + rec.changes + ' changes</h3><ul class="timeline">';
+    const runs = rec.runs || [];
+    for (let i = 0; i < runs.length; i++) {
+      let j = i;
+      while (j + 1 < runs.length && runs[j + 1].path === runs[i].path) {
+        j++;
+      }
+      html += '<li>' + time(runs[i].time) + ' &ndash; ' + time(runs[j].time) + ': ' + esc(runs[i].path) + '</li>';
+      i = j;
+    }
+    html += '</ul>';
+  }
+  document.getElementById('paths').innerHTML = html || '<p>No paths recorded yet.</p>';
+}
+
+refreshMonitors();
+refreshPaths();
+setInterval(refreshMonitors, 5000);
+setInterval(refreshDetail, 2000);
+setInterval(refreshPaths, 30000);
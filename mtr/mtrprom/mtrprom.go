@@ -0,0 +1,196 @@
+// Package mtrprom exposes MTR reports as Prometheus metrics in the text
+// exposition format, so a long-running process can be scraped directly.
+package mtrprom
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// Exporter keeps the latest report per src/dst and serves them as gauges,
+// along with the agent's self-metrics.
+// It is safe for concurrent use.
+type Exporter struct {
+	// Scorer rates each report and target group for the
+	// opmtr_health_score and opmtr_group_health_score gauges, with PathDB
+	// if it has none.
+	Scorer mtr.HealthScorer
+	// PathDB, if set, supplies the opmtr_path_* stability gauges over
+	// Windows, mtr.DefaultStabilityWindows if empty.
+	PathDB  *mtr.PathDB
+	Windows []time.Duration
+
+	mu      sync.RWMutex
+	reports map[string]mtr.MTRReport
+}
+
+// NewExporter returns an empty Exporter.
+func NewExporter() *Exporter {
+	return &Exporter{reports: map[string]mtr.MTRReport{}}
+}
+
+// Update replaces the report for r's src/dst.
+func (e *Exporter) Update(r mtr.MTRReport) {
+	e.mu.Lock()
+	e.reports[r.Src+" "+r.Dst] = r
+	e.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics of all known reports and the agent's
+// self-metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteTo(w)
+}
+
+type gauge struct {
+	name, help string
+	value      func(h mtr.MTRHup) float64
+}
+
+var hopGauges = []gauge{
+	{"opmtr_hop_loss_ratio", "Fraction of probes to the hop that were lost.", func(h mtr.MTRHup) float64 { return h.Loss }},
+	{"opmtr_hop_rtt_last_ms", "Last RTT to the hop in milliseconds.", func(h mtr.MTRHup) float64 { return h.Last }},
+	{"opmtr_hop_rtt_avg_ms", "Average RTT to the hop in milliseconds.", func(h mtr.MTRHup) float64 { return h.Avg }},
+	{"opmtr_hop_rtt_best_ms", "Best RTT to the hop in milliseconds.", func(h mtr.MTRHup) float64 { return h.Best }},
+	{"opmtr_hop_rtt_worst_ms", "Worst RTT to the hop in milliseconds.", func(h mtr.MTRHup) float64 { return h.Wrst }},
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.mu.RLock()
+	reports := make([]mtr.MTRReport, 0, len(e.reports))
+	for _, r := range e.reports {
+		reports = append(reports, r)
+	}
+	e.mu.RUnlock()
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Src != reports[j].Src {
+			return reports[i].Src < reports[j].Src
+		}
+		return reports[i].Dst < reports[j].Dst
+	})
+
+	scorer := e.Scorer
+	if scorer.PathDB == nil {
+		scorer.PathDB = e.PathDB
+	}
+	p := &printer{w: w}
+	for _, g := range hopGauges {
+		p.header(g.name, g.help)
+		for _, r := range reports {
+			for _, h := range r.Hups {
+				p.printf("%s{src=%s,dst=%s,hop=%s,ttl=\"%d\"} %g\n",
+					g.name, quote(r.Src), quote(r.Dst), quote(h.Host), h.Count, g.value(h))
+			}
+		}
+	}
+	p.header("opmtr_hops", "Number of hops to the destination.")
+	for _, r := range reports {
+		p.printf("opmtr_hops{src=%s,dst=%s} %d\n", quote(r.Src), quote(r.Dst), len(r.Hups))
+	}
+	p.header("opmtr_health_score", "Health score of the destination from 0 to 100.")
+	for _, r := range reports {
+		p.printf("opmtr_health_score{src=%s,dst=%s} %g\n", quote(r.Src), quote(r.Dst), scorer.Score(r))
+	}
+	groups := map[string][]mtr.MTRReport{}
+	var names []string
+	for _, r := range reports {
+		if r.Group == "" {
+			continue
+		}
+		if _, ok := groups[r.Group]; !ok {
+			names = append(names, r.Group)
+		}
+		groups[r.Group] = append(groups[r.Group], r)
+	}
+	sort.Strings(names)
+	p.header("opmtr_group_health_score", "Mean health score of the destinations of a target group from 0 to 100.")
+	for _, g := range names {
+		p.printf("opmtr_group_health_score{group=%s} %g\n", quote(g), scorer.GroupScore(groups[g]))
+	}
+
+	if e.PathDB != nil {
+		e.writeStability(p, reports)
+	}
+
+	self := mtr.Metrics()
+	keys := make([]string, 0, len(self))
+	for k := range self {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name, kind := "opmtr_agent_"+k, "gauge"
+		if mtr.IsCounter(k) {
+			name, kind = name+"_total", "counter"
+		}
+		p.typed(name, "op-mtr self-metric "+k+".", kind)
+		p.printf("%s %d\n", name, self[k])
+	}
+	return p.n, p.err
+}
+
+func (e *Exporter) writeStability(p *printer, reports []mtr.MTRReport) {
+	windows := e.Windows
+	if len(windows) == 0 {
+		windows = mtr.DefaultStabilityWindows
+	}
+	type row struct {
+		r mtr.MTRReport
+		w string
+		s mtr.PathStability
+	}
+	var rows []row
+	for _, r := range reports {
+		for _, w := range windows {
+			if s, ok := e.PathDB.Stability(r.Src, r.Dst, w); ok {
+				rows = append(rows, row{r, mtr.FormatWindow(w), s})
+			}
+		}
+	}
+	p.header("opmtr_path_modal_ratio", "Fraction of runs in the window that took the most common path.")
+	for _, x := range rows {
+		p.printf("opmtr_path_modal_ratio{src=%s,dst=%s,window=%s} %g\n", quote(x.r.Src), quote(x.r.Dst), quote(x.w), x.s.Modal)
+	}
+	p.header("opmtr_path_distinct", "Number of distinct paths taken by runs in the window.")
+	for _, x := range rows {
+		p.printf("opmtr_path_distinct{src=%s,dst=%s,window=%s} %d\n", quote(x.r.Src), quote(x.r.Dst), quote(x.w), x.s.Distinct)
+	}
+}
+
+// quote renders a label value with the escapes the text format requires.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+type printer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (p *printer) header(name, help string) {
+	p.typed(name, help, "gauge")
+}
+
+func (p *printer) typed(name, help, kind string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
@@ -0,0 +1,55 @@
+package mtrprom
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+func TestSelfMetricTypes(t *testing.T) {
+	var b strings.Builder
+	if _, err := NewExporter().WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE opmtr_agent_pings_sent_total counter\n",
+		"# TYPE opmtr_agent_ping_errors_total counter\n",
+		"# TYPE opmtr_agent_pings_lost_total counter\n",
+		"# TYPE opmtr_agent_pings_preempted_total counter\n",
+		"# TYPE opmtr_agent_pings_rate_limited_total counter\n",
+		"# TYPE opmtr_agent_active_runs gauge\n",
+		"# TYPE opmtr_agent_active_monitors gauge\n",
+		"# TYPE opmtr_agent_open_sockets gauge\n",
+		"# TYPE opmtr_agent_pings_waiting gauge\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	if strings.Contains(out, "opmtr_agent_pings_sent ") {
+		t.Error("counter exported without the _total suffix")
+	}
+}
+
+func TestGroupHealthScore(t *testing.T) {
+	e := NewExporter()
+	lossy := mtr.MTRReport{Src: "s", Dst: "10.0.0.2", Group: "core", Hups: []mtr.MTRHup{{Count: 1, Host: "10.0.0.2", Loss: 1}}}
+	clean := mtr.MTRReport{Src: "s", Dst: "10.0.0.3", Group: "core", Hups: []mtr.MTRHup{{Count: 1, Host: "10.0.0.3"}}}
+	e.Update(lossy)
+	e.Update(clean)
+	e.Update(mtr.MTRReport{Src: "s", Dst: "10.0.0.4", Hups: []mtr.MTRHup{{Count: 1, Host: "10.0.0.4"}}})
+	var b strings.Builder
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("opmtr_group_health_score{group=\"core\"} %g\n", e.Scorer.GroupScore([]mtr.MTRReport{lossy, clean}))
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("missing %q in\n%s", want, b.String())
+	}
+	if n := strings.Count(b.String(), "opmtr_group_health_score{"); n != 1 {
+		t.Errorf("%d group scores, want 1", n)
+	}
+}
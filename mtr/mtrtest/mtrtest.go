@@ -0,0 +1,128 @@
+// Package mtrtest provides helpers for regression tests against recorded
+// op-mtr reports (golden files).
+package mtrtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// Normalize returns a copy of r with volatile fields (run ID, timestamps
+// of the report and its local events) cleared so that reports from
+// different runs can be compared.
+func Normalize(r mtr.MTRReport) mtr.MTRReport {
+	n := r
+	n.ID = ""
+	n.Time = 0
+	n.Hups = append([]mtr.MTRHup(nil), r.Hups...)
+	n.Errors = append([]mtr.MTRRunError(nil), r.Errors...)
+	if r.LocalEvents != nil {
+		n.LocalEvents = make([]mtr.LocalEvent, len(r.LocalEvents))
+		for i, e := range r.LocalEvents {
+			e.Time = 0
+			n.LocalEvents[i] = e
+		}
+	}
+	return n
+}
+
+// Equal reports whether a and b are equal ignoring volatile fields.
+func Equal(a, b mtr.MTRReport) bool {
+	return len(Diff(a, b)) == 0
+}
+
+// Diff lists the differences between a and b ignoring volatile fields, one
+// per differing JSON field, e.g. "hups[2].Avg: 1.5 != 1.7". Reports are
+// compared as serialized, so a report read back from JSON equals the live
+// one it was recorded from.
+func Diff(a, b mtr.MTRReport) []string {
+	var diffs []string
+	diffJSON("", asJSON(Normalize(a)), asJSON(Normalize(b)), &diffs)
+	return diffs
+}
+
+// asJSON returns r decoded from its JSON form into maps, slices and
+// scalars.
+func asJSON(r mtr.MTRReport) interface{} {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err.Error()
+	}
+	var v interface{}
+	json.Unmarshal(b, &v)
+	return v
+}
+
+// diffJSON appends the differences between the decoded JSON values a and
+// b at path to diffs.
+func diffJSON(path string, a, b interface{}, diffs *[]string) {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		keys := map[string]bool{}
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffJSON(p, am[k], bm[k], diffs)
+		}
+		return
+	}
+	as, aok := a.([]interface{})
+	bs, bok := b.([]interface{})
+	if aok && bok {
+		if len(as) != len(bs) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: %d != %d entries", path, len(as), len(bs)))
+		}
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), as[i], bs[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s != %s", path, jsonString(a), jsonString(b)))
+	}
+}
+
+// jsonString renders a decoded JSON value, null if absent.
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// ReadGolden loads a report recorded with WriteGolden.
+func ReadGolden(path string) (mtr.MTRReport, error) {
+	var r mtr.MTRReport
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+// WriteGolden records the normalized report r as indented JSON at path.
+func WriteGolden(path string, r mtr.MTRReport) error {
+	b, err := json.MarshalIndent(Normalize(r), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,63 @@
+package mtrtest
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+func report() mtr.MTRReport {
+	return mtr.MTRReport{
+		Version: mtr.ReportVersion, ID: "a", Time: 100, Src: "10.0.0.1", Dst: "10.0.0.3", Count: 2,
+		Hups: []mtr.MTRHup{
+			{Count: 1, Host: "10.0.0.1", Snt: 2, Avg: 1, Best: 1, Wrst: 1},
+			{Count: 2, Host: "10.0.0.3", Snt: 2, Avg: 2, Best: 2, Wrst: 2},
+		},
+		LocalEvents: []mtr.LocalEvent{{Time: 90, Kind: mtr.LocalEventLink, Detail: "eth0 down"}},
+	}
+}
+
+func TestDiffIgnoresVolatileFields(t *testing.T) {
+	a, b := report(), report()
+	b.ID, b.Time, b.LocalEvents[0].Time = "b", 200, 190
+	if d := Diff(a, b); len(d) != 0 {
+		t.Errorf("Diff = %q, want none", d)
+	}
+	if a.LocalEvents[0].Time != 90 {
+		t.Error("Normalize changed the report's local events")
+	}
+}
+
+func TestDiffWholeReport(t *testing.T) {
+	a, b := report(), report()
+	b.Seed = 7
+	b.Baseline = &mtr.BaselineResult{Snt: 2}
+	b.Hups[1].Avg = 2.5
+	b.LocalEvents[0].Detail = "eth1 down"
+	want := []string{
+		`baseline: null != {"avg":0,"best":0,"jitter":0,"last":0,"loss":0,"snt":2,"stdev":0,"wrst":0}`,
+		`hups[1].Avg: 2 != 2.5`,
+		`local_events[0].detail: "eth0 down" != "eth1 down"`,
+		`seed: null != 7`,
+	}
+	if d := Diff(a, b); !reflect.DeepEqual(d, want) {
+		t.Errorf("Diff =\n%q\nwant\n%q", d, want)
+	}
+}
+
+func TestGoldenRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := report()
+	if err := WriteGolden(path, r); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadGolden(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(r, g); len(d) != 0 {
+		t.Errorf("golden differs from the report: %q", d)
+	}
+}
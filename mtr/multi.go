@@ -0,0 +1,95 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultWorkers is the number of concurrent runs of RunMulti when
+// OPMTR.Workers is zero.
+const DefaultWorkers = 8
+
+// MultiResult is the outcome of one destination of RunMultiStream.
+type MultiResult struct {
+	Dst    string
+	Report MTRReport
+	Err    error
+}
+
+// RunMultiStream measures dsts with up to op.Workers concurrent runs and
+// sends each result as it completes on the returned channel, which is
+// closed once all are done or ctx is. The runs share op's sockets.
+func (op *OPMTR) RunMultiStream(ctx context.Context, dsts []string) <-chan MultiResult {
+	workers := op.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(dsts) {
+		workers = len(dsts)
+	}
+	jobs := make(chan string)
+	ch := make(chan MultiResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dst := range jobs {
+				res := MultiResult{Dst: dst}
+				// a panicking run fails its destination, the worker goes on
+				if e := recoverRun("run", 0, func() { res.Report, res.Err = op.RunContext(ctx, dst) }); e != nil {
+					res.Err = e.err()
+				}
+				select {
+				case ch <- res:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(ch)
+		defer wg.Wait()
+		defer close(jobs)
+		for _, dst := range dsts {
+			select {
+			case jobs <- dst:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// RunMulti measures dsts concurrently like RunMultiStream and returns the
+// reports in the order of dsts. If any run fails, the error names the
+// failed destinations and their reports hold what was collected.
+func (op *OPMTR) RunMulti(ctx context.Context, dsts []string) ([]MTRReport, error) {
+	reports := make([]MTRReport, len(dsts))
+	index := map[string][]int{}
+	for i, dst := range dsts {
+		index[dst] = append(index[dst], i)
+	}
+	var failed []string
+	var first error
+	for res := range op.RunMultiStream(ctx, dsts) {
+		i := index[res.Dst][0]
+		index[res.Dst] = index[res.Dst][1:]
+		reports[i] = res.Report
+		if res.Err != nil {
+			failed = append(failed, res.Dst)
+			if first == nil {
+				first = res.Err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return reports, err
+	}
+	if len(failed) > 0 {
+		return reports, fmt.Errorf("%d of %d runs failed (%v): %w", len(failed), len(dsts), failed, first)
+	}
+	return reports, nil
+}
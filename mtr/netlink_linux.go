@@ -0,0 +1,147 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rtnetlink multicast groups, missing from package syscall.
+const (
+	rtmgrpLink      = 0x1
+	rtmgrpIPv4Route = 0x40
+	rtmgrpIPv6Route = 0x400
+)
+
+// WatchLocalEvents records interface flaps and routing table changes into
+// l, using netlink, until ctx is done. It returns once the subscription is
+// set up.
+func WatchLocalEvents(ctx context.Context, l *EventLog) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	// Wake up regularly to notice ctx being done.
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		links := map[int32]uint32{}
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			now := time.Now().Unix()
+			for _, m := range msgs {
+				if e, ok := parseNetlinkEvent(m, links); ok {
+					e.Time = now
+					l.Record(e)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// parseNetlinkEvent turns link state changes and main table route updates into
+// events. links remembers interface flags to report only up/down flaps.
+func parseNetlinkEvent(m syscall.NetlinkMessage, links map[int32]uint32) (LocalEvent, bool) {
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			return LocalEvent{}, false
+		}
+		info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		name := fmt.Sprintf("if%d", info.Index)
+		if attrs, err := syscall.ParseNetlinkRouteAttr(&m); err == nil {
+			for _, a := range attrs {
+				if a.Attr.Type == syscall.IFLA_IFNAME {
+					name = string(trimNUL(a.Value))
+				}
+			}
+		}
+		if m.Header.Type == syscall.RTM_DELLINK {
+			delete(links, info.Index)
+			return LocalEvent{Kind: LocalEventLink, Detail: name + " removed"}, true
+		}
+		up := info.Flags&syscall.IFF_UP != 0 && info.Flags&syscall.IFF_RUNNING != 0
+		prev, known := links[info.Index]
+		links[info.Index] = info.Flags
+		wasUp := prev&syscall.IFF_UP != 0 && prev&syscall.IFF_RUNNING != 0
+		// Interfaces seen for the first time are only worth noting if down.
+		if known && up == wasUp || !known && up {
+			return LocalEvent{}, false
+		}
+		state := "down"
+		if up {
+			state = "up"
+		}
+		return LocalEvent{Kind: LocalEventLink, Detail: name + " " + state}, true
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return LocalEvent{}, false
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Table != syscall.RT_TABLE_MAIN {
+			return LocalEvent{}, false
+		}
+		bits := 32
+		if rt.Family == syscall.AF_INET6 {
+			bits = 128
+		}
+		dst := net.IPNet{IP: make(net.IP, bits/8), Mask: net.CIDRMask(int(rt.Dst_len), bits)}
+		var gw string
+		if attrs, err := syscall.ParseNetlinkRouteAttr(&m); err == nil {
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case syscall.RTA_GATEWAY:
+					gw = net.IP(a.Value).String()
+				case syscall.RTA_DST:
+					dst.IP = net.IP(a.Value)
+				}
+			}
+		}
+		action := "added"
+		if m.Header.Type == syscall.RTM_DELROUTE {
+			action = "removed"
+		}
+		detail := "route " + dst.String() + " " + action
+		if rt.Dst_len == 0 {
+			detail = "default route " + action
+		}
+		if gw != "" {
+			detail += " via " + gw
+		}
+		return LocalEvent{Kind: LocalEventRoute, Detail: detail, Prefix: dst.String()}, true
+	}
+	return LocalEvent{}, false
+}
+
+func trimNUL(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
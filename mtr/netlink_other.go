@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package mtr
+
+import (
+	"context"
+	"errors"
+)
+
+// WatchLocalEvents is only implemented on Linux.
+func WatchLocalEvents(ctx context.Context, l *EventLog) error {
+	return errors.New("local event monitoring requires Linux netlink")
+}
@@ -0,0 +1,112 @@
+package mtr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Note is an operator annotation on a destination (Hop 0) or on one of the
+// hops towards it, e.g. "provider rate limits ICMP".
+type Note struct {
+	Dst  string `json:"dst"`
+	Hop  int    `json:"hop,omitempty"`
+	Text string `json:"text"`
+}
+
+// NoteBook keeps operator notes by destination and hop. Destinations match
+// a report's Dst or DstName. It is safe for concurrent use.
+type NoteBook struct {
+	mu    sync.RWMutex
+	notes map[Note]string
+}
+
+// NewNoteBook returns an empty NoteBook.
+func NewNoteBook() *NoteBook {
+	return &NoteBook{notes: map[Note]string{}}
+}
+
+func noteKey(dst string, hop int) Note {
+	return Note{Dst: dst, Hop: hop}
+}
+
+// Set attaches text to dst, or to its hop if hop is not 0. An empty text
+// removes the note.
+func (nb *NoteBook) Set(dst string, hop int, text string) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	if text == "" {
+		delete(nb.notes, noteKey(dst, hop))
+		return
+	}
+	nb.notes[noteKey(dst, hop)] = text
+}
+
+// Get returns the note on dst, or on its hop if hop is not 0.
+func (nb *NoteBook) Get(dst string, hop int) string {
+	nb.mu.RLock()
+	defer nb.mu.RUnlock()
+	return nb.notes[noteKey(dst, hop)]
+}
+
+// Annotate copies the notes matching r into r.Note and its hups' Note.
+func (nb *NoteBook) Annotate(r *MTRReport) {
+	get := func(hop int) string {
+		if n := nb.Get(r.Dst, hop); n != "" {
+			return n
+		}
+		if r.DstName != "" {
+			return nb.Get(r.DstName, hop)
+		}
+		return ""
+	}
+	r.Note = get(0)
+	for i := range r.Hups {
+		r.Hups[i].Note = get(r.Hups[i].Count)
+	}
+}
+
+// Notes returns all notes sorted by destination and hop.
+func (nb *NoteBook) Notes() []Note {
+	nb.mu.RLock()
+	notes := make([]Note, 0, len(nb.notes))
+	for k, text := range nb.notes {
+		k.Text = text
+		notes = append(notes, k)
+	}
+	nb.mu.RUnlock()
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].Dst != notes[j].Dst {
+			return notes[i].Dst < notes[j].Dst
+		}
+		return notes[i].Hop < notes[j].Hop
+	})
+	return notes
+}
+
+// Save persists the notes as JSON at path.
+func (nb *NoteBook) Save(path string) error {
+	b, err := json.Marshal(nb.Notes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// LoadNoteBook reads notes written by Save.
+func LoadNoteBook(path string) (*NoteBook, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var notes []Note
+	if err := json.Unmarshal(b, &notes); err != nil {
+		return nil, err
+	}
+	nb := NewNoteBook()
+	for _, n := range notes {
+		nb.Set(n.Dst, n.Hop, n.Text)
+	}
+	return nb, nil
+}
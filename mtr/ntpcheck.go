@@ -0,0 +1,120 @@
+package mtr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NTPProbe sends an SNTP query to the destination of every run, for targets
+// that are time servers, recording the clock offset, the round-trip delay
+// and the server's stratum next to the path towards it.
+type NTPProbe struct {
+	// Port is the server port, 123 if zero.
+	Port int `json:"port,omitempty"`
+	// Timeout bounds the query, 2s if zero.
+	Timeout time.Duration `json:"-"`
+}
+
+// NTPResult is the outcome of an NTPProbe query. Offset is how far the
+// server's clock is ahead of the local one and Delay the round trip without
+// the server's processing time, both in milliseconds. OK is set when a
+// synchronized server answered.
+type NTPResult struct {
+	Server  string  `json:"server"`
+	Offset  float64 `json:"offset"`
+	Delay   float64 `json:"delay"`
+	Stratum int     `json:"stratum"`
+	RefID   string  `json:"ref_id,omitempty"`
+	OK      bool    `json:"ok"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// ntpEpoch is the NTP era 0 epoch, 1900-01-01, in Unix seconds.
+const ntpEpoch = -2208988800
+
+func ntpTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b))
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(sec+ntpEpoch, frac*1e9>>32)
+}
+
+// Check queries the time server at server.
+func (p NTPProbe) Check(ctx context.Context, server string) NTPResult {
+	res := NTPResult{Server: server}
+	if err := p.query(ctx, server, &res); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.OK = res.Error == ""
+	return res
+}
+
+func (p NTPProbe) query(ctx context.Context, server string, res *NTPResult) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	port := p.Port
+	if port == 0 {
+		port = 123
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(server, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Version 4, client mode. The transmit timestamp is random rather than
+	// the clock so the reply's origin timestamp can't be guessed; local
+	// times are measured separately.
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	rand.Read(req[40:48])
+	t1 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	buf := make([]byte, 128)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		t4 := time.Now()
+		if n < 48 || buf[0]&7 != 4 || string(buf[24:32]) != string(req[40:48]) {
+			// Not the answer to our query, keep waiting.
+			continue
+		}
+		res.Stratum = int(buf[1])
+		if res.Stratum == 0 {
+			// Kiss-o'-death: the reference id is an ASCII code like RATE.
+			return fmt.Errorf("server sent kiss code %q", string(buf[12:16]))
+		}
+		if res.Stratum == 1 {
+			res.RefID = strings.TrimRight(string(buf[12:16]), "\x00")
+		} else {
+			res.RefID = net.IP(buf[12:16]).String()
+		}
+		t2, t3 := ntpTime(buf[32:40]), ntpTime(buf[40:48])
+		offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+		delay := t4.Sub(t1) - t3.Sub(t2)
+		res.Offset = float64(offset) / float64(time.Millisecond)
+		res.Delay = float64(delay) / float64(time.Millisecond)
+		if buf[0]>>6 == 3 {
+			res.Error = "server clock is unsynchronized"
+		}
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package mtr
+
+import "net"
+
+// Open creates the sockets of the backends op will use up front instead of
+// on first probe, so that privileges can be dropped afterwards. Sockets of
+// the other address family are opened on a best-effort basis. Missing
+// privileges are reported as ErrPermission.
+func (op *OPMTR) Open() error {
+	v4 := op.Tracer.Addr.IP.To4() != nil
+	var err4, err6 error
+	if op.ProbeMode != ProbeUDP && op.ProbeMode != ProbeTCP && op.datagram() {
+		for _, s := range []*dgramSocket{op.dgram.v4, op.dgram.v6} {
+			s.once.Do(s.open)
+		}
+		if v4 {
+			return runError(op.dgram.v4.err)
+		}
+		return runError(op.dgram.v6.err)
+	}
+	if v4 {
+		sess, err := op.Tracer.NewSession(net.IPv4zero)
+		if err == nil {
+			sess.Close()
+		}
+		op.icmp4.once.Do(op.icmp4.init)
+		err4 = err
+		if err4 == nil {
+			err4 = op.icmp4.err
+		}
+	}
+	op.icmp6.once.Do(op.icmp6.init)
+	err6 = op.icmp6.err
+	var listen func(v4 bool) error
+	switch op.ProbeMode {
+	case ProbeUDP:
+		listen = op.udp.errs.listen
+	case ProbeTCP:
+		listen = op.tcp.listen
+	}
+	if listen != nil {
+		if err := listen(true); v4 && err4 == nil {
+			err4 = err
+		}
+		if err := listen(false); !v4 && err6 == nil {
+			err6 = err
+		}
+	}
+	if v4 {
+		return runError(err4)
+	}
+	return runError(err6)
+}
@@ -0,0 +1,46 @@
+package mtr
+
+import "time"
+
+// Option configures an OPMTR built by NewOPMTR.
+type Option func(*options)
+
+type options struct {
+	maxHops     int
+	count       int
+	maxUnknowns int
+	timeout     time.Duration
+	delay       time.Duration
+	networks    []string
+}
+
+// WithMaxHops sets the largest TTL traced.
+func WithMaxHops(n int) Option {
+	return func(o *options) { o.maxHops = n }
+}
+
+// WithPingCount sets the number of pings sent to every hop.
+func WithPingCount(n int) Option {
+	return func(o *options) { o.count = n }
+}
+
+// WithMaxUnknowns sets how many unanswered hops in a row end a trace.
+func WithMaxUnknowns(n int) Option {
+	return func(o *options) { o.maxUnknowns = n }
+}
+
+// WithTimeout sets how long to wait for each probe's reply.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithDelay sets the pause between trace probes.
+func WithDelay(d time.Duration) Option {
+	return func(o *options) { o.delay = d }
+}
+
+// WithNetworks overrides the tracer's networks, which default to "ip4:icmp"
+// or "ip6:ipv6-icmp" depending on the source address family.
+func WithNetworks(networks ...string) Option {
+	return func(o *options) { o.networks = networks }
+}
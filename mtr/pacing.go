@@ -0,0 +1,105 @@
+package mtr
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the probes of every run, hop and
+// OPMTR sharing it, so concurrent ping workers don't burst into routers'
+// ICMP rate limits. Set it as OPMTR.RateLimit.
+type RateLimiter struct {
+	mu    sync.Mutex
+	rate  float64
+	burst float64
+	// tokens is the number of probes that may leave as of refilled. It goes
+	// negative by the probes waiting for theirs.
+	tokens   float64
+	refilled time.Time
+}
+
+// NewRateLimiter returns a RateLimiter letting rate probes per second
+// through, with bursts of up to burst probes, at least 1.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// wait takes a token, blocking on clk until it is due. It returns
+// ctx.Err(), handing the token back, if ctx is done first.
+func (l *RateLimiter) wait(ctx context.Context, clk Clock) error {
+	if l == nil || l.rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := clk.Now()
+	if !l.refilled.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.refilled).Seconds()*l.rate)
+	}
+	l.refilled = now
+	l.tokens--
+	due := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if due <= 0 {
+		return nil
+	}
+	metrics.Add("pings_rate_limited", 1)
+	if err := sleep(ctx, clk, due); err != nil {
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// hopPacer spaces the pings of one hop by OPMTR.Interval and delays each by
+// up to ProbeJitter. It is not safe for concurrent use.
+type hopPacer struct {
+	interval time.Duration
+	jitter   time.Duration
+	rnd      *rand.Rand
+	// next is when the next ping may leave, zero before the first
+	next time.Time
+}
+
+// pacer returns the pacer of the pings of hop in a run with sched, or nil
+// if they aren't paced.
+func (op *OPMTR) pacer(sched *schedule, hop int) *hopPacer {
+	p := &hopPacer{interval: op.Interval}
+	if p.rnd = sched.hop(hop); p.rnd != nil {
+		p.jitter = sched.jitter
+	}
+	if p.interval <= 0 && p.rnd == nil {
+		return nil
+	}
+	return p
+}
+
+// wait sleeps on clk until the next ping of the hop is due.
+func (p *hopPacer) wait(ctx context.Context, clk Clock) error {
+	if p == nil {
+		return nil
+	}
+	var d time.Duration
+	if !p.next.IsZero() {
+		d = p.next.Sub(clk.Now())
+	}
+	if p.rnd != nil {
+		d += time.Duration(p.rnd.Int63n(int64(p.jitter) + 1))
+	}
+	if d > 0 {
+		if err := sleep(ctx, clk, d); err != nil {
+			return err
+		}
+	}
+	if p.interval > 0 {
+		p.next = clk.Now().Add(p.interval)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package mtr_test
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/SgtDaJim/op-mtr/mtr/mtrtest"
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// probeTimes returns a ProbeFunc answering from a two hop path that
+// records the time of every probe on clk.
+func probeTimes(clk *mtrtest.Clock, mu *sync.Mutex, times *[]time.Time) mtr.ProbeFunc {
+	return func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+		mu.Lock()
+		*times = append(*times, clk.Now())
+		mu.Unlock()
+		if ttl > 2 {
+			ttl = 2
+		}
+		return &traceroute.Reply{IP: net.IPv4(10, 0, 0, byte(ttl)), RTT: time.Millisecond, Hops: ttl}, nil
+	}
+}
+
+// drive advances clk in steps of step whenever something waits on it,
+// until done is closed.
+func drive(clk *mtrtest.Clock, step time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if clk.Timers() > 0 {
+			clk.Advance(step)
+		} else {
+			time.Sleep(50 * time.Microsecond)
+		}
+	}
+}
+
+func TestRateLimiterSpacesProbes(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := mtrtest.NewClock(start)
+	op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(5), mtr.WithMaxHops(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer op.Close()
+	var mu sync.Mutex
+	var times []time.Time
+	op.Clock, op.Probe = clk, probeTimes(clk, &mu, &times)
+	const rate, burst = 10, 3
+	op.RateLimit = mtr.NewRateLimiter(rate, burst)
+
+	done := make(chan struct{})
+	go drive(clk, 10*time.Millisecond, done)
+	_, err = op.RunContext(context.Background(), "10.0.0.2")
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// 2 trace probes and 4 more pings for each of the 2 hops
+	if len(times) != 10 {
+		t.Fatalf("%d probes, want 10", len(times))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, at := range times {
+		// the bucket starts full, then refills one token per 1/rate s
+		earliest := start
+		if n := i + 1 - burst; n > 0 {
+			earliest = start.Add(time.Duration(n) * time.Second / rate)
+		}
+		if at.Before(earliest) {
+			t.Errorf("probe %d left at +%v, before +%v", i+1, at.Sub(start), earliest.Sub(start))
+		}
+	}
+	// and the limiter doesn't hold probes back much longer than needed
+	if last := times[len(times)-1].Sub(start); last > 800*time.Millisecond {
+		t.Errorf("last probe left at +%v, want about +700ms", last)
+	}
+}
+
+func TestRateLimiterRefundsCancelledWaits(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := mtrtest.NewClock(start)
+	limit := mtr.NewRateLimiter(1, 1)
+	newOP := func(times *[]time.Time, mu *sync.Mutex) *mtr.OPMTR {
+		op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(2), mtr.WithMaxHops(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		op.Clock, op.Probe, op.RateLimit = clk, probeTimes(clk, mu, times), limit
+		return op
+	}
+	var mu sync.Mutex
+	var first, second []time.Time
+
+	// the first run takes the only token, then waits for the next one
+	// until cancelled
+	op := newOP(&first, &mu)
+	defer op.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan struct{})
+	go func() {
+		op.RunContext(ctx, "10.0.0.2")
+		close(ran)
+	}()
+	for clk.Timers() == 0 {
+		time.Sleep(50 * time.Microsecond)
+	}
+	cancel()
+	<-ran
+
+	// the cancelled wait gave its token back: the next probe waits 1s for
+	// a refill, not 2s
+	op2 := newOP(&second, &mu)
+	defer op2.Close()
+	done := make(chan struct{})
+	go drive(clk, 100*time.Millisecond, done)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go func() {
+		op2.RunContext(ctx2, "10.0.0.2")
+	}()
+	for {
+		mu.Lock()
+		n := len(second)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+	close(done)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(first) != 1 {
+		t.Errorf("cancelled run sent %d probes, want 1", len(first))
+	}
+	if got := second[0].Sub(start); got != time.Second {
+		t.Errorf("next probe left at +%v, want +1s", got)
+	}
+}
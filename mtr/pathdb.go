@@ -0,0 +1,220 @@
+package mtr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PathRecord is the last known path between a source and a destination.
+type PathRecord struct {
+	Src     string   `json:"src"`
+	Dst     string   `json:"dst"`
+	Hops    []string `json:"hops"`
+	Updated int64    `json:"updated"`
+	Changed int64    `json:"changed"`
+	Changes int      `json:"changes"`
+	// Trigger is the BGP event likely behind the last change, if known.
+	Trigger *BGPEvent `json:"trigger,omitempty"`
+	// Seen tracks when each hop address was first and last observed.
+	Seen []HopSeen `json:"seen,omitempty"`
+	// Runs are the most recent runs, oldest first, for Stability.
+	Runs []PathRun `json:"runs,omitempty"`
+}
+
+// PathRun is the path taken by one run. Runs over the same path share Path.
+type PathRun struct {
+	Time int64  `json:"time"`
+	Path string `json:"path"`
+}
+
+// maxPathRuns bounds PathRecord.Runs.
+const maxPathRuns = 1000
+
+// addRun appends a run over hops at ts. A run that samePath considers
+// unchanged reuses the previous run's Path so unknown hops don't split it.
+func (rec *PathRecord) addRun(hops []string, ts int64, changed bool) {
+	path := strings.Join(hops, " ")
+	if n := len(rec.Runs); n > 0 && !changed {
+		path = rec.Runs[n-1].Path
+	}
+	rec.Runs = append(rec.Runs, PathRun{Time: ts, Path: path})
+	if len(rec.Runs) > maxPathRuns {
+		rec.Runs = append([]PathRun(nil), rec.Runs[len(rec.Runs)-maxPathRuns:]...)
+	}
+}
+
+// HopSeen is when a hop address was first and last observed on a path.
+type HopSeen struct {
+	Host      string `json:"host"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// see updates Seen with the addresses of r observed at ts.
+func (rec *PathRecord) see(r MTRReport, ts int64) {
+	idx := map[string]int{}
+	for i, s := range rec.Seen {
+		idx[s.Host] = i
+	}
+	for _, h := range r.Hups {
+		hosts := []string{h.Host}
+		for _, hh := range h.Hosts {
+			hosts = append(hosts, hh.Host)
+		}
+		for _, host := range hosts {
+			if host == "???" {
+				continue
+			}
+			if i, ok := idx[host]; ok {
+				rec.Seen[i].LastSeen = ts
+				continue
+			}
+			idx[host] = len(rec.Seen)
+			rec.Seen = append(rec.Seen, HopSeen{Host: host, FirstSeen: ts, LastSeen: ts})
+		}
+	}
+}
+
+func (rec PathRecord) copy() PathRecord {
+	rec.Hops = append([]string(nil), rec.Hops...)
+	rec.Seen = append([]HopSeen(nil), rec.Seen...)
+	rec.Runs = append([]PathRun(nil), rec.Runs...)
+	return rec
+}
+
+// PathDB is a path cache keyed by src/dst that can be shared by several
+// OPMTRs. It is safe for concurrent use.
+type PathDB struct {
+	mu    sync.RWMutex
+	paths map[string]*PathRecord
+}
+
+// NewPathDB returns an empty PathDB.
+func NewPathDB() *PathDB {
+	return &PathDB{paths: map[string]*PathRecord{}}
+}
+
+func pathKey(src, dst string) string {
+	return src + " " + dst
+}
+
+// Update records the path of r and reports whether it differs from the
+// previously known one. Unknown hops ("???") never count as a change.
+func (db *PathDB) Update(r MTRReport) bool {
+	var hops []string
+	for _, h := range r.Hups {
+		hops = append(hops, h.Host)
+	}
+	ts := r.Time
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	k := pathKey(r.Src, r.Dst)
+	rec, ok := db.paths[k]
+	if !ok {
+		rec = &PathRecord{Src: r.Src, Dst: r.Dst, Hops: hops, Updated: ts, Changed: ts}
+		rec.see(r, ts)
+		rec.addRun(hops, ts, false)
+		db.paths[k] = rec
+		return false
+	}
+	rec.see(r, ts)
+	changed := !samePath(rec.Hops, hops)
+	rec.addRun(hops, ts, changed)
+	rec.Hops = hops
+	rec.Updated = ts
+	if changed {
+		rec.Changed = ts
+		rec.Changes++
+		rec.Trigger = nil
+	}
+	return changed
+}
+
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && a[i] != "???" && b[i] != "???" {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the current path from src to dst.
+func (db *PathDB) Get(src, dst string) (PathRecord, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rec, ok := db.paths[pathKey(src, dst)]
+	if !ok {
+		return PathRecord{}, false
+	}
+	return rec.copy(), true
+}
+
+// HopHistory returns when each hop address on the path from src to dst was
+// first and last seen, the newest arrivals last.
+func (db *PathDB) HopHistory(src, dst string) []HopSeen {
+	rec, ok := db.Get(src, dst)
+	if !ok {
+		return nil
+	}
+	sort.SliceStable(rec.Seen, func(i, j int) bool { return rec.Seen[i].FirstSeen < rec.Seen[j].FirstSeen })
+	return rec.Seen
+}
+
+// Fresh reports whether the path from src to dst was updated within maxAge,
+// in which case a new trace is redundant.
+func (db *PathDB) Fresh(src, dst string, maxAge time.Duration) bool {
+	rec, ok := db.Get(src, dst)
+	return ok && time.Since(time.Unix(rec.Updated, 0)) < maxAge
+}
+
+// Records returns all known paths sorted by src and dst.
+func (db *PathDB) Records() []PathRecord {
+	db.mu.RLock()
+	recs := make([]PathRecord, 0, len(db.paths))
+	for _, rec := range db.paths {
+		recs = append(recs, rec.copy())
+	}
+	db.mu.RUnlock()
+	sort.Slice(recs, func(i, j int) bool {
+		return pathKey(recs[i].Src, recs[i].Dst) < pathKey(recs[j].Src, recs[j].Dst)
+	})
+	return recs
+}
+
+// Save persists the database as JSON at path.
+func (db *PathDB) Save(path string) error {
+	b, err := json.Marshal(db.Records())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// LoadPathDB reads a database written by Save.
+func LoadPathDB(path string) (*PathDB, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recs []PathRecord
+	if err := json.Unmarshal(b, &recs); err != nil {
+		return nil, err
+	}
+	db := NewPathDB()
+	for i := range recs {
+		db.paths[pathKey(recs[i].Src, recs[i].Dst)] = &recs[i]
+	}
+	return db, nil
+}
@@ -0,0 +1,83 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// MaxPayloadSize is the largest OPMTR.PayloadSize, the most a UDP datagram
+// over IPv4 can carry.
+const MaxPayloadSize = 65507
+
+// payloadKey is the context key of the payload of a run.
+type payloadKey struct{}
+
+// withPayload checks PayloadSize and returns a copy of ctx carrying the
+// payload, so a run builds it once rather than per probe.
+func (op *OPMTR) withPayload(ctx context.Context) (context.Context, error) {
+	if op.PayloadSize < 0 || op.PayloadSize > MaxPayloadSize {
+		return ctx, fmt.Errorf("PayloadSize %d outside 0 to %d", op.PayloadSize, MaxPayloadSize)
+	}
+	return context.WithValue(ctx, payloadKey{}, op.payload()), nil
+}
+
+// runPayload returns the payload of the run of ctx, building it for probes
+// outside a run.
+func (op *OPMTR) runPayload(ctx context.Context) []byte {
+	if b, ok := ctx.Value(payloadKey{}).([]byte); ok {
+		return b
+	}
+	return op.payload()
+}
+
+// payload returns the probe payload set by PayloadSize and PayloadPattern,
+// or nil for the backends' default.
+func (op *OPMTR) payload() []byte {
+	if op.PayloadSize <= 0 {
+		return nil
+	}
+	b := make([]byte, op.PayloadSize)
+	if len(op.PayloadPattern) > 0 {
+		for i := range b {
+			b[i] = op.PayloadPattern[i%len(op.PayloadPattern)]
+		}
+	}
+	return b
+}
+
+// packetSize returns the size of the IP packets probing dst, headers
+// included, if PayloadSize is set, or 0.
+func (op *OPMTR) packetSize(dst net.IP) int {
+	if op.PayloadSize <= 0 {
+		return 0
+	}
+	size := op.PayloadSize
+	switch op.ProbeMode {
+	case ProbeTCP:
+		size += 20
+	default:
+		// ICMP echo and UDP headers are both 8 bytes
+		size += 8
+		if op.ProbeMode != ProbeUDP && op.Flow == FlowParis && op.PayloadSize < 2 {
+			size = 8 + 2
+		}
+	}
+	if dst.To4() != nil {
+		return size + 20
+	}
+	return size + 40
+}
+
+// echoPayload returns the payload of echo request seq: payload, or the
+// default if nil, led by the checksum-cancelling bytes with paris.
+func echoPayload(payload []byte, seq int, paris bool) []byte {
+	if !paris {
+		return payload
+	}
+	p := parisPayload(seq)
+	if len(payload) > len(p) {
+		p = append(p, payload[len(p):]...)
+	}
+	return p
+}
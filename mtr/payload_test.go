@@ -0,0 +1,51 @@
+package mtr
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+func TestPayloadSizeChecked(t *testing.T) {
+	for _, size := range []int{-1, MaxPayloadSize + 1} {
+		op, err := NewOPMTR("192.0.2.1", WithMaxHops(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		op.PayloadSize = size
+		op.Probe = func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+			t.Errorf("PayloadSize %d: probed", size)
+			return nil, nil
+		}
+		if _, err := op.RunContext(context.Background(), "10.0.0.1"); err == nil {
+			t.Errorf("PayloadSize %d: RunContext succeeded", size)
+		}
+		op.Close()
+	}
+}
+
+func TestPayloadOncePerRun(t *testing.T) {
+	op := &OPMTR{PayloadSize: 5, PayloadPattern: []byte{0xab, 0xcd}}
+	ctx, err := op.withPayload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := op.runPayload(ctx), op.runPayload(ctx)
+	if want := []byte{0xab, 0xcd, 0xab, 0xcd, 0xab}; !bytes.Equal(a, want) {
+		t.Errorf("payload %x, want %x", a, want)
+	}
+	if &a[0] != &b[0] {
+		t.Error("payload built again within a run")
+	}
+	if c := op.runPayload(context.Background()); !bytes.Equal(c, a) {
+		t.Errorf("payload outside a run %x, want %x", c, a)
+	}
+
+	op = &OPMTR{}
+	if ctx, err = op.withPayload(context.Background()); err != nil || op.runPayload(ctx) != nil {
+		t.Errorf("default payload %x, %v, want nil", op.runPayload(ctx), err)
+	}
+}
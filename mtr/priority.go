@@ -0,0 +1,105 @@
+package mtr
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority ranks runs sharing an OPMTR. While a run is in flight, the pings
+// of lower priority runs wait, so an operator's ad-hoc run during an
+// incident gets the sockets and probe budget before routine monitoring.
+type Priority int
+
+const (
+	// PriorityBackground is for routine workload such as Scheduler runs.
+	PriorityBackground Priority = iota - 1
+	// PriorityNormal is the priority of runs whose context carries none.
+	PriorityNormal
+	// PriorityInteractive is for runs someone is waiting on.
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx whose runs have priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority of runs with ctx, PriorityNormal if
+// unset.
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// priorityGate tracks the runs in flight by priority and holds back pings
+// of lower priority ones. It is shared by the copies of an OPMTR.
+type priorityGate struct {
+	mu     sync.Mutex
+	active map[Priority]int
+	// changed is closed and replaced whenever a run ends.
+	changed chan struct{}
+}
+
+func newPriorityGate() *priorityGate {
+	return &priorityGate{active: map[Priority]int{}, changed: make(chan struct{})}
+}
+
+// enter registers a run at priority p and returns the func ending it.
+func (g *priorityGate) enter(p Priority) func() {
+	if g == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	g.active[p]++
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.active[p]--; g.active[p] == 0 {
+			delete(g.active, p)
+		}
+		close(g.changed)
+		g.changed = make(chan struct{})
+	}
+}
+
+// preempted reports whether a run at priority p must wait, and the channel
+// closed on the next change if so.
+func (g *priorityGate) preempted(p Priority) (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for q := range g.active {
+		if q > p {
+			return true, g.changed
+		}
+	}
+	return false, nil
+}
+
+// wait blocks while a run of higher priority than p is in flight. It
+// returns ctx.Err() if ctx is done first.
+func (g *priorityGate) wait(ctx context.Context, p Priority) error {
+	if g == nil {
+		return nil
+	}
+	counted := false
+	for {
+		held, changed := g.preempted(p)
+		if !held {
+			return nil
+		}
+		if !counted {
+			metrics.Add("pings_preempted", 1)
+			counted = true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mtr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+)
+
+// ErrPermission is wrapped by errors caused by missing socket privileges.
+var ErrPermission = errors.New("insufficient privileges for ICMP probing")
+
+// Probing backends.
+const (
+	BackendRaw      = "raw"      // raw ICMP sockets, needs CAP_NET_RAW
+	BackendDatagram = "datagram" // ICMP datagram ("ping") sockets
+)
+
+// DetectBackend checks which probing backend this process may use and
+// returns the best one: raw sockets, else ICMP datagram sockets where they
+// can trace. When none is usable it returns a single error naming the
+// capability or sysctl that is missing.
+func DetectBackend() (string, error) {
+	conn, err := net.ListenIP("ip4:icmp", &net.IPAddr{IP: net.IPv4zero})
+	if err == nil {
+		conn.Close()
+		return BackendRaw, nil
+	}
+	if !isPermission(err) {
+		return "", err
+	}
+
+	hint := "op-mtr needs raw ICMP sockets: run it as root, grant the binary " +
+		"CAP_NET_RAW (setcap cap_net_raw+ep " + os.Args[0] + "), or start the " +
+		"container with --cap-add=NET_RAW"
+	if pc, err := icmp.ListenPacket("udp4", "0.0.0.0"); err == nil {
+		pc.Close()
+		if datagramTraces {
+			return BackendDatagram, nil
+		}
+		hint += "; ICMP datagram sockets are available but do not support TTL-limited traces on " + runtime.GOOS
+	} else {
+		hint += fmt.Sprintf("; ICMP datagram sockets are disabled too, allow them for group %d "+
+			"with sysctl -w net.ipv4.ping_group_range=\"%d %d\"", os.Getgid(), os.Getgid(), os.Getgid())
+	}
+	return "", fmt.Errorf("%w: %s", ErrPermission, hint)
+}
+
+func isPermission(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || os.IsPermission(err)
+}
@@ -0,0 +1,135 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// Prober is a probing backend: the built-in ICMP, UDP and TCP ones, or one
+// set as OPMTR.Prober, such as a fake network in tests or a remote agent.
+// Statistics, retries and reports are the same whichever backend probes.
+type Prober interface {
+	// Trace finds the hops towards dst, calling add with every reply, its
+	// Hops set to the TTL it answered. It stops at dst, after
+	// cfg.MaxUnknowns silent TTLs in a row, at cfg.MaxHops, or when ctx is
+	// done. Backends that can only Probe implement it with StepTrace.
+	Trace(ctx context.Context, dst net.IP, cfg TraceConfig, add func(*traceroute.Reply)) error
+	// Probe sends one probe as described for ProbeFunc.
+	Probe(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error)
+}
+
+// TraceConfig bounds a Prober's Trace.
+type TraceConfig struct {
+	MaxHops     int
+	MaxUnknowns int
+	// Timeout is how long to wait for each reply.
+	Timeout time.Duration
+}
+
+// StepTrace traces dst by probing the TTLs one by one with probe. Failed
+// probes count as silent TTLs, except for permission errors, which end the
+// trace. A Destination Unreachable answer also ends it, returning its
+// *UnreachableError.
+func StepTrace(ctx context.Context, probe ProbeFunc, dst net.IP, cfg TraceConfig, add func(*traceroute.Reply)) error {
+	var misses int
+	for ttl := 1; ttl <= cfg.MaxHops && misses < cfg.MaxUnknowns; ttl++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r, err := probe(ctx, dst.String(), ttl, cfg.Timeout)
+		if isPermission(err) {
+			return err
+		}
+		var unreach *UnreachableError
+		if errors.As(err, &unreach) && r != nil {
+			add(&traceroute.Reply{IP: r.IP, RTT: r.RTT, Hops: ttl})
+			unreach.TTL = ttl
+			return unreach
+		}
+		if err != nil || r == nil {
+			misses++
+			continue
+		}
+		misses = 0
+		add(&traceroute.Reply{IP: r.IP, RTT: r.RTT, Hops: ttl})
+		if r.IP.Equal(dst) {
+			break
+		}
+	}
+	return nil
+}
+
+// stepProber is a built-in backend that traces with its own probes, sent
+// through op.ping so they are counted, gated and fault-injected like pings.
+type stepProber struct {
+	op    *OPMTR
+	probe ProbeFunc
+}
+
+func (p stepProber) Probe(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+	return p.probe(ctx, ip, ttl, timeout)
+}
+
+func (p stepProber) Trace(ctx context.Context, dst net.IP, cfg TraceConfig, add func(*traceroute.Reply)) error {
+	return StepTrace(ctx, func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+		r, err := p.op.ping(ctx, ip, ttl, timeout)
+		if !answered(r, err) && err != nil && ctx.Err() == nil {
+			logTo(p.op.Logger, LevelWarn, "trace probe failed", "dst", ip, "ttl", ttl, "err", err)
+		}
+		return r, err
+	}, dst, cfg, add)
+}
+
+// tracerProber is the default ICMPv4 backend: batch traces with the Tracer,
+// probes over the shared socket.
+type tracerProber struct {
+	op    *OPMTR
+	paris bool
+}
+
+func (p tracerProber) Probe(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+	return p.op.icmp4.probe(ctx, ip, ttl, timeout, p.paris, nil)
+}
+
+// Trace ignores cfg, which the Tracer was configured with. The Tracer
+// can't tell Destination Unreachable from other answers. If the trace stops
+// short of dst at a router that answered several probes, as routers
+// rejecting packets do, the TTL where it first answered is probed again to
+// find out, a few times and slowly as routers rate-limit these messages.
+func (p tracerProber) Trace(ctx context.Context, dst net.IP, cfg TraceConfig, add func(*traceroute.Reply)) error {
+	var last *traceroute.Reply
+	first := map[string]int{}
+	answers := map[string]int{}
+	err := p.op.Tracer.Trace(ctx, dst, func(r *traceroute.Reply) {
+		if ttl, ok := first[r.IP.String()]; !ok || r.Hops < ttl {
+			first[r.IP.String()] = r.Hops
+		}
+		answers[r.IP.String()]++
+		if last == nil || r.Hops > last.Hops {
+			last = r
+		}
+		add(r)
+	})
+	if err != nil || last == nil || last.IP.Equal(dst) || answers[last.IP.String()] < 2 {
+		return err
+	}
+	ttl := first[last.IP.String()]
+	for i := 0; i < 3; i++ {
+		if err := sleep(ctx, p.op.clock(), time.Second); err != nil {
+			return err
+		}
+		r, err := p.op.ping(ctx, dst.String(), ttl, p.op.Tracer.Timeout)
+		var unreach *UnreachableError
+		if errors.As(err, &unreach) && r != nil {
+			return unreach
+		}
+		if r != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package mtr_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// agentProber is a Prober tracing in one go, like a remote agent would: its
+// replies arrive out of order and with a second router answering at TTL 2.
+type agentProber struct {
+	traceErr error
+
+	mu     sync.Mutex
+	cfg    mtr.TraceConfig
+	probed map[string]int
+}
+
+func (p *agentProber) Trace(ctx context.Context, dst net.IP, cfg mtr.TraceConfig, add func(*traceroute.Reply)) error {
+	p.mu.Lock()
+	p.cfg = cfg
+	p.mu.Unlock()
+	if p.traceErr != nil {
+		return p.traceErr
+	}
+	for _, r := range []struct {
+		ip  string
+		ttl int
+	}{{"10.0.0.3", 3}, {"10.0.0.1", 1}, {"10.0.0.2", 2}, {"10.0.0.22", 2}} {
+		add(&traceroute.Reply{IP: net.ParseIP(r.ip), RTT: time.Duration(r.ttl) * time.Millisecond, Hops: r.ttl})
+	}
+	return nil
+}
+
+func (p *agentProber) Probe(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+	p.mu.Lock()
+	if p.probed == nil {
+		p.probed = map[string]int{}
+	}
+	p.probed[ip]++
+	p.mu.Unlock()
+	return &traceroute.Reply{IP: net.ParseIP(ip), RTT: time.Millisecond, Hops: ttl}, nil
+}
+
+func newAgentOPMTR(t *testing.T, p *agentProber) *mtr.OPMTR {
+	t.Helper()
+	op, err := mtr.NewOPMTR("192.0.2.1", mtr.WithPingCount(4), mtr.WithMaxHops(5), mtr.WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(op.Close)
+	op.Prober = p
+	op.Probe = func(ctx context.Context, ip string, ttl int, timeout time.Duration) (*traceroute.Reply, error) {
+		t.Error("Probe used although Prober is set")
+		return nil, nil
+	}
+	return op
+}
+
+func TestProberReplacesBackend(t *testing.T) {
+	p := &agentProber{}
+	op := newAgentOPMTR(t, p)
+	r, err := op.RunContext(context.Background(), "10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mtr.TraceConfig{MaxHops: 5, MaxUnknowns: op.MaxUnknowns, Timeout: 50 * time.Millisecond}
+	if p.cfg != want {
+		t.Errorf("Trace got %+v, want %+v", p.cfg, want)
+	}
+	if len(r.Hups) != 3 {
+		t.Fatalf("%d hups, want 3", len(r.Hups))
+	}
+	for i, h := range r.Hups {
+		if h.Count != i+1 || h.Snt != 4 || h.Loss != 0 {
+			t.Errorf("hup %d: Count %d, Snt %v, Loss %v", i, h.Count, h.Snt, h.Loss)
+		}
+		if n := p.probed[h.Host]; n != 3 {
+			t.Errorf("%s pinged %d times, want 3", h.Host, n)
+		}
+	}
+	if hosts := r.Hups[1].Hosts; len(hosts) != 2 || hosts[1].Host != "10.0.0.22" {
+		t.Errorf("hop 2 Hosts %+v, want 10.0.0.2 and 10.0.0.22", hosts)
+	}
+}
+
+func TestProberTraceError(t *testing.T) {
+	gone := errors.New("agent gone")
+	op := newAgentOPMTR(t, &agentProber{traceErr: gone})
+	if _, err := op.RunContext(context.Background(), "10.0.0.3"); !errors.Is(err, gone) {
+		t.Errorf("RunContext = %v, want the Trace error", err)
+	}
+}
+
+func TestProberHooks(t *testing.T) {
+	op := newAgentOPMTR(t, &agentProber{})
+	var mu sync.Mutex
+	traced := map[int]string{}
+	sent, replied := map[int]int{}, map[int]int{}
+	done := map[int]float64{}
+	op.Hooks = &mtr.Hooks{
+		OnTraceReply: func(hop int, host string, rtt time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			traced[hop] = host
+		},
+		OnPingSent: func(hop int, host string) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent[hop]++
+		},
+		OnPingReply: func(hop int, from string, rtt time.Duration, lost bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !lost {
+				replied[hop]++
+			}
+		},
+		OnHopComplete: func(h mtr.MTRHup) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[h.Count] = h.Snt
+		},
+	}
+	if _, err := op.RunContext(context.Background(), "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	// the second router at TTL 2 is no new hop
+	if len(traced) != 3 || traced[2] != "10.0.0.2" {
+		t.Errorf("OnTraceReply got %v", traced)
+	}
+	for hop := 1; hop <= 3; hop++ {
+		if sent[hop] != 3 || replied[hop] != 3 {
+			t.Errorf("hop %d: %d pings sent, %d answered, want 3", hop, sent[hop], replied[hop])
+		}
+		if done[hop] != 4 {
+			t.Errorf("hop %d completed with Snt %v, want 4", hop, done[hop])
+		}
+	}
+}
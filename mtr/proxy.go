@@ -0,0 +1,183 @@
+package mtr
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RunProxy measures TCP connection establishment to dst ("host:port")
+// through a SOCKS5 or HTTP CONNECT proxy given as "socks5://host:port" or
+// "http://host:port". The report has two hups: the proxy, timed by the TCP
+// connect to it, and dst, timed from the start of the attempt until the
+// proxy confirms the tunnel. Each is attempted PingCount times.
+func (op *OPMTR) RunProxy(proxy, dst string) (MTRReport, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return MTRReport{}, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return MTRReport{}, fmt.Errorf("Unsupported proxy scheme %q", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(dst); err != nil {
+		return MTRReport{}, err
+	}
+	metrics.Add("active_runs", 1)
+	defer metrics.Add("active_runs", -1)
+	report := MTRReport{
+		Version: ReportVersion,
+		ID:      newReportID(),
+		Src:     op.Tracer.Addr.String(),
+		Dst:     dst,
+		Count:   op.PingCount,
+		Time:    op.clock().Now().Unix(),
+	}
+	hop := &MTRHup{Count: 1, Host: u.Host}
+	end := &MTRHup{Count: 2, Host: dst}
+	for i := 0; i < op.PingCount; i++ {
+		connect, total, err := op.proxyConnect(u, dst)
+		hop.Snt++
+		end.Snt++
+		if connect > 0 {
+			recordRTT(hop, connect)
+		} else {
+			hop.LossPoint++
+		}
+		if err == nil {
+			recordRTT(end, total)
+		} else {
+			logTo(op.Logger, LevelWarn, "proxy connect failed", "proxy", u.Host, "dst", dst, "err", err)
+			end.LossPoint++
+		}
+	}
+	for _, h := range []*MTRHup{hop, end} {
+		h.Loss = float64(h.LossPoint) / h.Snt
+	}
+	op.finish(context.Background(), &report, []*MTRHup{hop, end})
+	return report, nil
+}
+
+// recordRTT adds a successful sample in milliseconds to h, whose Snt must
+// already count it.
+func recordRTT(h *MTRHup, rtt float64) {
+	received := h.Snt - float64(h.LossPoint)
+	if received <= 1 {
+		h.Best, h.Wrst = rtt, rtt
+	}
+	h.addSample(rtt)
+	h.Last = rtt
+	h.Avg = (h.Avg*(received-1) + rtt) / received
+	if h.Best > rtt {
+		h.Best = rtt
+	}
+	if h.Wrst < rtt {
+		h.Wrst = rtt
+	}
+}
+
+// proxyConnect opens one tunnel and returns the TCP connect time to the
+// proxy and the total time until the tunnel was confirmed, in milliseconds.
+func (op *OPMTR) proxyConnect(u *url.URL, dst string) (connect, total float64, err error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", u.Host, op.Tracer.Timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	connect = time.Since(start).Seconds() * 1000
+	conn.SetDeadline(start.Add(op.Tracer.Timeout))
+	if u.Scheme == "socks5" {
+		err = socks5Connect(conn, dst)
+	} else {
+		err = httpConnect(conn, dst)
+	}
+	if err != nil {
+		return connect, 0, err
+	}
+	return connect, time.Since(start).Seconds() * 1000, nil
+}
+
+func socks5Connect(conn net.Conn, dst string) error {
+	host, portStr, _ := net.SplitHostPort(dst)
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	// greeting offering "no authentication"
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	b := make([]byte, 262)
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return err
+	}
+	if b[0] != 5 || b[1] != 0 {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, b[:4]); err != nil {
+		return err
+	}
+	if b[1] != 0 {
+		return fmt.Errorf("socks5: connect failed with code %d", b[1])
+	}
+	var n int
+	switch b[3] {
+	case 1:
+		n = 4
+	case 4:
+		n = 16
+	case 3:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("socks5: bad address type %d", b[3])
+	}
+	_, err = io.ReadFull(conn, b[:n+2])
+	return err
+}
+
+func httpConnect(conn net.Conn, dst string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: dst},
+		Host:   dst,
+		Header: http.Header{},
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	// only the status line and headers matter, anything after them already
+	// belongs to the tunnel
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http connect: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package mtr
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PTRHint is what a router's PTR name reveals by provider convention, e.g.
+// "ae-1-3502.ear2.london1.level3.net" or "be2490.ccr42.jfk02.atlas.cogentco.com".
+type PTRHint struct {
+	// Interface is the interface descriptor, e.g. "ae-1-3502" or "be2490".
+	Interface string
+	// Location is the airport code or city found in the name, e.g. "JFK"
+	// or "London".
+	Location string
+}
+
+var ptrIfaceRe = regexp.MustCompile(`^(ae|be|xe|et|ge|te|gi|hu|fo|so|po|eth|irb|lag|bundle-ether|tengige|hundredgige|gigabitethernet|[0-9]+ge)-?[0-9][-0-9]*$`)
+
+// ptrDigits strips the site numbering from labels like "jfk02" or "london1".
+var ptrDigits = regexp.MustCompile(`[0-9]+$`)
+
+// ptrAirports are IATA codes of cities hosting major backbone sites.
+var ptrAirports = map[string]bool{
+	"ams": true, "arn": true, "atl": true, "bcn": true, "bkk": true, "bog": true,
+	"bom": true, "bos": true, "bru": true, "bud": true, "cdg": true, "cph": true,
+	"den": true, "dfw": true, "dub": true, "dus": true, "ewr": true, "eze": true,
+	"fra": true, "gru": true, "ham": true, "hel": true, "hkg": true, "iad": true,
+	"icn": true, "ist": true, "jfk": true, "jnb": true, "kix": true, "lax": true,
+	"lga": true, "lhr": true, "lis": true, "mad": true, "man": true, "mia": true,
+	"mil": true, "mrs": true, "mxp": true, "nrt": true, "ord": true, "osl": true,
+	"otp": true, "par": true, "pdx": true, "phx": true, "prg": true, "scl": true,
+	"sea": true, "sfo": true, "sin": true, "sjc": true, "sof": true, "syd": true,
+	"tpe": true, "tyo": true, "vie": true, "waw": true, "yul": true, "yyz": true,
+	"zrh": true,
+}
+
+// ptrCities are city names used spelled out in PTRs.
+var ptrCities = map[string]string{
+	"amsterdam": "Amsterdam", "ashburn": "Ashburn", "atlanta": "Atlanta",
+	"chicago": "Chicago", "dallas": "Dallas", "denver": "Denver",
+	"frankfurt": "Frankfurt", "hongkong": "Hong Kong", "london": "London",
+	"losangeles": "Los Angeles", "madrid": "Madrid", "miami": "Miami",
+	"milan": "Milan", "newyork": "New York", "paris": "Paris",
+	"sanjose": "San Jose", "seattle": "Seattle", "singapore": "Singapore",
+	"stockholm": "Stockholm", "sydney": "Sydney", "tokyo": "Tokyo",
+	"toronto": "Toronto", "vienna": "Vienna", "warsaw": "Warsaw",
+	"washington": "Washington", "zurich": "Zurich",
+}
+
+// ParsePTR extracts the interface and location hints from a PTR name. The
+// last two labels (the provider's domain) are never taken as a location.
+func ParsePTR(name string) PTRHint {
+	var hint PTRHint
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if len(labels) > 2 {
+		labels = labels[:len(labels)-2]
+	}
+	for i, l := range labels {
+		if i == 0 && ptrIfaceRe.MatchString(l) {
+			hint.Interface = l
+			continue
+		}
+		if hint.Location != "" {
+			continue
+		}
+		for _, part := range strings.Split(l, "-") {
+			site := ptrDigits.ReplaceAllString(part, "")
+			if ptrAirports[site] {
+				hint.Location = strings.ToUpper(site)
+				break
+			}
+			if city, ok := ptrCities[site]; ok {
+				hint.Location = city
+				break
+			}
+		}
+	}
+	return hint
+}
@@ -0,0 +1,63 @@
+package mtr
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"time"
+)
+
+// schedule is the randomized probe schedule of one run, derived from its
+// seed so the run can be reproduced by setting OPMTR.Seed.
+type schedule struct {
+	seed    int64
+	jitter  time.Duration
+	shuffle bool
+}
+
+// schedule returns the schedule of a new run, or nil if neither
+// ProbeJitter nor Shuffle is set.
+func (op *OPMTR) schedule() *schedule {
+	if op.ProbeJitter <= 0 && !op.Shuffle {
+		return nil
+	}
+	seed := op.Seed
+	for seed == 0 {
+		seed = newSeed()
+	}
+	return &schedule{seed: seed, jitter: op.ProbeJitter, shuffle: op.Shuffle}
+}
+
+// newSeed returns a random seed.
+func newSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.BigEndian.Uint64(b[:]))
+}
+
+// order returns the indexes of n hups in the order to ping them: shuffled
+// with Shuffle, by TTL otherwise.
+func (s *schedule) order(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	if s != nil && s.shuffle {
+		rand.New(rand.NewSource(s.seed)).Shuffle(n, func(i, j int) { idx[i], idx[j] = idx[j], idx[i] })
+	}
+	return idx
+}
+
+// hop returns the jitter source of the pings of hop, or nil without
+// jitter. Each hop has its own, so concurrent workers draw the same
+// delays whatever order they run in.
+func (s *schedule) hop(hop int) *rand.Rand {
+	if s == nil || s.jitter <= 0 {
+		return nil
+	}
+	// spread the hops' seeds apart, golden ratio increments as in splitmix64
+	seed := int64(uint64(s.seed) + uint64(hop)*0x9e3779b97f4a7c15)
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,128 @@
+package mtr
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPTRCacheSize is the number of addresses a PTRResolver remembers
+// when Size is zero.
+const DefaultPTRCacheSize = 1024
+
+// AddrResolver looks up the names of an address. *net.Resolver implements it.
+type AddrResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// PTRResolver fills in hup hostnames with reverse DNS lookups. Lookups run
+// concurrently and results, including failures, are kept in an LRU cache so
+// repeated runs don't re-query.
+type PTRResolver struct {
+	// Resolver does the lookups, net.DefaultResolver if nil.
+	Resolver AddrResolver
+	// Timeout bounds each lookup, 2s if zero.
+	Timeout time.Duration
+	// Size is the cache capacity, DefaultPTRCacheSize if zero.
+	Size int
+
+	mu    sync.Mutex
+	order *list.List
+	cache map[string]*list.Element
+}
+
+type ptrEntry struct {
+	addr string
+	name string
+}
+
+// NewPTRResolver returns a PTRResolver using net.DefaultResolver.
+func NewPTRResolver(timeout time.Duration, size int) *PTRResolver {
+	return &PTRResolver{Timeout: timeout, Size: size}
+}
+
+// Annotate sets Hostname on every responding hup, along with the Iface and
+// Location hints ParsePTR finds in it.
+func (p *PTRResolver) Annotate(ctx context.Context, hups []*MTRHup) {
+	var wg sync.WaitGroup
+	for _, h := range hups {
+		if h.Host == "???" {
+			continue
+		}
+		wg.Add(1)
+		go func(h *MTRHup) {
+			defer wg.Done()
+			h.Hostname = p.Lookup(ctx, h.Host)
+			hint := ParsePTR(h.Hostname)
+			h.Iface, h.Location = hint.Interface, hint.Location
+		}(h)
+	}
+	wg.Wait()
+}
+
+// Lookup returns the first PTR name of addr without the trailing dot, or ""
+// if it has none.
+func (p *PTRResolver) Lookup(ctx context.Context, addr string) string {
+	if name, ok := p.get(addr); ok {
+		return name
+	}
+	var r AddrResolver = net.DefaultResolver
+	if p.Resolver != nil {
+		r = p.Resolver
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	names, err := r.LookupAddr(ctx, addr)
+	if ctx.Err() != nil && err != nil {
+		// Don't cache names we gave up on.
+		return ""
+	}
+	var name string
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	p.put(addr, name)
+	return name
+}
+
+func (p *PTRResolver) get(addr string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.cache[addr]
+	if !ok {
+		return "", false
+	}
+	p.order.MoveToFront(e)
+	return e.Value.(*ptrEntry).name, true
+}
+
+func (p *PTRResolver) put(addr, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cache == nil {
+		p.cache = make(map[string]*list.Element)
+		p.order = list.New()
+	}
+	if e, ok := p.cache[addr]; ok {
+		e.Value.(*ptrEntry).name = name
+		p.order.MoveToFront(e)
+		return
+	}
+	p.cache[addr] = p.order.PushFront(&ptrEntry{addr: addr, name: name})
+	size := p.Size
+	if size <= 0 {
+		size = DefaultPTRCacheSize
+	}
+	for p.order.Len() > size {
+		e := p.order.Back()
+		p.order.Remove(e)
+		delete(p.cache, e.Value.(*ptrEntry).addr)
+	}
+}
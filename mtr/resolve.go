@@ -0,0 +1,71 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Address family preferences for hostname destinations.
+const (
+	PreferIPv4 = "ip4"
+	PreferIPv6 = "ip6"
+)
+
+// Resolver looks up the addresses of a hostname. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ResolveError is returned when a hostname destination cannot be resolved
+// to a usable address.
+type ResolveError struct {
+	Host string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("resolve %s: %v", e.Host, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes a ResolveError match ErrResolve.
+func (e *ResolveError) Is(target error) bool {
+	return target == ErrResolve
+}
+
+// resolve turns dst into an address, looking hostnames up with op.Resolver
+// (net.DefaultResolver if nil). It returns the hostname, or "" if dst was
+// already an IP.
+func (op *OPMTR) resolve(ctx context.Context, dst string) (net.IP, string, error) {
+	if ip := net.ParseIP(dst); ip != nil {
+		return ip, "", op.checkFamily(ip)
+	}
+	var r Resolver = net.DefaultResolver
+	if op.Resolver != nil {
+		r = op.Resolver
+	}
+	addrs, err := r.LookupIPAddr(ctx, dst)
+	if err != nil {
+		return nil, dst, &ResolveError{Host: dst, Err: err}
+	}
+	var fallback net.IP
+	for _, a := range addrs {
+		if op.checkFamily(a.IP) != nil {
+			continue
+		}
+		if (a.IP.To4() != nil) == (op.Prefer != PreferIPv6) {
+			return a.IP, dst, nil
+		}
+		if fallback == nil {
+			fallback = a.IP
+		}
+	}
+	if fallback == nil {
+		return nil, dst, &ResolveError{Host: dst, Err: fmt.Errorf("no usable address among %v", addrs)}
+	}
+	return fallback, dst, nil
+}
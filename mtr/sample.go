@@ -0,0 +1,27 @@
+package mtr
+
+// SampleReports thins a high-frequency report stream for heavy consumers
+// such as storage. Every report from in is passed to each, if set, so cheap
+// per-round work like alert evaluation keeps full resolution; only every
+// Nth report per src/dst is forwarded on the returned channel, starting
+// with the first. The returned channel is closed once in is.
+func SampleReports(in <-chan MTRReport, every int, each func(MTRReport)) <-chan MTRReport {
+	out := make(chan MTRReport)
+	go func() {
+		defer close(out)
+		seen := map[string]int{}
+		for r := range in {
+			if each != nil {
+				each(r)
+			}
+			k := pathKey(r.Src, r.Dst)
+			n := seen[k]
+			seen[k] = n + 1
+			if every > 1 && n%every != 0 {
+				continue
+			}
+			out <- r
+		}
+	}()
+	return out
+}
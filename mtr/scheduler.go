@@ -0,0 +1,134 @@
+package mtr
+
+import (
+	"context"
+	"time"
+)
+
+// Scheduler spreads a probe budget over many targets with smooth weighted
+// round-robin. A target's share follows its Weight, and targets whose last
+// run scored below MinHealth, or failed, get Boost times their share so
+// unhealthy paths are measured more often than healthy ones.
+type Scheduler struct {
+	// Budget is the number of probe packets per second to spend across all
+	// targets. Zero means runs follow each other without pause.
+	Budget float64
+	// Scorer rates each report. Reports scoring below MinHealth, 80 if
+	// zero, mark their target unhealthy.
+	Scorer    HealthScorer
+	MinHealth float64
+	// Boost multiplies the weight of unhealthy targets, 4 if zero.
+	Boost float64
+
+	entries []*schedEntry
+}
+
+type schedEntry struct {
+	t         Target
+	current   float64
+	unhealthy bool
+}
+
+// NewScheduler returns a Scheduler over targets spending budget packets
+// per second.
+func NewScheduler(targets []Target, budget float64) *Scheduler {
+	s := &Scheduler{Budget: budget}
+	for _, t := range targets {
+		s.entries = append(s.entries, &schedEntry{t: t})
+	}
+	return s
+}
+
+func (s *Scheduler) weight(e *schedEntry) float64 {
+	w := e.t.Weight
+	if w <= 0 {
+		w = 1
+	}
+	if e.unhealthy {
+		boost := s.Boost
+		if boost <= 0 {
+			boost = 4
+		}
+		w *= boost
+	}
+	return w
+}
+
+// next picks the target to run, the one furthest behind its share.
+func (s *Scheduler) next() *schedEntry {
+	var best *schedEntry
+	var total float64
+	for _, e := range s.entries {
+		w := s.weight(e)
+		e.current += w
+		total += w
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// observe updates the health of e from its latest run.
+func (s *Scheduler) observe(e *schedEntry, r MTRReport, err error) {
+	min := s.MinHealth
+	if min == 0 {
+		min = 80
+	}
+	e.unhealthy = err != nil || s.Scorer.Score(r) < min
+}
+
+// Run measures the targets one at a time until ctx is done, pausing after
+// each run long enough to keep within Budget, and sends the reports on the
+// returned channel, which is closed when scheduling stops. Failed runs are
+// logged to op.Logger and skipped. Runs have PriorityBackground unless ctx
+// carries a priority.
+func (s *Scheduler) Run(ctx context.Context, op *OPMTR) <-chan MTRReport {
+	if _, ok := ctx.Value(priorityKey{}).(Priority); !ok {
+		ctx = WithPriority(ctx, PriorityBackground)
+	}
+	clk := op.clock()
+	ch := make(chan MTRReport)
+	go func() {
+		defer close(ch)
+		if len(s.entries) == 0 {
+			return
+		}
+		for {
+			e := s.next()
+			start := clk.Now()
+			r, err := op.RunTarget(ctx, e.t)
+			if ctx.Err() != nil {
+				return
+			}
+			s.observe(e, r, err)
+			// A failed run still sent its probes; assume one round.
+			cost := float64(op.PingCount)
+			if err != nil {
+				logTo(op.Logger, LevelError, "scheduled run failed", "dst", e.t.Dst, "err", err)
+			} else {
+				cost = float64(len(r.Hups))
+				for _, h := range r.Hups {
+					cost += h.Snt
+				}
+				select {
+				case ch <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if s.Budget <= 0 {
+				continue
+			}
+			pause := time.Duration(cost/s.Budget*float64(time.Second)) - clk.Now().Sub(start)
+			if pause <= 0 {
+				continue
+			}
+			if sleep(ctx, clk, pause) != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
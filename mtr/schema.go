@@ -0,0 +1,90 @@
+package mtr
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ReportVersion is the version of the report format described by
+// ReportSchema. It changes whenever a field changes incompatibly.
+const ReportVersion = 1
+
+// ReportSchema is the JSON Schema (draft-07) of a serialized MTRReport.
+//
+//go:embed schema.json
+var ReportSchema string
+
+// ErrInvalidReport is wrapped by all errors returned from Validate.
+var ErrInvalidReport = errors.New("invalid report")
+
+// Validate checks that r is a well-formed report of the current version.
+func (r MTRReport) Validate() error {
+	if r.Version != ReportVersion {
+		return fmt.Errorf("%w: version %d, want %d", ErrInvalidReport, r.Version, ReportVersion)
+	}
+	if r.ID == "" {
+		return fmt.Errorf("%w: missing id", ErrInvalidReport)
+	}
+	if r.Dst == "" {
+		return fmt.Errorf("%w: missing dst", ErrInvalidReport)
+	}
+	if r.Time < 0 || r.Count < 0 {
+		return fmt.Errorf("%w: negative ts or count", ErrInvalidReport)
+	}
+	for i, h := range r.Hups {
+		if h.Count != i+1 {
+			return fmt.Errorf("%w: hup %d has count %d", ErrInvalidReport, i+1, h.Count)
+		}
+		if h.Host == "" {
+			return fmt.Errorf("%w: hup %d has no host", ErrInvalidReport, h.Count)
+		}
+		if h.Loss < 0 || h.Loss > 1 {
+			return fmt.Errorf("%w: hup %d loss %v out of range", ErrInvalidReport, h.Count, h.Loss)
+		}
+		if h.Snt < 0 || h.Last < 0 || h.Avg < 0 || h.Best < 0 || h.Wrst < 0 || h.StDev < 0 || h.Jitter < 0 ||
+			h.P50 < 0 || h.P90 < 0 || h.P99 < 0 {
+			return fmt.Errorf("%w: hup %d has negative statistics", ErrInvalidReport, h.Count)
+		}
+		if h.SegEst < 0 {
+			return fmt.Errorf("%w: hup %d has negative estimated segment delay", ErrInvalidReport, h.Count)
+		}
+		if h.Best > h.Wrst {
+			return fmt.Errorf("%w: hup %d best %v above worst %v", ErrInvalidReport, h.Count, h.Best, h.Wrst)
+		}
+		if g := h.Geo; g != nil && (g.Lat < -90 || g.Lat > 90 || g.Lon < -180 || g.Lon > 180) {
+			return fmt.Errorf("%w: hup %d location out of range", ErrInvalidReport, h.Count)
+		}
+	}
+	if r.DNS != nil && r.DNS.RTT < 0 {
+		return fmt.Errorf("%w: negative dns rtt", ErrInvalidReport)
+	}
+	if b := r.Baseline; b != nil {
+		if b.Loss < 0 || b.Loss > 1 {
+			return fmt.Errorf("%w: baseline loss %v out of range", ErrInvalidReport, b.Loss)
+		}
+		if b.Snt < 0 || b.Last < 0 || b.Avg < 0 || b.Best < 0 || b.Wrst < 0 || b.StDev < 0 || b.Jitter < 0 {
+			return fmt.Errorf("%w: baseline has negative statistics", ErrInvalidReport)
+		}
+	}
+	for _, e := range r.Errors {
+		if e.Hop < 0 {
+			return fmt.Errorf("%w: error for hop %d", ErrInvalidReport, e.Hop)
+		}
+	}
+	return nil
+}
+
+// ParseReport decodes a serialized report, rejecting unknown fields, and
+// validates it.
+func ParseReport(b []byte) (MTRReport, error) {
+	var r MTRReport
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return r, fmt.Errorf("%w: %v", ErrInvalidReport, err)
+	}
+	return r, r.Validate()
+}
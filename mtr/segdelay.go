@@ -0,0 +1,43 @@
+package mtr
+
+// estimateSegments sets SegRaw and SegEst on the responding hups. The
+// minimum RTT is the best estimate of a hop's propagation delay, but it
+// still dips along the path when a router is slow to answer. Fitting a
+// non-decreasing sequence to the minima by weighted isotonic regression
+// (pool adjacent violators, weighted by replies) spreads such dips over
+// their neighbours instead of reporting negative delays.
+func estimateSegments(hups []MTRHup) {
+	type block struct {
+		sum, w float64
+		n      int
+	}
+	var idx []int
+	var blocks []block
+	for i, h := range hups {
+		rcv := h.Snt * (1 - h.Loss)
+		if h.Host == "???" || rcv <= 0 {
+			continue
+		}
+		idx = append(idx, i)
+		blocks = append(blocks, block{h.Best * rcv, rcv, 1})
+		for n := len(blocks); n > 1 && blocks[n-2].sum/blocks[n-2].w > blocks[n-1].sum/blocks[n-1].w; n-- {
+			b := blocks[n-1]
+			blocks = blocks[:n-1]
+			blocks[n-2].sum += b.sum
+			blocks[n-2].w += b.w
+			blocks[n-2].n += b.n
+		}
+	}
+	var prevBest, prevFit float64
+	k := 0
+	for _, b := range blocks {
+		fit := b.sum / b.w
+		for j := 0; j < b.n; j++ {
+			h := &hups[idx[k]]
+			h.SegRaw = h.Best - prevBest
+			h.SegEst = fit - prevFit
+			prevBest, prevFit = h.Best, fit
+			k++
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Segment is the latency added between two consecutive responsive hops.
+// From is 0 for the segment starting at the source.
+type Segment struct {
+	From     int
+	FromHost string
+	To       int
+	ToHost   string
+	// Raw is the difference of the hops' average RTTs in milliseconds. It
+	// goes negative when a router answers slowly, e.g. because ICMP
+	// generation is deprioritized, and a later one answers faster.
+	Raw float64
+	// Added is the delay the segment contributes: the amount To's average
+	// exceeds the highest average seen before it, never negative. The
+	// Added values of a path sum to the highest average on it.
+	Added float64
+	// Share is Added as a fraction of the path's total.
+	Share float64
+	// Estimated is To's SegEst, the segment's share of propagation delay.
+	Estimated float64
+}
+
+// Segments breaks the path down into per-segment latency contributions.
+// Hops that never answered are skipped.
+func (r MTRReport) Segments() []Segment {
+	var segs []Segment
+	prev := Segment{ToHost: r.Src}
+	var last, high, total float64
+	for _, h := range r.Hups {
+		if h.Host == "???" || h.Loss >= 1 {
+			continue
+		}
+		s := Segment{From: prev.To, FromHost: prev.ToHost, To: h.Count, ToHost: h.Host, Raw: h.Avg - last, Estimated: h.SegEst}
+		if h.Avg > high {
+			s.Added = h.Avg - high
+			high = h.Avg
+		}
+		total += s.Added
+		segs = append(segs, s)
+		prev, last = s, h.Avg
+	}
+	for i := range segs {
+		if total > 0 {
+			segs[i].Share = segs[i].Added / total
+		}
+	}
+	return segs
+}
+
+// ToLatencyBudget renders Segments as a table with a bar per segment, so
+// the segment adding the most delay stands out.
+func (r MTRReport) ToLatencyBudget() string {
+	return r.LatencyBudget(Locale{})
+}
+
+// LatencyBudget is ToLatencyBudget with numbers formatted for l.
+func (r MTRReport) LatencyBudget(l Locale) string {
+	const barWidth = 30
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-44s %8s %8s %8s %6s\n", "Segment", "Added", "Raw", "Est", "Share")
+	for _, s := range r.Segments() {
+		name := fmt.Sprintf("%2d %s -> %2d %s", s.From, s.FromHost, s.To, s.ToHost)
+		line := fmt.Sprintf("%-44s %8s %8s %8s %5s%% %s",
+			name, l.Float(s.Added, 1), l.Float(s.Raw, 1), l.Float(s.Estimated, 1), l.Float(s.Share*100, 1), strings.Repeat("#", int(s.Share*barWidth+0.5)))
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package mtr
+
+import (
+	"fmt"
+	"io"
+)
+
+// Thresholds above which WriteSideBySide flags a hop.
+const (
+	sideLossDelta = 0.05
+	sideAvgDelta  = 10.0
+)
+
+// WriteSideBySide prints the hops of a and b (e.g. before/after, or two
+// agents) in aligned columns with their loss and average RTT deltas. Hops
+// whose address changed, or whose loss or average moved noticeably, are
+// marked with "!".
+func WriteSideBySide(w io.Writer, a, b MTRReport) error {
+	p := &digestWriter{w: w}
+	p.printf("%-4s %-20s %6s %7s | %-20s %6s %7s | %7s %8s\n",
+		"HOP", a.Src+" "+a.Dst, "Loss%", "Avg", b.Src+" "+b.Dst, "Loss%", "Avg", "ΔLoss%", "ΔAvg")
+	n := len(a.Hups)
+	if len(b.Hups) > n {
+		n = len(b.Hups)
+	}
+	for i := 0; i < n; i++ {
+		ha, oka := sideHup(a, i)
+		hb, okb := sideHup(b, i)
+		mark := " "
+		if ha.Host != hb.Host {
+			mark = "!"
+		}
+		dl, da := "", ""
+		if oka && okb && ha.Host != "???" && hb.Host != "???" {
+			lossDelta, avgDelta := hb.Loss-ha.Loss, hb.Avg-ha.Avg
+			dl = fmt.Sprintf("%+.1f", lossDelta*100)
+			da = fmt.Sprintf("%+.1f", avgDelta)
+			if abs(lossDelta) >= sideLossDelta || abs(avgDelta) >= sideAvgDelta {
+				mark = "!"
+			}
+		}
+		p.printf("%s%-3d %s | %s | %7s %8s\n", mark, i+1, sideCells(ha, oka), sideCells(hb, okb), dl, da)
+	}
+	return p.err
+}
+
+func sideHup(r MTRReport, i int) (MTRHup, bool) {
+	if i < len(r.Hups) {
+		return r.Hups[i], true
+	}
+	return MTRHup{}, false
+}
+
+func sideCells(h MTRHup, ok bool) string {
+	switch {
+	case !ok:
+		return fmt.Sprintf("%-20s %6s %7s", "", "", "")
+	case h.Host == "???":
+		return fmt.Sprintf("%-20s %6s %7s", h.Host, "", "")
+	}
+	return fmt.Sprintf("%-20s %5.1f%% %7.1f", h.Host, h.Loss*100, h.Avg)
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
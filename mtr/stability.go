@@ -0,0 +1,64 @@
+package mtr
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultStabilityWindows are the windows summaries report stability over.
+var DefaultStabilityWindows = []time.Duration{time.Hour, 24 * time.Hour}
+
+// PathStability tells a chronically flappy route from a stable one.
+type PathStability struct {
+	Window time.Duration
+	// Runs is the number of runs within the window.
+	Runs int
+	// Modal is the fraction of those runs that took the most common path.
+	Modal float64
+	// Distinct is the number of different paths they took.
+	Distinct int
+}
+
+// Stability computes the path stability from src to dst over the runs within
+// window of the latest one, or over all retained runs if window is zero. It
+// reports false if no run is known.
+func (db *PathDB) Stability(src, dst string, window time.Duration) (PathStability, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rec, ok := db.paths[pathKey(src, dst)]
+	if !ok || len(rec.Runs) == 0 {
+		return PathStability{}, false
+	}
+	s := PathStability{Window: window}
+	since := rec.Runs[len(rec.Runs)-1].Time - int64(window/time.Second)
+	counts := map[string]int{}
+	modal := 0
+	for _, run := range rec.Runs {
+		if window > 0 && run.Time <= since {
+			continue
+		}
+		s.Runs++
+		counts[run.Path]++
+		if counts[run.Path] > modal {
+			modal = counts[run.Path]
+		}
+	}
+	s.Distinct = len(counts)
+	s.Modal = float64(modal) / float64(s.Runs)
+	return s, true
+}
+
+// FormatWindow renders a stability window compactly, like "1h" or "15m".
+func FormatWindow(d time.Duration) string {
+	if d <= 0 {
+		return "all"
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package mtr
+
+import (
+	"errors"
+	"sync"
+)
+
+// HopStats accumulates the statistics of one hup while its pings are in
+// flight. It is safe for concurrent use: workers Add probe outcomes while
+// others read the hup through Host or Snapshot.
+type HopStats struct {
+	mu  sync.Mutex
+	hup MTRHup
+}
+
+// NewHopStats returns a HopStats starting from h, e.g. a hup found by the
+// trace.
+func NewHopStats(h MTRHup) *HopStats {
+	return &HopStats{hup: h.clone()}
+}
+
+// Add records one probe answered by ip after rtt milliseconds, or lost if
+// ip is empty.
+func (s *HopStats) Add(ip string, rtt float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hup.Snt++
+	if ip == "" {
+		s.hup.LossPoint++
+		return
+	}
+	s.hup.record(ip, rtt)
+}
+
+// unreachable records the code of err if it is an *UnreachableError.
+func (s *HopStats) unreachable(err error) {
+	var unreach *UnreachableError
+	if !errors.As(err, &unreach) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hup.unreachable(unreach.Code)
+}
+
+// Host returns the address the hup is pinged at, "???" if unknown.
+func (s *HopStats) Host() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hup.Host
+}
+
+// adopt records the first answer of an unknown hup from ip, which becomes
+// its host.
+func (s *HopStats) adopt(ip string, rtt float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hup.Host = ip
+	s.hup.Snt++
+	s.hup.record(ip, rtt)
+}
+
+// Snapshot returns a copy of the statistics so far with Loss up to date.
+func (s *HopStats) Snapshot() MTRHup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.hup.clone()
+	if h.Snt > 0 {
+		h.Loss = float64(h.LossPoint) / h.Snt
+	}
+	return h
+}
+
+// clone returns a copy of h sharing no slices with it.
+func (h MTRHup) clone() MTRHup {
+	h.Hosts = append([]HopHost(nil), h.Hosts...)
+	h.Samples = append([]float64(nil), h.Samples...)
+	h.rtts = append([]float64(nil), h.rtts...)
+	return h
+}
+
+// pathStats is the HopStats of every hup of a run.
+type pathStats struct {
+	// claimMu makes checking whether an address is already on the path and
+	// adopting it one step, so two unknown hups can't claim the same one.
+	claimMu sync.Mutex
+	hops    []*HopStats
+}
+
+func newPathStats(hups []*MTRHup) *pathStats {
+	p := &pathStats{hops: make([]*HopStats, len(hups))}
+	for i, h := range hups {
+		p.hops[i] = NewHopStats(*h)
+	}
+	return p
+}
+
+// claim makes ip, which answered for the unknown hup s, its host unless a
+// hup of the path already has that address. It reports whether it did.
+func (p *pathStats) claim(s *HopStats, ip string, rtt float64) bool {
+	p.claimMu.Lock()
+	defer p.claimMu.Unlock()
+	for _, h := range p.hops {
+		if h.Host() == ip {
+			return false
+		}
+	}
+	s.adopt(ip, rtt)
+	return true
+}
+
+// snapshot returns the final statistics of every hup.
+func (p *pathStats) snapshot() []*MTRHup {
+	hups := make([]*MTRHup, len(p.hops))
+	for i, s := range p.hops {
+		h := s.Snapshot()
+		hups[i] = &h
+	}
+	return hups
+}
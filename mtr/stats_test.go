@@ -0,0 +1,118 @@
+package mtr
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHopStatsConcurrent(t *testing.T) {
+	s := NewHopStats(MTRHup{Count: 1, Host: "10.0.0.1"})
+	const workers, pings = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < pings; i++ {
+				if i%4 == 3 {
+					s.Add("", 0)
+				} else {
+					s.Add(fmt.Sprintf("10.0.0.%d", w%2+1), float64(i%10+1))
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < pings; i++ {
+				// snapshots are copies, changing them must not race with Add
+				h := s.Snapshot()
+				h.Hosts = append(h.Hosts, HopHost{Host: "x"})
+				if len(h.Hosts) > 1 {
+					h.Hosts[0].Rcv = -1
+				}
+				_ = s.Host()
+			}
+		}()
+	}
+	wg.Wait()
+	h := s.Snapshot()
+	if h.Snt != workers*pings || h.LossPoint != workers*pings/4 {
+		t.Fatalf("Snt %v, LossPoint %d, want %d, %d", h.Snt, h.LossPoint, workers*pings, workers*pings/4)
+	}
+	rcv := 0
+	for _, hh := range h.Hosts {
+		rcv += hh.Rcv
+	}
+	if want := workers * pings * 3 / 4; rcv != want {
+		t.Errorf("hosts received %d, want %d", rcv, want)
+	}
+	if h.Loss != 0.25 || h.Best != 1 || h.Wrst != 10 {
+		t.Errorf("Loss %v, Best %v, Wrst %v", h.Loss, h.Best, h.Wrst)
+	}
+}
+
+func TestHopStatsAdoptAfterLosses(t *testing.T) {
+	// a hop unknown to the trace, losing pings before one is answered
+	s := NewHopStats(MTRHup{Count: 2, Host: "???", Snt: 1, LossPoint: 1})
+	s.Add("", 0)
+	s.Add("", 0)
+	s.adopt("10.0.0.2", 12)
+	h := s.Snapshot()
+	if h.Host != "10.0.0.2" || h.Snt != 4 || h.Loss != 0.75 || h.Avg != 12 || h.Best != 12 || h.Wrst != 12 {
+		t.Errorf("adopted after losses: %+v", h)
+	}
+	s.Add("", 0)
+	s.Add("10.0.0.2", 6)
+	if h := s.Snapshot(); h.Avg != 9 || h.Best != 6 || h.Wrst != 12 || h.Hosts[0].Avg != 9 {
+		t.Errorf("Avg %v, Best %v, Wrst %v, host Avg %v, want 9, 6, 12, 9", h.Avg, h.Best, h.Wrst, h.Hosts[0].Avg)
+	}
+}
+
+func TestPathStatsClaim(t *testing.T) {
+	hups := []*MTRHup{{Count: 1, Host: "10.0.0.1"}}
+	for ttl := 2; ttl <= 9; ttl++ {
+		hups = append(hups, &MTRHup{Count: ttl, Host: "???"})
+	}
+	p := newPathStats(hups)
+	var wg sync.WaitGroup
+	claimed := make(chan int, len(hups))
+	for _, s := range p.hops[1:] {
+		wg.Add(1)
+		go func(s *HopStats) {
+			defer wg.Done()
+			// an address already on the path is never claimed
+			if p.claim(s, "10.0.0.1", 1) {
+				t.Error("claimed the address of hop 1")
+			}
+			if p.claim(s, "10.0.0.9", 9) {
+				claimed <- s.Snapshot().Count
+			}
+		}(s)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p.hops[0].Add("10.0.0.1", 1)
+			p.snapshot()
+		}
+	}()
+	wg.Wait()
+	close(claimed)
+	var winners []int
+	for c := range claimed {
+		winners = append(winners, c)
+	}
+	if len(winners) != 1 {
+		t.Fatalf("hops %v claimed 10.0.0.9, want exactly one", winners)
+	}
+	for _, h := range p.snapshot() {
+		switch {
+		case h.Count == winners[0] && (h.Host != "10.0.0.9" || h.Snt != 1 || h.Avg != 9):
+			t.Errorf("claiming hop %+v", *h)
+		case h.Count != winners[0] && h.Count > 1 && h.Host != "???":
+			t.Errorf("hop %d adopted %s", h.Count, h.Host)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+)
+
+// TargetOptions override OPMTR settings for one target. Zero fields inherit
+// the group defaults, then the OPMTR settings.
+type TargetOptions struct {
+	Count     int    `json:"count,omitempty"`
+	ProbeMode string `json:"probe_mode,omitempty"`
+	TCPPort   int    `json:"tcp_port,omitempty"`
+	// Weight is the target's share of a Scheduler's probe budget, 1 if
+	// zero.
+	Weight float64 `json:"weight,omitempty"`
+	// DNS, if set, also queries the target as a DNS resolver.
+	DNS *DNSProbe `json:"dns,omitempty"`
+	// NTP, if set, also queries the target as a time server.
+	NTP *NTPProbe `json:"ntp,omitempty"`
+}
+
+// Target is a destination to measure.
+type Target struct {
+	Dst   string `json:"dst"`
+	Group string `json:"group,omitempty"`
+	TargetOptions
+}
+
+// Group is a named set of targets sharing default options, e.g.
+// "dns-providers" or "branch-offices".
+type Group struct {
+	Name     string        `json:"name"`
+	Defaults TargetOptions `json:"defaults"`
+	Targets  []Target      `json:"targets"`
+}
+
+// GroupSummary aggregates the end-to-end results of a group's reports.
+type GroupSummary struct {
+	Group     string  `json:"group"`
+	Targets   int     `json:"targets"`
+	WorstDst  string  `json:"worst_dst"`
+	WorstLoss float64 `json:"worst_loss"`
+	AvgLoss   float64 `json:"avg_loss"`
+	AvgRTT    float64 `json:"avg_rtt"`
+}
+
+// LoadGroups reads a JSON array of groups from path.
+func LoadGroups(path string) ([]Group, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	err = json.Unmarshal(b, &groups)
+	return groups, err
+}
+
+// Expand returns the group's targets with the group name and defaults
+// filled in.
+func (g Group) Expand() []Target {
+	targets := make([]Target, len(g.Targets))
+	for i, t := range g.Targets {
+		t.Group = g.Name
+		if t.Count == 0 {
+			t.Count = g.Defaults.Count
+		}
+		if t.ProbeMode == "" {
+			t.ProbeMode = g.Defaults.ProbeMode
+		}
+		if t.TCPPort == 0 {
+			t.TCPPort = g.Defaults.TCPPort
+		}
+		if t.Weight == 0 {
+			t.Weight = g.Defaults.Weight
+		}
+		if t.DNS == nil {
+			t.DNS = g.Defaults.DNS
+		}
+		if t.NTP == nil {
+			t.NTP = g.Defaults.NTP
+		}
+		targets[i] = t
+	}
+	return targets
+}
+
+// RunTarget runs an MTR to t with its options applied on top of op's.
+func (op *OPMTR) RunTarget(ctx context.Context, t Target) (MTRReport, error) {
+	o := *op
+	if t.Count != 0 {
+		o.PingCount = t.Count
+	}
+	if t.ProbeMode != "" {
+		o.ProbeMode = t.ProbeMode
+	}
+	if t.TCPPort != 0 {
+		o.TCPPort = t.TCPPort
+	}
+	if t.DNS != nil {
+		o.DNS = t.DNS
+	}
+	if t.NTP != nil {
+		o.NTP = t.NTP
+	}
+	r, err := o.RunContext(ctx, t.Dst)
+	r.Group = t.Group
+	return r, err
+}
+
+// SummarizeGroup aggregates reports of one group: the member with the worst
+// end-to-end loss and the average loss and RTT over all members.
+func SummarizeGroup(group string, reports []MTRReport) GroupSummary {
+	s := GroupSummary{Group: group, Targets: len(reports), WorstLoss: -1}
+	var rtts int
+	for _, r := range reports {
+		h := lastHup(r)
+		if h.Loss > s.WorstLoss {
+			s.WorstLoss = h.Loss
+			s.WorstDst = r.Dst
+		}
+		s.AvgLoss += h.Loss
+		if h.Host != "???" && h.Loss < 1 {
+			s.AvgRTT += h.Avg
+			rtts++
+		}
+	}
+	if len(reports) > 0 {
+		s.AvgLoss /= float64(len(reports))
+	} else {
+		s.WorstLoss = 0
+	}
+	if rtts > 0 {
+		s.AvgRTT /= float64(rtts)
+	}
+	return s
+}
@@ -0,0 +1,296 @@
+package mtr
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultTCPPort is the destination port of TCP probes when OPMTR.TCPPort
+// is not set.
+const DefaultTCPPort = 80
+
+// tcpBasePort and tcpPortRange delimit the source ports of TCP probes, one
+// per outstanding probe.
+const (
+	tcpBasePort  = 50000
+	tcpPortRange = 1024
+)
+
+// tcpParisPort is the source port of all FlowParis probes, which are told
+// apart by sequence number instead.
+const tcpParisPort = tcpBasePort + tcpPortRange
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// tcpProber crafts TCP SYN segments on a raw socket, each from its own
+// source port. Routers answer with ICMP Time Exceeded quoting that port;
+// the destination answers with SYN-ACK (open) or RST (closed).
+type tcpProber struct {
+	src  net.IP
+	errs icmpListener
+
+	once4, once6 sync.Once
+	raw4         *ipv4.RawConn
+	raw6         *ipv6.PacketConn
+	conn6        net.PacketConn
+	err4, err6   error
+
+	mu         sync.Mutex
+	next       int
+	pending    map[int]*pendingProbe
+	parisSeq   uint32
+	parisBySeq map[uint32]*pendingProbe
+}
+
+func newTCPProber(src net.IP, labels *labelBook) *tcpProber {
+	p := &tcpProber{
+		src:        src,
+		pending:    map[int]*pendingProbe{},
+		parisSeq:   rand.Uint32(),
+		parisBySeq: map[uint32]*pendingProbe{},
+	}
+	p.errs.handle, p.errs.labels = p.handleError, labels
+	return p
+}
+
+func (p *tcpProber) listen(v4 bool) error {
+	if err := p.errs.listen(v4); err != nil {
+		return err
+	}
+	if v4 {
+		p.once4.Do(func() {
+			var c net.PacketConn
+			if c, p.err4 = net.ListenPacket("ip4:tcp", "0.0.0.0"); p.err4 != nil {
+				return
+			}
+			if p.raw4, p.err4 = ipv4.NewRawConn(c); p.err4 != nil {
+				c.Close()
+				return
+			}
+			metrics.Add("open_sockets", 1)
+			go p.serve4()
+		})
+		return p.err4
+	}
+	p.once6.Do(func() {
+		if p.conn6, p.err6 = net.ListenPacket("ip6:tcp", "::"); p.err6 != nil {
+			return
+		}
+		p.raw6 = ipv6.NewPacketConn(p.conn6)
+		metrics.Add("open_sockets", 1)
+		go p.serve6()
+	})
+	return p.err6
+}
+
+// probe sends one SYN to ip:port limited to ttl hops, carrying payload if
+// not nil. With paris every probe uses tcpParisPort.
+func (p *tcpProber) probe(ctx context.Context, ip string, port, ttl int, timeout time.Duration, paris bool, payload []byte) (*traceroute.Reply, error) {
+	dst := net.ParseIP(ip)
+	v4 := dst.To4() != nil
+	if v4 {
+		dst = dst.To4()
+	}
+	if err := p.listen(v4); err != nil {
+		return nil, err
+	}
+	src, err := p.srcFor(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &pendingProbe{dst: dst, ch: make(chan probeAnswer, 1)}
+	var sport int
+	var seq uint32
+	p.mu.Lock()
+	if paris {
+		sport = tcpParisPort
+		p.parisSeq++
+		seq = p.parisSeq
+		p.parisBySeq[seq] = pr
+	} else {
+		sport = tcpBasePort + p.next
+		p.next = (p.next + 1) % tcpPortRange
+		seq = rand.Uint32()
+		p.pending[sport] = pr
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if paris {
+			delete(p.parisBySeq, seq)
+		} else {
+			delete(p.pending, sport)
+		}
+		p.mu.Unlock()
+	}()
+
+	seg := tcpSYN(src, dst, sport, port, seq, payload)
+	pr.sent = time.Now()
+	if v4 {
+		err = p.raw4.WriteTo(&ipv4.Header{
+			Version:  ipv4.Version,
+			Len:      ipv4.HeaderLen,
+			TotalLen: ipv4.HeaderLen + len(seg),
+			TTL:      ttl,
+			Protocol: 6,
+			Src:      src,
+			Dst:      dst,
+		}, seg, nil)
+	} else {
+		_, err = p.raw6.WriteTo(seg, &ipv6.ControlMessage{HopLimit: ttl}, &net.IPAddr{IP: dst})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// srcFor returns the source address used towards dst, which the TCP
+// checksum covers.
+func (p *tcpProber) srcFor(dst net.IP) (net.IP, error) {
+	if p.src != nil && !p.src.IsUnspecified() {
+		if v4 := p.src.To4(); v4 != nil {
+			return v4, nil
+		}
+		return p.src, nil
+	}
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	ip := c.LocalAddr().(*net.UDPAddr).IP
+	if v4 := ip.To4(); v4 != nil {
+		return v4, nil
+	}
+	return ip, nil
+}
+
+func (p *tcpProber) serve4() {
+	buf := make([]byte, 1500)
+	for {
+		h, seg, _, err := p.raw4.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		p.handleSegment(h.Src, seg, time.Now())
+	}
+}
+
+func (p *tcpProber) serve6() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := p.conn6.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if addr, ok := from.(*net.IPAddr); ok {
+			p.handleSegment(addr.IP, buf[:n], time.Now())
+		}
+	}
+}
+
+// handleSegment matches a SYN-ACK or RST from the destination.
+func (p *tcpProber) handleSegment(from net.IP, seg []byte, now time.Time) {
+	if len(seg) < 20 {
+		return
+	}
+	flags := seg[13]
+	if flags&tcpFlagRST == 0 && flags&(tcpFlagSYN|tcpFlagACK) != tcpFlagSYN|tcpFlagACK {
+		return
+	}
+	// a SYN-ACK or RST acknowledges the SYN's sequence number plus one
+	p.deliver(int(binary.BigEndian.Uint16(seg[2:4])), binary.BigEndian.Uint32(seg[8:12])-1, from, true, nil, now)
+}
+
+func (p *tcpProber) handleError(from net.IP, quoted []byte, unreach *UnreachableError, now time.Time) {
+	proto, l4 := quotedTransport(quoted)
+	if proto != 6 || l4 == nil {
+		return
+	}
+	p.deliver(int(binary.BigEndian.Uint16(l4[0:2])), binary.BigEndian.Uint32(l4[4:8]), from, false, unreach, now)
+}
+
+func (p *tcpProber) deliver(sport int, seq uint32, from net.IP, fromDst bool, unreach *UnreachableError, now time.Time) {
+	p.mu.Lock()
+	pr, ok := p.pending[sport]
+	if sport == tcpParisPort {
+		pr, ok = p.parisBySeq[seq]
+	}
+	p.mu.Unlock()
+	if !ok || (fromDst && !pr.dst.Equal(from)) {
+		return
+	}
+	pr.answer(from, now, unreach)
+}
+
+func (p *tcpProber) close() {
+	p.errs.close()
+	p.once4.Do(func() {})
+	p.once6.Do(func() {})
+	if p.raw4 != nil {
+		p.raw4.Close()
+		metrics.Add("open_sockets", -1)
+	}
+	if p.conn6 != nil {
+		p.conn6.Close()
+		metrics.Add("open_sockets", -1)
+	}
+}
+
+// tcpSYN builds a TCP SYN segment carrying payload with a valid checksum.
+func tcpSYN(src, dst net.IP, sport, dport int, seq uint32, payload []byte) []byte {
+	seg := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(seg[0:], uint16(sport))
+	binary.BigEndian.PutUint16(seg[2:], uint16(dport))
+	binary.BigEndian.PutUint32(seg[4:], seq)
+	seg[12] = 5 << 4
+	seg[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(seg[14:], 65535)
+	seg = append(seg, payload...)
+
+	n := len(seg)
+	var pseudo []byte
+	if src.To4() != nil {
+		pseudo = append(append(append(pseudo, src.To4()...), dst.To4()...), 0, 6, byte(n>>8), byte(n))
+	} else {
+		pseudo = append(append(append(pseudo, src.To16()...), dst.To16()...), byte(n>>24), byte(n>>16), byte(n>>8), byte(n), 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(seg[16:], checksum(append(pseudo, seg...)))
+	return seg
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,105 @@
+package mtr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSOptions is the TLS configuration shared by the API daemon and the
+// HTTPS connections op-mtr makes, such as to an OIDC issuer or to Consul.
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM certificate and key presented: the
+	// server's for the daemon, a client certificate for connections made.
+	CertFile, KeyFile string
+	// CAFile is a PEM bundle of the CAs verifying peers: the servers
+	// connected to, the system roots if empty, or the daemon's clients,
+	// which then must present a certificate.
+	CAFile string
+	// MinVersion is the lowest TLS version accepted, "1.2" or "1.3", 1.2
+	// if empty.
+	MinVersion string
+	// ServerName is the name servers connected to are verified against
+	// and asked for with SNI, the host connected to if empty.
+	ServerName string
+}
+
+// Enabled reports whether o sets a certificate to serve with.
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+// ServerConfig returns the configuration of a server presenting CertFile,
+// requiring client certificates signed by CAFile if set.
+func (o TLSOptions) ServerConfig() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("tls: serving needs a certificate and a key")
+	}
+	c, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	if c.RootCAs != nil {
+		c.ClientCAs, c.RootCAs = c.RootCAs, nil
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// ClientConfig returns the configuration of connections verifying servers
+// with CAFile and presenting CertFile if set.
+func (o TLSOptions) ClientConfig() (*tls.Config, error) {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("tls: a client certificate needs both a certificate and a key")
+	}
+	c, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	c.ServerName = o.ServerName
+	return c, nil
+}
+
+// HTTPClient returns an HTTP client connecting with ClientConfig.
+func (o TLSOptions) HTTPClient() (*http.Client, error) {
+	c, err := o.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = c
+	return &http.Client{Transport: t}, nil
+}
+
+// config loads the files and version common to servers and clients.
+func (o TLSOptions) config() (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch o.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		c.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("tls: unsupported minimum version %q, want 1.2 or 1.3", o.MinVersion)
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", o.CAFile)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,121 @@
+package mtr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a self-signed certificate for 127.0.0.1 and its key to
+// dir, returning their paths.
+func selfSigned(t *testing.T, dir, name string) (cert, key string) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir, "server")
+	for _, o := range []TLSOptions{
+		{CertFile: cert},
+		{KeyFile: key},
+		{CertFile: cert, KeyFile: key, MinVersion: "1.1"},
+		{CertFile: cert, KeyFile: key, CAFile: filepath.Join(dir, "missing.pem")},
+		{CertFile: cert, KeyFile: key, CAFile: key},
+	} {
+		if _, err := o.ServerConfig(); err == nil {
+			t.Errorf("ServerConfig(%+v) = nil error", o)
+		}
+	}
+	if _, err := (TLSOptions{CertFile: cert}).ClientConfig(); err == nil {
+		t.Error("ClientConfig with a certificate and no key = nil error")
+	}
+	c, err := TLSOptions{CertFile: cert, KeyFile: key, MinVersion: "1.3"}.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MinVersion != tls.VersionTLS13 || c.ClientAuth != tls.NoClientCert {
+		t.Errorf("ServerConfig = min %x client auth %v, want TLS 1.3 and none", c.MinVersion, c.ClientAuth)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir, "server")
+	ccert, ckey := selfSigned(t, dir, "client")
+	server, err := TLSOptions{CertFile: cert, KeyFile: key, CAFile: ccert}.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = server
+	// the rejected handshakes are expected
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, c := range []struct {
+		name string
+		o    TLSOptions
+		ok   bool
+	}{
+		{"client certificate", TLSOptions{CertFile: ccert, KeyFile: ckey, CAFile: cert}, true},
+		{"no client certificate", TLSOptions{CAFile: cert}, false},
+		{"server not trusted", TLSOptions{CertFile: ccert, KeyFile: ckey}, false},
+	} {
+		client, err := c.o.HTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
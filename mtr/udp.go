@@ -0,0 +1,248 @@
+package mtr
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Probe modes.
+const (
+	ProbeICMP = "icmp" // ICMP echo requests, the default
+	ProbeUDP  = "udp"  // UDP datagrams to incrementing ports, like mtr -u
+	ProbeTCP  = "tcp"  // TCP SYNs to one port, like mtr -T
+)
+
+// udpBasePort is the first destination port used by UDP probes, as in
+// classic traceroute.
+const udpBasePort = 33434
+
+// udpPortRange is how many destination ports UDP probes cycle through.
+const udpPortRange = 1024
+
+// udpParisSlots is how many FlowParis probes can be told apart by their
+// length.
+const udpParisSlots = 256
+
+// udpProber sends each probe from its own UDP socket to a fresh destination
+// port and matches ICMP Time Exceeded and Port Unreachable messages back to
+// it by that port. FlowParis probes instead share one socket and destination
+// port, and are matched by their UDP length, which routers quote but don't
+// hash.
+type udpProber struct {
+	src  net.IP
+	errs icmpListener
+
+	mu                     sync.Mutex
+	next                   int
+	pending                map[int]*pendingProbe
+	parisNext              int
+	parisPort4, parisPort6 int
+	parisByLen             map[int]*pendingProbe
+
+	parisOnce4, parisOnce6 sync.Once
+	paris4, paris6         *net.UDPConn
+	parisErr4, parisErr6   error
+	// sendMu serializes setting the TTL and sending on paris4
+	sendMu sync.Mutex
+}
+
+func newUDPProber(src net.IP, labels *labelBook) *udpProber {
+	p := &udpProber{src: src, pending: map[int]*pendingProbe{}, parisByLen: map[int]*pendingProbe{}}
+	p.errs.handle, p.errs.labels = p.handleError, labels
+	return p
+}
+
+// probe sends one datagram to ip limited to ttl hops, on a constant flow if
+// paris is set. It carries payload, or 32 zero bytes if nil.
+func (p *udpProber) probe(ctx context.Context, ip string, ttl int, timeout time.Duration, paris bool, payload []byte) (*traceroute.Reply, error) {
+	dst := net.ParseIP(ip)
+	v4 := dst.To4() != nil
+	if err := p.errs.listen(v4); err != nil {
+		return nil, err
+	}
+	if paris {
+		return p.probeParis(ctx, dst, v4, ttl, timeout, payload)
+	}
+
+	p.mu.Lock()
+	port := udpBasePort + p.next
+	p.next = (p.next + 1) % udpPortRange
+	pr := &pendingProbe{dst: dst, ch: make(chan probeAnswer, 1)}
+	p.pending[port] = pr
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, port)
+		p.mu.Unlock()
+	}()
+
+	var laddr *net.UDPAddr
+	if p.src != nil && !p.src.IsUnspecified() {
+		laddr = &net.UDPAddr{IP: p.src}
+	}
+	conn, err := net.DialUDP("udp", laddr, &net.UDPAddr{IP: dst, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Add("open_sockets", 1)
+	defer func() {
+		conn.Close()
+		metrics.Add("open_sockets", -1)
+	}()
+	if v4 {
+		err = ipv4.NewConn(conn).SetTTL(ttl)
+	} else {
+		err = ipv6.NewConn(conn).SetHopLimit(ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pr.sent = time.Now()
+	if payload == nil {
+		payload = make([]byte, 32)
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *udpProber) handleError(from net.IP, quoted []byte, unreach *UnreachableError, now time.Time) {
+	proto, l4 := quotedTransport(quoted)
+	if proto != 17 || l4 == nil {
+		return
+	}
+	sport := int(l4[0])<<8 | int(l4[1])
+	port := int(l4[2])<<8 | int(l4[3])
+	p.mu.Lock()
+	pr, ok := p.pending[port]
+	if sport == p.parisPort(from.To4() != nil) {
+		pr, ok = p.parisByLen[int(l4[4])<<8|int(l4[5])]
+	}
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	pr.answer(from, now, unreach)
+}
+
+// probeParis sends a probe from the shared socket of dst's family to
+// udpBasePort, sized to identify it: up to udpParisSlots-1 bytes shorter
+// than payload, or longer than the default 32 bytes if payload is nil.
+func (p *udpProber) probeParis(ctx context.Context, dst net.IP, v4 bool, ttl int, timeout time.Duration, payload []byte) (*traceroute.Reply, error) {
+	conn, err := p.parisConn(v4)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	size := 32 + p.parisNext
+	if payload != nil {
+		size = len(payload) - p.parisNext%(len(payload)+1)
+	}
+	p.parisNext = (p.parisNext + 1) % udpParisSlots
+	pr := &pendingProbe{dst: dst, ch: make(chan probeAnswer, 1)}
+	// the UDP length field covers the 8 byte header
+	key := 8 + size
+	p.parisByLen[key] = pr
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.parisByLen, key)
+		p.mu.Unlock()
+	}()
+
+	data := make([]byte, size)
+	copy(data, payload)
+	to := &net.UDPAddr{IP: dst, Port: udpBasePort}
+	if v4 {
+		p.sendMu.Lock()
+		if err = ipv4.NewConn(conn).SetTTL(ttl); err == nil {
+			pr.sent = time.Now()
+			_, err = conn.WriteTo(data, to)
+		}
+		p.sendMu.Unlock()
+	} else {
+		pr.sent = time.Now()
+		_, err = ipv6.NewPacketConn(conn).WriteTo(data, &ipv6.ControlMessage{HopLimit: ttl}, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case a := <-pr.ch:
+		return a.result(ttl)
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *udpProber) parisConn(v4 bool) (*net.UDPConn, error) {
+	if v4 {
+		p.parisOnce4.Do(func() {
+			laddr := &net.UDPAddr{IP: net.IPv4zero}
+			if p.src != nil && p.src.To4() != nil {
+				laddr.IP = p.src
+			}
+			if p.paris4, p.parisErr4 = net.ListenUDP("udp4", laddr); p.parisErr4 == nil {
+				p.mu.Lock()
+				p.parisPort4 = p.paris4.LocalAddr().(*net.UDPAddr).Port
+				p.mu.Unlock()
+				metrics.Add("open_sockets", 1)
+			}
+		})
+		return p.paris4, p.parisErr4
+	}
+	p.parisOnce6.Do(func() {
+		laddr := &net.UDPAddr{IP: net.IPv6unspecified}
+		if p.src != nil && p.src.To4() == nil {
+			laddr.IP = p.src
+		}
+		if p.paris6, p.parisErr6 = net.ListenUDP("udp6", laddr); p.parisErr6 == nil {
+			p.mu.Lock()
+			p.parisPort6 = p.paris6.LocalAddr().(*net.UDPAddr).Port
+			p.mu.Unlock()
+			metrics.Add("open_sockets", 1)
+		}
+	})
+	return p.paris6, p.parisErr6
+}
+
+// parisPort returns the local port of the paris socket of a family, or 0 if
+// it isn't open. p.mu must be held.
+func (p *udpProber) parisPort(v4 bool) int {
+	if v4 {
+		return p.parisPort4
+	}
+	return p.parisPort6
+}
+
+func (p *udpProber) close() {
+	p.errs.close()
+	p.parisOnce4.Do(func() {})
+	p.parisOnce6.Do(func() {})
+	if p.paris4 != nil {
+		p.paris4.Close()
+		metrics.Add("open_sockets", -1)
+	}
+	if p.paris6 != nil {
+		p.paris6.Close()
+		metrics.Add("open_sockets", -1)
+	}
+}
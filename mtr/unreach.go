@@ -0,0 +1,104 @@
+package mtr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pixelbender/go-traceroute/traceroute"
+)
+
+// ReplyUnreachable is the MTRHup.ReplyType of hups that answered with ICMP
+// Destination Unreachable.
+const ReplyUnreachable = "unreachable"
+
+// UnreachableError reports that a probe was answered with ICMP or ICMPv6
+// Destination Unreachable. Probes return it along with the reply, and
+// Prober.Trace when the trace ended at one.
+type UnreachableError struct {
+	// IP is the router that answered.
+	IP net.IP
+	// TTL is the TTL of the probe.
+	TTL int
+	// Code is the code of the message, whose meaning depends on the
+	// address family.
+	Code int
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s: destination unreachable (%s)", e.IP, unreachableAnnotation(e.IP.To4() != nil, e.Code))
+}
+
+// Traceroute annotations of Destination Unreachable codes.
+var (
+	unreachable4 = map[int]string{0: "!N", 1: "!H", 2: "!P", 3: "!p", 4: "!F", 5: "!S", 6: "!N", 7: "!H",
+		9: "!X", 10: "!X", 11: "!N", 12: "!H", 13: "!X", 14: "!V", 15: "!C"}
+	unreachable6 = map[int]string{0: "!N", 1: "!X", 2: "!S", 3: "!H", 4: "!p", 5: "!X", 6: "!X"}
+)
+
+func unreachableAnnotation(v4 bool, code int) string {
+	m := unreachable6
+	if v4 {
+		m = unreachable4
+	}
+	if a, ok := m[code]; ok {
+		return a
+	}
+	return "!" + strconv.Itoa(code)
+}
+
+// Annotation returns the traceroute-style annotation of the hup, such as
+// !H, !N or !X if it answered with Destination Unreachable, or "".
+func (h MTRHup) Annotation() string {
+	if h.ReplyType != ReplyUnreachable {
+		return ""
+	}
+	return unreachableAnnotation(net.ParseIP(h.Host).To4() != nil, h.ICMPCode)
+}
+
+// unreachable records that the hup answered with Destination Unreachable.
+func (h *MTRHup) unreachable(code int) {
+	h.ReplyType, h.ICMPCode = ReplyUnreachable, code
+}
+
+// answered reports whether a probe returning r and err got a reply.
+func answered(r *traceroute.Reply, err error) bool {
+	var ue *UnreachableError
+	return r != nil && (err == nil || errors.As(err, &ue))
+}
+
+// probeAnswer is what a pending probe receives: the reply, and unreach if
+// it was a Destination Unreachable.
+type probeAnswer struct {
+	reply   *traceroute.Reply
+	unreach *UnreachableError
+}
+
+// answer hands pr the reply from ip received at now, with unreach set to
+// the code of a Destination Unreachable. It drops replies once pr has
+// one. Port Unreachable from the destination is the expected answer of UDP
+// probes and not reported.
+func (pr *pendingProbe) answer(ip net.IP, now time.Time, unreach *UnreachableError) {
+	if unreach != nil {
+		unreach.IP = ip
+		if ip.Equal(pr.dst) && unreachableAnnotation(ip.To4() != nil, unreach.Code) == "!p" {
+			unreach = nil
+		}
+	}
+	select {
+	case pr.ch <- probeAnswer{&traceroute.Reply{IP: ip, RTT: now.Sub(pr.sent)}, unreach}:
+	default:
+	}
+}
+
+// result returns the reply and error of a probe with ttl answered with a.
+func (a probeAnswer) result(ttl int) (*traceroute.Reply, error) {
+	a.reply.Hops = ttl
+	if a.unreach != nil {
+		a.unreach.TTL = ttl
+		return a.reply, a.unreach
+	}
+	return a.reply, nil
+}
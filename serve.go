@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+	"github.com/SgtDaJim/op-mtr/mtr/mtrapi"
+)
+
+// runServe implements `op-mtr serve`: it runs MTRs on request over the HTTP
+// API of package mtrapi until interrupted.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8080", "address to serve the API on")
+	src := fs.String("src", "0.0.0.0", "source address to probe from")
+	count := fs.Int("count", 20, "default pings per hop")
+	ui := fs.Bool("ui", false, "serve the web UI at /")
+	hopName := fs.String("hop-name", "", "text/template naming hops in reports and the UI, e.g. '{{.ASN}} {{.Hostname | default .IP}}'")
+	interval := fs.Duration("interval", 0, "least time between the pings of a hop, e.g. 200ms")
+	rate := fs.Float64("rate", 0, "probes per second for all runs together, 0 for no limit")
+	rateBurst := fs.Int("rate-burst", 1, "probes that may leave at once within -rate")
+	baseline := fs.Bool("baseline", false, "also ping the destination end to end in every run and report it")
+	var limits mtrapi.Limits
+	fs.Float64Var(&limits.RunsPerMinute, "runs-per-minute", 30, "runs each client may start per minute with POST /mtr, 0 for no limit")
+	fs.IntVar(&limits.Burst, "runs-burst", 10, "runs each client may start at once within -runs-per-minute")
+	fs.IntVar(&limits.MaxRunsPerClient, "max-client-runs", 4, "runs each client may have in flight, 0 for no limit")
+	fs.IntVar(&limits.MaxRuns, "max-runs", 32, "runs in flight for all clients together, 0 for no limit")
+	fs.IntVar(&limits.MaxCount, "max-count", 1000, "pings per hop a run or monitor may ask for, 0 for no limit")
+	authFile := fs.String("auth", "", "JSON file mapping bearer tokens to roles (viewer, operator, admin); without it or -oidc-issuer anyone may do anything")
+	oidcIssuer := fs.String("oidc-issuer", "", "accept bearer tokens issued by this OpenID Connect issuer URL")
+	oidcAudience := fs.String("oidc-audience", "", "audience (client ID) that -oidc-issuer tokens must be issued for, required with it")
+	oidcRoleClaim := fs.String("oidc-role-claim", "roles", "token claim holding the caller's role (viewer, operator, admin)")
+	oidcCA := fs.String("oidc-ca", "", "PEM bundle of the CAs verifying -oidc-issuer (default: system roots)")
+	var tlsOpts mtr.TLSOptions
+	fs.StringVar(&tlsOpts.CertFile, "tls-cert", "", "PEM certificate to serve HTTPS with")
+	fs.StringVar(&tlsOpts.KeyFile, "tls-key", "", "PEM key of -tls-cert")
+	fs.StringVar(&tlsOpts.CAFile, "tls-client-ca", "", "PEM bundle of the CAs clients must present a certificate of")
+	fs.StringVar(&tlsOpts.MinVersion, "tls-min-version", "1.2", "lowest TLS version accepted, 1.2 or 1.3")
+	harden := fs.Bool("harden", false, "once started, deny system calls the daemon never makes, such as exec, ptrace and mount (Linux)")
+	insecure := fs.Bool("insecure", false, "allow serving a non-loopback address without TLS or without -auth/-oidc-issuer")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	opmtr, err := mtr.NewOPMTR(*src, mtr.WithPingCount(*count))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer opmtr.Close()
+	opmtr.Logger = mtr.StdLogger(log.New(os.Stderr, "", log.LstdFlags), mtr.LevelWarn)
+	opmtr.Interval = *interval
+	opmtr.Baseline = *baseline
+	if *rate > 0 {
+		opmtr.RateLimit = mtr.NewRateLimiter(*rate, *rateBurst)
+	}
+	if *hopName != "" {
+		if opmtr.HopNames, err = mtr.ParseHopTemplate(*hopName); err != nil {
+			fmt.Println("-hop-name:", err)
+			return
+		}
+	}
+	// keep paths in memory for GET /paths and the UI's timeline
+	opmtr.PathDB = mtr.NewPathDB()
+	api := mtrapi.NewServer(opmtr)
+	defer api.Close()
+	api.UI = *ui
+	api.Limits = limits
+	switch {
+	case *authFile != "" && *oidcIssuer != "":
+		fmt.Println("-auth and -oidc-issuer are mutually exclusive")
+		return
+	case *authFile != "":
+		auth, err := mtrapi.LoadStaticAuth(*authFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		api.Auth = auth
+	case *oidcIssuer != "" && *oidcAudience == "":
+		fmt.Println("-oidc-issuer needs -oidc-audience, or tokens issued for any client would be accepted")
+		return
+	case *oidcIssuer != "":
+		client, err := mtr.TLSOptions{CAFile: *oidcCA, MinVersion: tlsOpts.MinVersion}.HTTPClient()
+		if err != nil {
+			fmt.Println("-oidc-ca:", err)
+			return
+		}
+		api.Auth = &mtrapi.OIDCAuth{Issuer: *oidcIssuer, Audience: *oidcAudience, RoleClaim: *oidcRoleClaim, Client: client}
+	}
+	// Tokens sent in cleartext can be replayed, and without auth every
+	// caller is admin, so other hosts need both unless told otherwise.
+	if host, _, _ := net.SplitHostPort(*listen); !isLoopback(host) {
+		switch {
+		case !tlsOpts.Enabled() && !*insecure:
+			fmt.Printf("serving on %s needs -tls-cert and -tls-key, or -insecure to serve plain HTTP\n", *listen)
+			return
+		case api.Auth == nil && !*insecure:
+			fmt.Printf("serving on %s needs -auth or -oidc-issuer, or -insecure to let anyone run MTRs and manage monitors\n", *listen)
+			return
+		case *insecure:
+			fmt.Fprintf(os.Stderr, "warning: serving on %s with -insecure\n", *listen)
+		}
+	}
+	srv := &http.Server{Addr: *listen, Handler: api}
+	if tlsOpts.Enabled() {
+		if srv.TLSConfig, err = tlsOpts.ServerConfig(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	// end monitors, jobs and their event streams, which would hold up
+	// shutdown
+	srv.RegisterOnShutdown(api.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if *harden {
+		if err := mtr.Harden(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	serve := srv.ListenAndServe
+	if srv.TLSConfig != nil {
+		// the certificate is in TLSConfig
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != http.ErrServerClosed {
+		fmt.Println(err)
+	}
+}
+
+// isLoopback reports whether host, from a listen address, only accepts
+// local connections.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SgtDaJim/op-mtr/mtr"
+)
+
+// runTUI shows a live hop table for dst, refreshed every cycle like mtr.
+// Keys: p pauses and resumes probing, r resets the counters, n toggles
+// hostnames and q quits.
+func runTUI(ctx context.Context, opmtr *mtr.OPMTR, dst string, l mtr.Locale) error {
+	restore, err := cbreak(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\n")
+
+	keys := make(chan byte)
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(b); err != nil {
+				return
+			} else if n == 1 {
+				keys <- b[0]
+			}
+		}
+	}()
+
+	// short cycles keep the screen lively; counters accumulate across them
+	opmtr.PingCount = 5
+	showDNS := opmtr.PTR != nil
+	paused := false
+	var last mtr.MTRReport
+	for {
+		mctx, cancel := context.WithCancel(ctx)
+		m, err := opmtr.NewMonitor(mctx, dst, time.Second)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if paused {
+			m.Pause()
+		}
+		reports := m.Reports()
+		restart := false
+		for !restart {
+			select {
+			case r, ok := <-reports:
+				if !ok {
+					cancel()
+					return ctx.Err()
+				}
+				last = r
+				render(last, showDNS, paused, l)
+			case k := <-keys:
+				switch k {
+				case 'q', 'Q':
+					cancel()
+					return nil
+				case 'p', 'P':
+					paused = !paused
+					if paused {
+						m.Pause()
+					} else {
+						m.Resume()
+					}
+				case 'r', 'R':
+					m.ResetStats()
+					last.Hups = nil
+				case 'n', 'N':
+					showDNS = !showDNS
+					if showDNS && opmtr.PTR == nil {
+						// start over so no run races with the resolver being set
+						cancel()
+						<-drain(reports)
+						opmtr.PTR = mtr.NewPTRResolver(2*time.Second, mtr.DefaultPTRCacheSize)
+						restart = true
+					}
+				}
+				render(last, showDNS, paused, l)
+			}
+		}
+		cancel()
+		<-drain(reports)
+	}
+}
+
+// drain discards reports until the monitor closes the channel.
+func drain(reports <-chan mtr.MTRReport) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range reports {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func render(r mtr.MTRReport, showDNS, paused bool, l mtr.Locale) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	dst := r.Dst
+	if r.DstName != "" {
+		dst = fmt.Sprintf("%s (%s)", r.DstName, r.Dst)
+	}
+	state := ""
+	if paused {
+		state = "  [paused]"
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+	if l.TimeLayout != "" {
+		now = l.Time(time.Now())
+	}
+	fmt.Fprintf(&b, "op-mtr to %s  %s%s\n", dst, now, state)
+	fmt.Fprintf(&b, "Keys: p pause  r reset  n DNS  q quit\n\n")
+	fmt.Fprintf(&b, "%4s    %-30s %5s%%  %4s  %6s  %6s  %6s  %6s  %6s\n",
+		"HOP:|", "Host", "Loss", "Snt", "Last", "Avg", "Best", "Wrst", "StDev")
+	for _, h := range r.Hups {
+		host := h.Host
+		if showDNS {
+			host = h.DisplayName()
+		}
+		if h.Host == "???" {
+			fmt.Fprintf(&b, "%3d:|-- %-30s\n", h.Count, host)
+			continue
+		}
+		if a := h.Annotation(); a != "" {
+			host += " " + a
+		}
+		fmt.Fprintf(&b, "%3d:|-- %-30s %5s%%  %4s  %6s  %6s  %6s  %6s  %6s\n",
+			h.Count, host, l.Float(h.Loss*100, 1), l.Float(h.Snt, -1), l.Float(h.Last, 1), l.Float(h.Avg, 1),
+			l.Float(h.Best, 1), l.Float(h.Wrst, 1), l.Float(h.StDev, 1))
+	}
+	os.Stdout.WriteString(b.String())
+}
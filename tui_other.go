@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is only implemented on Linux.
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("the live display requires a Linux terminal")
+}